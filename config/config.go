@@ -17,12 +17,17 @@ type Config struct {
 	SignatureKey SignatureKeyConfig `mapstructure:"signature"`
 	JWT          JWTConfig          `mapstructure:"jwt"`
 	Logger       LoggerConfig       `mapstructure:"logger"`
+	SLA          SLAConfig          `mapstructure:"sla"`
+	Workflow     WorkflowConfig     `mapstructure:"workflow"`
+	Notification NotificationConfig `mapstructure:"notification"`
+	Webhook      WebhookConfig      `mapstructure:"webhook"`
 }
 
 type ServerConfig struct {
-	Name string `mapstructure:"name"`
-	Port string `mapstructure:"port"`
-	ENV  string `mapstructure:"env"`
+	Name           string   `mapstructure:"name"`
+	Port           string   `mapstructure:"port"`
+	ENV            string   `mapstructure:"env"`
+	TrustedProxies []string `mapstructure:"trusted_proxies"` // IP/CIDR của Reverse Proxy được phép set X-Forwarded-For
 }
 
 type DatabaseConfig struct {
@@ -53,6 +58,47 @@ type LoggerConfig struct {
 	Path  string `mapstructure:"path"`
 }
 
+type SLAConfig struct {
+	Enabled         bool `mapstructure:"enabled"`          // Xử lý task quá hạn. Tắt thì Scheduler vẫn chạy để trả task đã nhận và dọn Idempotency-Key
+	IntervalMinutes int  `mapstructure:"interval_minutes"` // Chu kỳ quét task quá hạn
+	// Task nhóm đã nhận (Claim) quá số phút này mà chưa xử lý -> Tự trả lại nhóm. 0 = Không tự trả
+	ClaimTimeoutMinutes int `mapstructure:"claim_timeout_minutes"`
+}
+
+type WorkflowConfig struct {
+	// Hành động luôn bắt buộc nhập ý kiến (ngoài các bước RequireComment). Không cấu hình = REJECT, RETURN
+	CommentRequiredActions []string `mapstructure:"comment_required_actions"`
+	// ServiceCode được duyệt/từ chối hàng loạt từ Inbox. Không cấu hình = Không quy trình nào được phép
+	BulkActionServiceCodes []string `mapstructure:"bulk_action_service_codes"`
+}
+
+type NotificationConfig struct {
+	Enabled         bool       `mapstructure:"enabled"`
+	IntervalSeconds int        `mapstructure:"interval_seconds"` // Chu kỳ gửi email trong hàng đợi
+	BatchSize       int        `mapstructure:"batch_size"`       // Số email tối đa mỗi lượt
+	MaxAttempts     int        `mapstructure:"max_attempts"`     // Gửi lỗi quá số lần này -> FAILED
+	DefaultLanguage string     `mapstructure:"default_language"` // vi, en, tw
+	AppURL          string     `mapstructure:"app_url"`          // Link mở đơn trong email: <app_url>/instances/<id>
+	SMTP            SMTPConfig `mapstructure:"smtp"`
+}
+
+type WebhookConfig struct {
+	Enabled         bool `mapstructure:"enabled"`
+	IntervalSeconds int  `mapstructure:"interval_seconds"` // Chu kỳ gửi webhook trong hàng đợi
+	BatchSize       int  `mapstructure:"batch_size"`
+	MaxAttempts     int  `mapstructure:"max_attempts"`    // Gửi lỗi quá số lần này -> FAILED (gửi lại thủ công được)
+	TimeoutSeconds  int  `mapstructure:"timeout_seconds"` // Timeout mỗi request
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"` // Trống = Không đăng nhập (VD: MailHog/Mailpit khi dev)
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+	FromName string `mapstructure:"from_name"`
+}
+
 type SignatureKeyConfig struct {
 	Secret string `mapstructure:"signature_key"`
 }
@@ -110,3 +156,84 @@ func (c *Config) GetERPDatabaseDSN() string {
 func (c *Config) GetJWTExpiry() time.Duration {
 	return time.Duration(c.JWT.ExpiryHour) * time.Hour
 }
+
+func (c *Config) GetSLAInterval() time.Duration {
+	if c.SLA.IntervalMinutes <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(c.SLA.IntervalMinutes) * time.Minute
+}
+
+func (c *Config) GetClaimTimeout() time.Duration {
+	if c.SLA.ClaimTimeoutMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(c.SLA.ClaimTimeoutMinutes) * time.Minute
+}
+
+func (c *Config) GetCommentRequiredActions() []string {
+	if c.Workflow.CommentRequiredActions == nil {
+		return []string{"REJECT", "RETURN"}
+	}
+	return c.Workflow.CommentRequiredActions
+}
+
+func (c *Config) GetBulkActionServiceCodes() []string {
+	return c.Workflow.BulkActionServiceCodes
+}
+
+func (c *Config) GetNotificationInterval() time.Duration {
+	if c.Notification.IntervalSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.Notification.IntervalSeconds) * time.Second
+}
+
+func (c *Config) GetNotificationBatchSize() int {
+	if c.Notification.BatchSize <= 0 {
+		return 50
+	}
+	return c.Notification.BatchSize
+}
+
+func (c *Config) GetNotificationMaxAttempts() int {
+	if c.Notification.MaxAttempts <= 0 {
+		return 5
+	}
+	return c.Notification.MaxAttempts
+}
+
+func (c *Config) GetWebhookInterval() time.Duration {
+	if c.Webhook.IntervalSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.Webhook.IntervalSeconds) * time.Second
+}
+
+func (c *Config) GetWebhookBatchSize() int {
+	if c.Webhook.BatchSize <= 0 {
+		return 50
+	}
+	return c.Webhook.BatchSize
+}
+
+func (c *Config) GetWebhookMaxAttempts() int {
+	if c.Webhook.MaxAttempts <= 0 {
+		return 8
+	}
+	return c.Webhook.MaxAttempts
+}
+
+func (c *Config) GetWebhookTimeout() time.Duration {
+	if c.Webhook.TimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.Webhook.TimeoutSeconds) * time.Second
+}
+
+func (c *Config) GetNotificationLanguage() string {
+	if c.Notification.DefaultLanguage == "" {
+		return "vi"
+	}
+	return c.Notification.DefaultLanguage
+}
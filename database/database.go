@@ -149,6 +149,8 @@ func runMigrations(db *gorm.DB) error {
 		&model.WorkflowInstance{},
 		&model.WorkflowTask{},
 		&model.WorkflowLog{},
+		&model.IdempotencyKey{},
+		&model.ReasonCode{},
 		// // 3. Hệ thống Chữ ký điện tử (Signature Trail)
 		// &models.DigitalSignature{},
 		// &models.SignatureTemplate{},
@@ -160,6 +162,17 @@ func runMigrations(db *gorm.DB) error {
 
 		&model.UserGroup{},
 		&model.UserGroupMember{},
-	// &models.WorkflowDelegation{},
+		&model.WorkflowDelegation{},
+
+		// 5. Lịch làm việc (SLA)
+		&model.Factory{},
+		&model.WorkingCalendar{},
+		&model.CalendarHoliday{},
+
+		// 6. Thông báo (Email)
+		&model.Notification{},
+		&model.NotificationPreference{},
+		&model.WebhookSubscription{},
+		&model.WebhookDelivery{},
 	)
 }
@@ -6,6 +6,7 @@ import (
 	"CQS-KYC/internal/handler"
 	"CQS-KYC/internal/repository"
 	"CQS-KYC/internal/service"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -29,6 +30,11 @@ type App struct {
 	database    database.Database
 	handlers    []handler.BaseHandler // Danh sách REST Handlers
 	soapHandler *handler.SOAPHandler  // Handler riêng cho ERP (SOAP)
+	slaService  service.SLAService    // Scheduler quét task quá hạn, trả task đã nhận, dọn Idempotency-Key
+
+	notificationService service.NotificationService // Gửi email trong hàng đợi
+	eventHub            service.EventHub            // Sự kiện realtime (SSE)
+	webhookService      service.WebhookService      // Gửi webhook trong hàng đợi
 }
 
 func New(cfg *config.Config, db database.Database) *App {
@@ -40,6 +46,13 @@ func New(cfg *config.Config, db database.Database) *App {
 	app.fiber = fiber.New(fiber.Config{
 		AppName:      cfg.Server.Name,
 		ErrorHandler: errorHandler,
+		// Lấy IP thật của client từ X-Forwarded-For, chỉ khi request đến từ proxy tin cậy
+		TrustProxy:         len(cfg.Server.TrustedProxies) > 0,
+		ProxyHeader:        fiber.HeaderXForwardedFor,
+		EnableIPValidation: true,
+		TrustProxyConfig: fiber.TrustProxyConfig{
+			Proxies: cfg.Server.TrustedProxies,
+		},
 	})
 
 	// --- Middlewares ---
@@ -67,23 +80,37 @@ func New(cfg *config.Config, db database.Database) *App {
 	positionRepo := repository.NewPositionRepo(gormDB)
 	factoryRepo := repository.NewFactoryRepo(gormDB)
 	groupRepo := repository.NewGroupRepo(gormDB)
+	delegationRepo := repository.NewDelegationRepo(gormDB)
+	calendarRepo := repository.NewCalendarRepo(gormDB)
+	reasonCodeRepo := repository.NewReasonCodeRepo(gormDB)
+	notificationRepo := repository.NewNotificationRepo(gormDB)
+	webhookRepo := repository.NewWebhookRepo(gormDB)
 
 	wfDefRepo := repository.NewWorkflowRepo(gormDB)
 
-	// Engine cần: DB, GroupRepo (để tìm nhóm), SignatureHelper (để ký)
-	instanceRepo := repository.NewWorkflowEngine(gormDB, groupRepo, *sigHelper)
+	// Engine cần: DB, GroupRepo (để tìm nhóm), DelegationRepo (duyệt thay), DueDateCalculator (SLA), SignatureHelper (để ký)
+	dueDateCalc := repository.NewCalendarCalculator(calendarRepo) // Theo lịch làm việc của nhà máy
+	eventHub := service.NewEventHub()                             // Engine phát sự kiện -> Kết nối realtime
+	instanceRepo := repository.NewWorkflowEngine(gormDB, groupRepo, delegationRepo, dueDateCalc, *sigHelper, cfg.GetCommentRequiredActions(), cfg.Notification.Enabled, cfg.Webhook.Enabled, eventHub)
 
 	// 3. Services
 	// Service quản lý định nghĩa quy trình (CRUD Workflow)
-	wfDefService := service.NewWorkflowService(wfDefRepo)
+	wfDefService := service.NewWorkflowService(wfDefRepo, instanceRepo, userRepo, groupRepo, departmentRepo, factoryRepo)
 	departmentService := service.NewDepartmentSerivce(departmentRepo)
 	managerService := service.NewManagerService(managerRepo)
 	positionService := service.NewPositionService(positionRepo)
 	// Service quản lý chạy luồng (Engine)
-	instanceService := service.NewInstanceService(instanceRepo, gormDB)
+	instanceService := service.NewInstanceService(instanceRepo, userRepo, sigHelper, cfg.GetBulkActionServiceCodes(), gormDB)
 	groupService := service.NewGroupService(groupRepo)
 	userService := service.NewUserService(userRepo)
 	factoryService := service.NewFactoryService(factoryRepo)
+	delegationService := service.NewDelegationService(delegationRepo, userRepo)
+	slaService := service.NewSLAService(instanceRepo, cfg.SLA.Enabled, cfg.GetSLAInterval(), cfg.GetClaimTimeout())
+	calendarService := service.NewCalendarService(calendarRepo, factoryRepo, dueDateCalc)
+	reasonCodeService := service.NewReasonCodeService(reasonCodeRepo)
+	webhookService := service.NewWebhookService(webhookRepo, userRepo, cfg)
+	realtimeService := service.NewRealtimeService(eventHub, groupRepo, delegationRepo, cfg.SignatureKey.Secret)
+	notificationService := service.NewNotificationService(notificationRepo, userRepo, departmentRepo, service.NewSMTPMailer(cfg.Notification.SMTP), cfg)
 	// Service ERP (Cầu nối)
 	erpService := service.NewERPService(app.database, cfg, userRepo, wfDefService, instanceService)
 
@@ -97,6 +124,12 @@ func New(cfg *config.Config, db database.Database) *App {
 	userHandler := handler.NewUserHandler(userService)
 	groupHandler := handler.NewGroupHandler(groupService)
 	factoryHandler := handler.NewFactoryHandler(factoryService)
+	delegationHandler := handler.NewDelegationHandler(delegationService)
+	calendarHandler := handler.NewCalendarHandler(calendarService)
+	reasonCodeHandler := handler.NewReasonCodeHandler(reasonCodeService)
+	notificationHandler := handler.NewNotificationHandler(notificationService)
+	eventHandler := handler.NewEventHandler(realtimeService)
+	webhookHandler := handler.NewWebhookHandler(webhookService)
 	// Handler cho SOAP API (ERP gọi)
 	soapHandler := handler.NewSOAPHandler(erpService)
 
@@ -109,8 +142,23 @@ func New(cfg *config.Config, db database.Database) *App {
 		departmentHandler,
 		managerHandler,
 		positionHandler,
+		delegationHandler,
+		calendarHandler,
+		reasonCodeHandler,
+		notificationHandler,
+		eventHandler,
+		webhookHandler,
 	}
+	app.eventHub = eventHub
 	app.soapHandler = soapHandler
+	// Luôn chạy: Dọn Idempotency-Key và trả task nhận quá lâu không phụ thuộc sla.enabled
+	app.slaService = slaService
+	if cfg.Notification.Enabled {
+		app.notificationService = notificationService
+	}
+	if cfg.Webhook.Enabled {
+		app.webhookService = webhookService
+	}
 	return app
 }
 
@@ -172,9 +220,26 @@ func (a *App) Start() {
 	log.Printf("📡 SOAP Endpoint: http://localhost:%s/EFNETService/EFERPService.asmx", a.config.Server.Port)
 	log.Printf("🔌 REST API: http://localhost:%s/api", a.config.Server.Port)
 
+	// Background jobs
+	bgCtx, stopJobs := context.WithCancel(context.Background())
+	if a.slaService != nil {
+		go a.slaService.Start(bgCtx)
+		log.Printf("⏰ SLA Scheduler started (every %s, overdue handling: %t)", a.config.GetSLAInterval(), a.config.SLA.Enabled)
+	}
+	if a.notificationService != nil {
+		go a.notificationService.Start(bgCtx)
+		log.Printf("✉️  Email notifications started (every %s, SMTP %s:%d)", a.config.GetNotificationInterval(), a.config.Notification.SMTP.Host, a.config.Notification.SMTP.Port)
+	}
+	if a.webhookService != nil {
+		go a.webhookService.Start(bgCtx)
+		log.Printf("🔗 Webhook dispatcher started (every %s)", a.config.GetWebhookInterval())
+	}
+
 	// Block main thread until signal received
 	<-sigChan
 	log.Println("Shutting down server...")
+	stopJobs()
+	a.eventHub.Close() // Đóng các kết nối SSE để Shutdown không phải chờ
 
 	if err := a.database.Close(); err != nil {
 		log.Printf("Error closing database connection: %v", err)
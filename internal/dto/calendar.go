@@ -0,0 +1,68 @@
+package dto
+
+import "time"
+
+type CalendarShift struct {
+	Start string `json:"start"` // HH:MM
+	End   string `json:"end"`   // HH:MM
+}
+
+type CalendarCreate struct {
+	FactoryID uint64          `json:"factory_id" validate:"required"`
+	Name      string          `json:"name"`
+	Timezone  string          `json:"timezone"` // Mặc định Asia/Ho_Chi_Minh
+	WorkDays  []int           `json:"work_days"`
+	Shifts    []CalendarShift `json:"shifts"`
+}
+
+type CalendarUpdate struct {
+	Name     *string         `json:"name"`
+	Timezone *string         `json:"timezone"`
+	WorkDays []int           `json:"work_days"`
+	Shifts   []CalendarShift `json:"shifts"`
+	IsActive *bool           `json:"is_active"`
+}
+
+type CalendarHolidayReq struct {
+	Date         string `json:"date" validate:"required"` // YYYY-MM-DD
+	Name         string `json:"name"`
+	IsWorkingDay bool   `json:"is_working_day"`
+}
+
+type CalendarHolidayRes struct {
+	ID           uint64 `json:"id"`
+	Date         string `json:"date"`
+	Name         string `json:"name"`
+	IsWorkingDay bool   `json:"is_working_day"`
+	Source       string `json:"source"`
+}
+
+type CalendarRes struct {
+	ID        uint64               `json:"id"`
+	FactoryID uint64               `json:"factory_id"`
+	Name      string               `json:"name"`
+	Timezone  string               `json:"timezone"`
+	WorkDays  []int                `json:"work_days"`
+	Shifts    []CalendarShift      `json:"shifts"`
+	IsActive  bool                 `json:"is_active"`
+	Holidays  []CalendarHolidayRes `json:"holidays,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+type CalendarImportRes struct {
+	Imported int `json:"imported"`
+}
+
+// Tính thử hạn xử lý / số giờ làm việc theo lịch của nhà máy
+type CalendarCalcReq struct {
+	FactoryID uint64     `json:"factory_id" validate:"required"`
+	Start     time.Time  `json:"start" validate:"required"`
+	Hours     int        `json:"hours"` // Cộng N giờ làm việc -> DueDate
+	End       *time.Time `json:"end"`   // Hoặc: đếm giờ làm việc giữa Start và End
+}
+
+type CalendarCalcRes struct {
+	DueDate      *time.Time `json:"due_date,omitempty"`
+	WorkingHours *float64   `json:"working_hours,omitempty"`
+}
@@ -0,0 +1,34 @@
+package dto
+
+import "time"
+
+type DelegationCreate struct {
+	DelegatorID  string    `json:"delegator_id"` // Bỏ trống = người đang đăng nhập, khác người đăng nhập -> Chỉ Admin
+	DelegateID   string    `json:"delegate_id" validate:"required"`
+	StartDate    time.Time `json:"start_date" validate:"required"`
+	EndDate      time.Time `json:"end_date" validate:"required"`
+	ServiceCodes []string  `json:"service_codes"`
+	Reason       string    `json:"reason"`
+}
+
+type DelegationUpdate struct {
+	DelegateID   *string    `json:"delegate_id"`
+	StartDate    *time.Time `json:"start_date"`
+	EndDate      *time.Time `json:"end_date"`
+	ServiceCodes []string   `json:"service_codes"`
+	Reason       *string    `json:"reason"`
+	IsActive     *bool      `json:"is_active"`
+}
+
+type DelegationRes struct {
+	ID           uint64    `json:"id"`
+	DelegatorID  string    `json:"delegator_id"`
+	DelegateID   string    `json:"delegate_id"`
+	StartDate    time.Time `json:"start_date"`
+	EndDate      time.Time `json:"end_date"`
+	ServiceCodes []string  `json:"service_codes"`
+	Reason       string    `json:"reason"`
+	IsActive     bool      `json:"is_active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
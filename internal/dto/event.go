@@ -0,0 +1,10 @@
+package dto
+
+import "time"
+
+// Vé mở kết nối realtime (EventSource không gửi được header x-user-id)
+type EventTicketRes struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+	StreamURL string    `json:"stream_url"` // GET, dạng text/event-stream
+}
@@ -1,6 +1,9 @@
 package dto
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // 1. Request tạo đơn mới (ERP gửi sang)
 type WorkflowInitiateReq struct {
@@ -15,7 +18,107 @@ type WorkflowInitiateReq struct {
 
 // 2. Request Duyệt/Từ chối
 type WorkflowActionReq struct {
-	Action  string `json:"action" validate:"required,oneof=APPROVE REJECT"`
+	Action     string `json:"action" validate:"required,oneof=APPROVE REJECT RETURN"`
+	Comment    string `json:"comment"`     // Bắt buộc khi REJECT/RETURN hoặc bước có RequireComment
+	ReasonCode string `json:"reason_code"` // Mã lý do REJECT/RETURN (GET /api/reason-codes?service_code=)
+}
+
+type WorkflowActionRes struct {
+	InstanceID  uint64 `json:"instance_id"`
+	Action      string `json:"action"`
+	Status      string `json:"status"`       // Trạng thái đơn sau khi xử lý
+	CurrentStep int    `json:"current_step"` // Bước hiện tại sau khi xử lý
+	Version     int    `json:"version,omitempty"`
+	Replayed    bool   `json:"replayed"` // True = Request gửi lại (cùng Idempotency-Key), trả kết quả lần đầu
+	// Log chữ ký của thao tác (Replayed -> Chữ ký của lần xử lý đầu)
+	LogID         uint64 `json:"log_id,omitempty"`
+	SignatureHash string `json:"signature_hash,omitempty"`
+}
+
+// 2.1 Request chuyển task cho người/nhóm khác (Forward)
+type WorkflowForwardReq struct {
+	ToUserID    string `json:"to_user_id"`    // Chọn 1 trong 2
+	ToGroupCode string `json:"to_group_code"` // Chọn 1 trong 2
+	Comment     string `json:"comment"`
+}
+
+// 2.2 Request Admin chuyển toàn bộ task từ User A sang User B
+type WorkflowReassignReq struct {
+	FromUserID  string `json:"from_user_id" validate:"required"`
+	ToUserID    string `json:"to_user_id" validate:"required"`
+	ServiceCode string `json:"service_code"` // Bỏ trống = tất cả loại đơn
+	Comment     string `json:"comment"`
+}
+
+type WorkflowReassignRes struct {
+	MovedTasks       int      `json:"moved_tasks"`
+	ReleasedClaims   int      `json:"released_claims"`   // Task nhóm người cũ đã nhận -> Trả lại cho nhóm
+	SkippedInstances []uint64 `json:"skipped_instances"` // Người nhận đã có task trên đơn -> Cần xử lý riêng
+}
+
+// 2.3 Request Admin chuyển đơn đang chạy sang version quy trình mới
+type WorkflowMigrateReq struct {
+	FromWorkflowID uint64         `json:"from_workflow_id" validate:"required"`
+	ToWorkflowID   uint64         `json:"to_workflow_id" validate:"required"`
+	InstanceIDs    []uint64       `json:"instance_ids"` // Bỏ trống = Tất cả đơn đang chạy của from_workflow_id
+	StepMapping    map[string]int `json:"step_mapping"` // StepOrder cũ -> StepOrder mới. Bỏ trống = Map theo StepCode
+	Comment        string         `json:"comment"`
+}
+
+type WorkflowMigrateRes struct {
+	Migrated int                      `json:"migrated"`
+	Failed   int                      `json:"failed"`
+	Results  []WorkflowMigrateItemRes `json:"results"`
+}
+
+type WorkflowMigrateItemRes struct {
+	InstanceID uint64 `json:"instance_id"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 2.8 Request duyệt/từ chối hàng loạt (cùng Action/Comment cho mọi đơn)
+type WorkflowBulkActionReq struct {
+	InstanceIDs []uint64 `json:"instance_ids"`
+	Action      string   `json:"action"` // APPROVE, REJECT
+	Comment     string   `json:"comment"`
+	ReasonCode  string   `json:"reason_code"`
+}
+
+type WorkflowBulkActionRes struct {
+	Succeeded int                         `json:"succeeded"`
+	Failed    int                         `json:"failed"`
+	Results   []WorkflowBulkActionItemRes `json:"results"` // Theo thứ tự instance_ids
+}
+
+type WorkflowBulkActionItemRes struct {
+	InstanceID    uint64 `json:"instance_id"`
+	Success       bool   `json:"success"`
+	Status        string `json:"status,omitempty"`
+	CurrentStep   int    `json:"current_step,omitempty"`
+	LogID         uint64 `json:"log_id,omitempty"`
+	SignatureHash string `json:"signature_hash,omitempty"` // Chữ ký riêng của đơn này
+	Replayed      bool   `json:"replayed,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// 2.4 Request Admin bỏ qua bước hiện tại (bước phải cho phép Canskip)
+type WorkflowSkipReq struct {
+	Comment string `json:"comment"`
+}
+
+// 2.7 Request xác nhận CC / Đồng ký (Đồng ký bắt buộc Comment)
+type WorkflowAcknowledgeReq struct {
+	Comment string `json:"comment"`
+}
+
+type WorkflowAcknowledgeRes struct {
+	InstanceID uint64 `json:"instance_id"`
+	Action     string `json:"action"` // ACKNOWLEDGE hoặc COUNTERSIGN
+}
+
+// 2.6 Request Hủy đơn (người tạo hoặc Admin). Đơn con đang chạy bị hủy theo
+type WorkflowCancelReq struct {
 	Comment string `json:"comment"`
 }
 
@@ -27,16 +130,240 @@ type PendingTaskRes struct {
 	DocType     string    `json:"doc_type"`
 	ServiceCode string    `json:"service_code"`
 	StepName    string    `json:"step_name"`
+	StepType    string    `json:"step_type"` // APPROVAL, hoặc NOTIFY/COUNTERSIGN ở mục CC
 	Status      string    `json:"status"`
 	ReceivedAt  time.Time `json:"received_at"`
 	CreatorID   string    `json:"creator_id"`
+	// Có giá trị nếu task được ủy quyền (duyệt thay người này)
+	DelegatedFrom string `json:"delegated_from,omitempty"`
+	// Task nhóm đã có người nhận
+	ClaimedBy string     `json:"claimed_by,omitempty"`
+	ClaimedAt *time.Time `json:"claimed_at,omitempty"`
+	DueDate   *time.Time `json:"due_date,omitempty"`
+	Overdue   bool       `json:"overdue"`
+}
+
+// 3.3 Request: Tìm kiếm Inbox (GET /api/instance/tasks/search)
+type TaskSearchReq struct {
+	DocNum      string `query:"doc_num"` // Tìm theo mã đơn (chứa chuỗi)
+	ServiceCode string `query:"service_code"`
+	DocType     string `query:"doc_type"`
+	CreatorID   string `query:"creator_id"`
+	FactoryID   uint64 `query:"factory_id"`
+	From        string `query:"from"`    // Ngày nhận từ (YYYY-MM-DD)
+	To          string `query:"to"`      // Ngày nhận đến (YYYY-MM-DD, tính trọn ngày)
+	Overdue     string `query:"overdue"` // "true" / "false" / trống = tất cả
+	Sort        string `query:"sort"`    // received_at (mặc định), due_date
+	Order       string `query:"order"`   // desc (mặc định với received_at), asc (mặc định với due_date)
+	Cursor      string `query:"cursor"`  // next_cursor của trang trước
+	Limit       int    `query:"limit"`   // Mặc định 20, tối đa 100
+}
+
+// 3.3 Response: Kết quả tìm kiếm Inbox
+type TaskSearchRes struct {
+	Items      []PendingTaskRes `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"` // Trống = Hết dữ liệu
+	Total      int64            `json:"total"`                 // Tổng số task khớp bộ lọc
+	Counts     []TaskCountRes   `json:"counts"`                // Theo từng quy trình (không áp dụng lọc service_code)
+}
+
+type TaskCountRes struct {
+	ServiceCode string `json:"service_code"`
+	Total       int64  `json:"total"`
+	Overdue     int64  `json:"overdue"`
+}
+
+// 3.1 Response: Task đã xử lý
+type CompletedTaskRes struct {
+	TaskID      uint64     `json:"task_id"`
+	InstanceID  uint64     `json:"instance_id"`
+	DocNum      string     `json:"doc_num"`
+	DocType     string     `json:"doc_type"`
+	ServiceCode string     `json:"service_code"`
+	StepName    string     `json:"step_name"`
+	Status      string     `json:"status"`
+	Outcome     string     `json:"outcome"`
+	AssignedTo  string     `json:"assigned_to"`
+	IsGroup     bool       `json:"is_group"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// Thời gian task nằm chờ (giây)
+	DurationSeconds int64 `json:"duration_seconds"`
 }
 
 // 4. Response: Chi tiết lịch sử (History)
 type WorkflowLogRes struct {
-	StepName  string    `json:"step_name"`
-	Action    string    `json:"action"`
-	ActorName string    `json:"actor_name"`
-	Comment   string    `json:"comment"`
-	Time      time.Time `json:"time"`
+	ID         uint64 `json:"id"`
+	InstanceID uint64 `json:"instance_id,omitempty"` // Chỉ có trong lịch sử gộp đơn cha/con
+	StepOrder  int    `json:"step_order"`
+	StepName   string `json:"step_name"`
+	Action     string `json:"action"`
+	ActorID    string `json:"actor_id"`
+	ActorName  string `json:"actor_name"`
+	// Duyệt thay cho ai (Ủy quyền)
+	OnBehalfOfID string    `json:"on_behalf_of_id,omitempty"`
+	TargetID     string    `json:"target_id,omitempty"` // FORWARD/REASSIGN: Người/nhóm nhận task
+	Comment      string    `json:"comment"`
+	ReasonCode   string    `json:"reason_code,omitempty"` // Lý do REJECT/RETURN
+	Time         time.Time `json:"time"`
+
+	// --- CHỮ KÝ & NƠI THAO TÁC ---
+	SignatureHash    string `json:"signature_hash"`
+	DataSnapshotHash string `json:"data_snapshot_hash"`
+	SignedTimestamp  int64  `json:"signed_timestamp"`
+	SignatureStatus  string `json:"signature_status,omitempty"` // SIGNATURE_* (chỉ có trong timeline)
+	IPAddress        string `json:"ip_address"`
+	DeviceInfo       string `json:"device_info"`
+	DeviceID         string `json:"device_id"`
+}
+
+// Kết quả kiểm tra chữ ký log
+const (
+	SIGNATURE_VALID    = "VALID"    // Khớp HMAC
+	SIGNATURE_INVALID  = "INVALID"  // Log bị sửa sau khi ký
+	SIGNATURE_UNSIGNED = "UNSIGNED" // Log không có chữ ký
+)
+
+// Lịch sử gộp của đơn cha và các đơn con (Sub-workflow)
+type CombinedHistoryRes struct {
+	RootInstanceID uint64              `json:"root_instance_id"`
+	Instances      []LinkedInstanceRes `json:"instances"`
+	Logs           []WorkflowLogRes    `json:"logs"` // Theo thời gian, mọi đơn trong cây
+}
+
+type LinkedInstanceRes struct {
+	ID               uint64     `json:"id"`
+	ParentInstanceID *uint64    `json:"parent_instance_id"`
+	ParentStepOrder  int        `json:"parent_step_order,omitempty"`
+	ServiceCode      string     `json:"service_code"`
+	DocNum           string     `json:"doc_num"`
+	Status           string     `json:"status"`
+	CurrentStep      int        `json:"current_step"`
+	StartedAt        time.Time  `json:"started_at"`
+	CompletedAt      *time.Time `json:"completed_at"`
+}
+
+// 6. Request: Danh sách đơn (GET /api/instance). User thường chỉ thấy đơn mình tạo / tham gia duyệt
+type InstanceListReq struct {
+	Status       string `query:"status"`
+	CreatorID    string `query:"creator_id"`
+	ServiceCode  string `query:"service_code"`
+	FactoryID    uint64 `query:"factory_id"`
+	DepartmentID uint64 `query:"department_id"`
+	DocNum       string `query:"doc_num"` // Chứa chuỗi
+	From         string `query:"from"`    // Ngày tạo từ (YYYY-MM-DD)
+	To           string `query:"to"`      // Ngày tạo đến (YYYY-MM-DD, tính trọn ngày)
+	Cursor       string `query:"cursor"`  // next_cursor của trang trước
+	Limit        int    `query:"limit"`   // Mặc định 20, tối đa 100
+}
+
+type InstanceListRes struct {
+	Items      []InstanceSummaryRes `json:"items"`
+	NextCursor string               `json:"next_cursor,omitempty"` // Trống = Hết dữ liệu
+	Total      int64                `json:"total"`
+}
+
+type InstanceSummaryRes struct {
+	ID              uint64     `json:"id"`
+	DocNum          string     `json:"doc_num"`
+	DocType         string     `json:"doc_type"`
+	ServiceCode     string     `json:"service_code"`
+	WorkflowName    string     `json:"workflow_name"`
+	WorkflowVersion int        `json:"workflow_version"`
+	Status          string     `json:"status"`
+	CurrentStep     int        `json:"current_step"`
+	TotalSteps      int        `json:"total_steps"`
+	Progress        int        `json:"progress"` // % số bước đã qua
+	CreatorID       string     `json:"creator_id"`
+	FactoryID       uint64     `json:"factory_id"`
+	DepartmentID    uint64     `json:"department_id"`
+	StartedAt       time.Time  `json:"started_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+}
+
+// 6.1 Response: Chi tiết đơn (GET /api/instance/:id)
+type InstanceDetailRes struct {
+	InstanceSummaryRes
+	ParentInstanceID *uint64              `json:"parent_instance_id,omitempty"`
+	RequestData      json.RawMessage      `json:"request_data"`
+	Steps            []InstanceStepRes    `json:"steps"`
+	CurrentAssignees []CurrentAssigneeRes `json:"current_assignees"` // Task đang mở ở bước hiện tại
+	Logs             []WorkflowLogRes     `json:"logs"`
+}
+
+// Bước trong quy trình của đơn (theo version đơn đang chạy)
+type InstanceStepRes struct {
+	StepOrder int    `json:"step_order"`
+	StepCode  string `json:"step_code"`
+	StepName  string `json:"step_name"`
+	StepType  string `json:"step_type"`
+	State     string `json:"state"` // STEP_STATE_*, hoặc REJECTED/CANCELLED ở bước đơn kết thúc
+}
+
+const (
+	STEP_STATE_DONE        = "DONE"        // Đã qua
+	STEP_STATE_SKIPPED     = "SKIPPED"     // Bị bỏ qua (Admin / Điều kiện không thỏa)
+	STEP_STATE_CURRENT     = "CURRENT"     // Đang chờ xử lý
+	STEP_STATE_UPCOMING    = "UPCOMING"    // Chưa đến
+	STEP_STATE_NOT_REACHED = "NOT_REACHED" // Đơn đã kết thúc (Từ chối/Hủy) trước khi đến bước này
+)
+
+type CurrentAssigneeRes struct {
+	TaskID     uint64     `json:"task_id"`
+	AssignedTo string     `json:"assigned_to"` // UserID hoặc GroupCode
+	Name       string     `json:"name,omitempty"`
+	IsGroup    bool       `json:"is_group"`
+	Status     string     `json:"status"`
+	ClaimedBy  string     `json:"claimed_by,omitempty"`
+	DueDate    *time.Time `json:"due_date,omitempty"`
+}
+
+// 7. Response: Timeline đơn (GET /api/instance/:id/timeline)
+type InstanceTimelineRes struct {
+	InstanceID       uint64               `json:"instance_id"`
+	DocNum           string               `json:"doc_num"`
+	Status           string               `json:"status"`
+	CurrentStep      int                  `json:"current_step"`
+	Events           []TimelineEventRes   `json:"events"`            // Log + Task theo thời gian
+	Steps            []TimelineStepRes    `json:"steps"`             // Mọi bước của quy trình, kể cả bước chưa đến
+	PendingAssignees []CurrentAssigneeRes `json:"pending_assignees"` // Người đang phải xử lý bước hiện tại
+}
+
+// Loại sự kiện trên Timeline
+const (
+	TIMELINE_LOG           = "LOG"           // Thao tác (Duyệt, Từ chối, Chuyển...) - xem Log
+	TIMELINE_TASK_ASSIGNED = "TASK_ASSIGNED" // Giao task - xem Task
+	TIMELINE_TASK_CLOSED   = "TASK_CLOSED"   // Task tự đóng không có thao tác (đủ người duyệt, hủy, quá hạn) - xem Task
+)
+
+type TimelineEventRes struct {
+	Type      string           `json:"type"` // TIMELINE_*
+	Time      time.Time        `json:"time"`
+	StepOrder int              `json:"step_order"`
+	StepName  string           `json:"step_name"`
+	Log       *WorkflowLogRes  `json:"log,omitempty"`
+	Task      *TimelineTaskRes `json:"task,omitempty"`
+}
+
+type TimelineTaskRes struct {
+	TaskID      uint64     `json:"task_id"`
+	AssignedTo  string     `json:"assigned_to"`
+	IsGroup     bool       `json:"is_group"`
+	Status      string     `json:"status"`
+	Outcome     string     `json:"outcome,omitempty"`
+	CompletedBy string     `json:"completed_by,omitempty"`
+	DueDate     *time.Time `json:"due_date,omitempty"`
+}
+
+// Thời gian đơn nằm ở từng bước (bước bị RETURN đi lại được cộng dồn qua các lượt)
+type TimelineStepRes struct {
+	StepOrder       int        `json:"step_order"`
+	StepCode        string     `json:"step_code"`
+	StepName        string     `json:"step_name"`
+	StepType        string     `json:"step_type"`
+	State           string     `json:"state"`  // Như InstanceStepRes.State
+	Visits          int        `json:"visits"` // Số lượt đơn vào bước này
+	EnteredAt       *time.Time `json:"entered_at,omitempty"`
+	LeftAt          *time.Time `json:"left_at,omitempty"` // Trống nếu đang ở bước này / chưa đến
+	DurationSeconds int64      `json:"duration_seconds"`
 }
@@ -0,0 +1,29 @@
+package dto
+
+import "time"
+
+// Cập nhật cài đặt nhận thông báo (trường nil = giữ nguyên)
+type NotificationPreferenceReq struct {
+	Language     *string  `json:"language"`      // vi, en, tw. "" = Mặc định hệ thống
+	EmailEnabled *bool    `json:"email_enabled"` // false = Tắt toàn bộ email
+	MutedEvents  []string `json:"muted_events"`  // Sự kiện không muốn nhận (nil = giữ nguyên, [] = nhận tất cả)
+}
+
+type NotificationPreferenceRes struct {
+	UserID       string    `json:"user_id"`
+	Language     string    `json:"language"`
+	EmailEnabled bool      `json:"email_enabled"`
+	MutedEvents  []string  `json:"muted_events"`
+	Events       []string  `json:"events"` // Các sự kiện có thể tắt/bật
+	UpdatedAt    time.Time `json:"updated_at,omitempty"`
+}
+
+// Gửi thử email (kiểm tra cấu hình SMTP), mặc định gửi đến email của chính User
+type NotificationTestReq struct {
+	Event string `json:"event"` // Trống = TASK_ASSIGNED
+}
+
+type NotificationTestRes struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+}
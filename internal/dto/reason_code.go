@@ -0,0 +1,47 @@
+package dto
+
+import "time"
+
+type ReasonCodeCreate struct {
+	ServiceCode string   `json:"service_code"` // Trống = Dùng chung mọi quy trình
+	Code        string   `json:"code" binding:"required"`
+	Label       string   `json:"label" binding:"required"`
+	Actions     []string `json:"actions"` // REJECT, RETURN (trống = cả 2)
+	SortOrder   int      `json:"sort_order"`
+}
+
+type ReasonCodeUpdate struct {
+	Label     *string  `json:"label"`
+	Actions   []string `json:"actions"`
+	SortOrder *int     `json:"sort_order"`
+	IsActive  *bool    `json:"is_active"`
+}
+
+type ReasonCodeResponse struct {
+	ID          uint64   `json:"id"`
+	ServiceCode string   `json:"service_code"`
+	Code        string   `json:"code"`
+	Label       string   `json:"label"`
+	Actions     []string `json:"actions"`
+	SortOrder   int      `json:"sort_order"`
+	IsActive    bool     `json:"is_active"`
+}
+
+type ReasonCodeID struct {
+	ID uint64 `json:"id" uri:"id" binding:"required"`
+}
+
+// Báo cáo số lần Từ chối/Trả về theo lý do
+type ReasonReportRes struct {
+	ServiceCode string            `json:"service_code,omitempty"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Items       []ReasonReportRow `json:"items"`
+}
+
+type ReasonReportRow struct {
+	Action     string `json:"action"`
+	ReasonCode string `json:"reason_code"` // Trống = Không chọn lý do (trước khi có danh mục)
+	Label      string `json:"label"`
+	Total      int64  `json:"total"`
+}
@@ -0,0 +1,70 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type WebhookCreateReq struct {
+	Name         string   `json:"name"`
+	URL          string   `json:"url"`           // http(s)://...
+	Secret       string   `json:"secret"`        // Trống = Hệ thống tự sinh (chỉ trả về 1 lần)
+	Events       []string `json:"events"`        // Trống = Tất cả sự kiện
+	ServiceCodes []string `json:"service_codes"` // Trống = Mọi quy trình
+	IsActive     *bool    `json:"is_active"`     // Mặc định true
+}
+
+// Trường nil = Giữ nguyên. Secret = "" -> Sinh khóa mới
+type WebhookUpdateReq struct {
+	Name         *string  `json:"name"`
+	URL          *string  `json:"url"`
+	Secret       *string  `json:"secret"`
+	Events       []string `json:"events"`
+	ServiceCodes []string `json:"service_codes"`
+	IsActive     *bool    `json:"is_active"`
+}
+
+type WebhookRes struct {
+	ID           uint64    `json:"id"`
+	Name         string    `json:"name"`
+	URL          string    `json:"url"`
+	Events       []string  `json:"events"`
+	ServiceCodes []string  `json:"service_codes"`
+	IsActive     bool      `json:"is_active"`
+	Secret       string    `json:"secret,omitempty"` // Chỉ có khi tạo mới / đổi khóa
+	SecretHint   string    `json:"secret_hint"`      // 4 ký tự cuối
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type WebhookDeliveryListReq struct {
+	InstanceID uint64 `query:"instance_id"`
+	Status     string `query:"status"` // PENDING, SUCCESS, FAILED, CANCELLED
+	Cursor     string `query:"cursor"` // next_cursor của trang trước
+	Limit      int    `query:"limit"`  // Mặc định 20, tối đa 100
+}
+
+type WebhookDeliveryListRes struct {
+	Items      []WebhookDeliveryRes `json:"items"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+type WebhookDeliveryRes struct {
+	ID             uint64          `json:"id"`
+	SubscriptionID uint64          `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	InstanceID     uint64          `json:"instance_id"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"` // Chỉ khi đang chờ gửi
+	ResponseStatus int             `json:"response_status,omitempty"`
+	ResponseBody   string          `json:"response_body,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DurationMs     int64           `json:"duration_ms"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	RedeliveryOf   *uint64         `json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Payload        json.RawMessage `json:"payload,omitempty"` // Chỉ có ở API chi tiết
+}
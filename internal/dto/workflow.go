@@ -1,11 +1,14 @@
 package dto
 
+import "time"
+
 type WorkflowDefinitionRes struct {
 	ID           uint64            `json:"id" uri:"id"`
 	ServiceCode  string            `json:"service_code"`
 	Operation    string            `json:"operation"`
 	WorkflowName string            `json:"workflow_name"`
 	Description  string            `json:"description"`
+	Version      int               `json:"version"`
 	IsActive     bool              `json:"is_active"`
 	Steps        []WorkflowStepRes `json:"steps"`
 }
@@ -16,11 +19,17 @@ type WorkflowStepRes struct {
 	StepCode             string                      `json:"step_code"`
 	StepName             string                      `json:"step_name"`
 	StepOrder            int                         `json:"step_order"`
+	StepType             string                      `json:"step_type"`
 	RequiredRole         string                      `json:"required_role"`
 	Canskip              bool                        `json:"can_skip"`
 	CanDelegate          bool                        `json:"can_delegate"`
 	RequireComment       bool                        `json:"require_comment"`
 	TimeHours            int                         `json:"time_hours"`
+	TimeoutAction        string                      `json:"timeout_action"`
+	Condition            *StepCondition              `json:"condition"`
+	CompletionPolicy     string                      `json:"completion_policy"`
+	RequiredApprovals    int                         `json:"required_approvals"`
+	SubWorkflowCode      string                      `json:"sub_workflow_code,omitempty"`
 	Assisments           []WorkflowStepAssignmentRes `json:"assignments"`
 }
 
@@ -43,21 +52,27 @@ type WorkflowDefinitionCreate struct {
 }
 
 type WorkflowStepCreateReq struct {
-	StepCode       string                            `json:"step_code" binding:"required"`
-	StepName       string                            `json:"step_name" binding:"required"`
-	StepOrder      int                               `json:"step_order" binding:"required"`
-	RequiredRole   string                            `json:"required_role" binding:"required"`
-	Canskip        bool                              `json:"can_skip"`
-	CanDelegate    bool                              `json:"can_delegate"`
-	RequireComment bool                              `json:"require_comment"`
-	TimeHours      int                               `json:"time_hours"`
-	Assisments     []WorkflowStepAssignmentCreateReq `json:"assignments" binding:"dive"`
+	StepCode          string                            `json:"step_code" binding:"required"`
+	StepName          string                            `json:"step_name" binding:"required"`
+	StepOrder         int                               `json:"step_order" binding:"required"`
+	StepType          string                            `json:"step_type"` // APPROVAL (mặc định), NOTIFY (CC), COUNTERSIGN (Đồng ký) - 2 loại sau không chặn đơn
+	RequiredRole      string                            `json:"required_role" binding:"required"`
+	Canskip           bool                              `json:"can_skip"`
+	CanDelegate       bool                              `json:"can_delegate"`
+	RequireComment    bool                              `json:"require_comment"`
+	TimeHours         int                               `json:"time_hours"`
+	TimeoutAction     string                            `json:"timeout_action"`     // NONE, ESCALATE, AUTO_APPROVE, AUTO_REJECT
+	Condition         *StepCondition                    `json:"condition"`          // Null = bước luôn áp dụng
+	CompletionPolicy  string                            `json:"completion_policy"`  // ANY (mặc định), ALL, QUORUM
+	RequiredApprovals int                               `json:"required_approvals"` // Bắt buộc khi QUORUM
+	SubWorkflowCode   string                            `json:"sub_workflow_code"`  // != "": Tạo đơn con theo quy trình này và chờ kết quả (không cần assignments)
+	Assisments        []WorkflowStepAssignmentCreateReq `json:"assignments" binding:"dive"`
 }
 
 type WorkflowStepAssignmentCreateReq struct {
 	DepartmentIDs    []uint64 `json:"department_ids"`
-	AssignedType     string   `json:"assigned_type" binding:"required"`
-	AssignedIdentity string   `json:"assigned_identity" binding:"required"`
+	AssignedType     string   `json:"assigned_type" binding:"required"` // USER, GROUP, CREATOR_MANAGER, DEPARTMENT_HEAD, MANAGER_LEVEL, POSITION_LEVEL
+	AssignedIdentity string   `json:"assigned_identity"`                // Bỏ trống với CREATOR_MANAGER, DEPARTMENT_HEAD
 	Priority         int      `json:"priority"`
 	IsActive         bool     `json:"is_active"`
 }
@@ -88,3 +103,154 @@ type WorkflowStepAssignmentUpdateReq struct {
 	Priority         *int     `json:"priority"`
 	IsActive         *bool    `json:"is_active"`
 }
+
+// Điều kiện áp dụng bước (xem model.StepCondition)
+type StepCondition struct {
+	All    []StepCondition `json:"all,omitempty" yaml:"all,omitempty"`
+	Any    []StepCondition `json:"any,omitempty" yaml:"any,omitempty"`
+	Not    *StepCondition  `json:"not,omitempty" yaml:"not,omitempty"`
+	Field  string          `json:"field,omitempty" yaml:"field,omitempty"`
+	Op     string          `json:"op,omitempty" yaml:"op,omitempty"` // eq, ne, gt, gte, lt, lte, between, in, not_in, exists
+	Value  interface{}     `json:"value,omitempty" yaml:"value,omitempty"`
+	Values []interface{}   `json:"values,omitempty" yaml:"values,omitempty"`
+	Min    interface{}     `json:"min,omitempty" yaml:"min,omitempty"`
+	Max    interface{}     `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// Request chạy thử điều kiện của quy trình với dữ liệu mẫu
+type WorkflowDryRunReq struct {
+	RequestData map[string]interface{} `json:"request_data"`
+	Condition   *StepCondition         `json:"condition"` // Tùy chọn: thử 1 điều kiện chưa lưu
+}
+
+type WorkflowDryRunRes struct {
+	ConditionMatched *bool                   `json:"condition_matched,omitempty"`
+	Steps            []WorkflowDryRunStepRes `json:"steps"`
+}
+
+type WorkflowDryRunStepRes struct {
+	StepOrder int    `json:"step_order"`
+	StepCode  string `json:"step_code"`
+	StepName  string `json:"step_name"`
+	Applies   bool   `json:"applies"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Danh sách version của 1 ServiceCode (cũ -> mới), Diff so với version liền trước
+type WorkflowVersionRes struct {
+	ID           uint64               `json:"id"`
+	ServiceCode  string               `json:"service_code"`
+	Version      int                  `json:"version"`
+	WorkflowName string               `json:"workflow_name"`
+	IsActive     bool                 `json:"is_active"`
+	CreatedAt    int64                `json:"created_at"`
+	StepCount    int                  `json:"step_count"`
+	InProgress   int64                `json:"in_progress"` // Số đơn đang chạy trên version này
+	Diff         *WorkflowVersionDiff `json:"diff,omitempty"`
+}
+
+type WorkflowVersionDiff struct {
+	AddedSteps   []string           `json:"added_steps"`   // StepCode
+	RemovedSteps []string           `json:"removed_steps"` // StepCode
+	ChangedSteps []WorkflowStepDiff `json:"changed_steps"`
+}
+
+type WorkflowStepDiff struct {
+	StepCode string   `json:"step_code"`
+	Changes  []string `json:"changes"` // VD: "time_hours: 24 -> 48"
+}
+
+// Request chạy thử engine với người tạo + dữ liệu mẫu
+type WorkflowSimulateReq struct {
+	CreatorID    uint64                 `json:"creator_id" validate:"required"`
+	FactoryID    uint64                 `json:"factory_id"`    // Bỏ trống = Nhà máy của người tạo
+	DepartmentID uint64                 `json:"department_id"` // Bỏ trống = Phòng ban của người tạo
+	RequestData  map[string]interface{} `json:"request_data"`
+}
+
+// Kết quả chạy thử 1 bước
+const (
+	SIMULATE_ACTIVE  = "ACTIVE"  // Engine sẽ giao task ở bước này
+	SIMULATE_SKIPPED = "SKIPPED" // Bị bỏ qua (điều kiện / Canskip)
+	SIMULATE_ERROR   = "ERROR"   // Engine sẽ báo lỗi cấu hình và dừng
+
+	SIMULATE_SUB_WORKFLOW = "SUB_WORKFLOW" // Bước tạo đơn con (người duyệt xem ở quy trình con)
+)
+
+type WorkflowSimulateStepRes struct {
+	StepOrder         int                           `json:"step_order"`
+	StepCode          string                        `json:"step_code"`
+	StepName          string                        `json:"step_name"`
+	StepType          string                        `json:"step_type"`
+	Result            string                        `json:"result"`
+	SkipReason        string                        `json:"skip_reason,omitempty"`
+	Error             string                        `json:"error,omitempty"`
+	CompletionPolicy  string                        `json:"completion_policy"`
+	RequiredApprovals int                           `json:"required_approvals,omitempty"`
+	SubWorkflowCode   string                        `json:"sub_workflow_code,omitempty"`
+	Approvers         []WorkflowSimulateApproverRes `json:"approvers"`
+}
+
+type WorkflowSimulateApproverRes struct {
+	ID      string     `json:"id"` // UserID hoặc GroupCode
+	IsGroup bool       `json:"is_group"`
+	Name    string     `json:"name"`
+	DueDate *time.Time `json:"due_date,omitempty"`
+}
+
+// Phiên bản định dạng file Export/Import (tăng khi đổi cấu trúc bundle)
+const WORKFLOW_BUNDLE_FORMAT = 1
+
+// Định dạng file Export/Import
+const (
+	BUNDLE_JSON = "json"
+	BUNDLE_YAML = "yaml"
+)
+
+// Bundle quy trình để chuyển giữa các công ty (VD: TEST_EFNET -> Production).
+// Không chứa ID: Nhóm/Phòng ban/Nhà máy/Người dùng đều tham chiếu theo Code
+type WorkflowBundle struct {
+	FormatVersion int                  `json:"format_version" yaml:"format_version"`
+	ServiceCode   string               `json:"service_code" yaml:"service_code"`
+	Operation     string               `json:"operation" yaml:"operation"`
+	WorkflowName  string               `json:"workflow_name" yaml:"workflow_name"`
+	Description   string               `json:"description" yaml:"description"`
+	SourceVersion int                  `json:"source_version,omitempty" yaml:"source_version,omitempty"` // Chỉ để tham khảo, khi Import luôn tạo version mới
+	Steps         []WorkflowBundleStep `json:"steps" yaml:"steps"`
+}
+
+type WorkflowBundleStep struct {
+	StepCode          string                     `json:"step_code" yaml:"step_code"`
+	StepName          string                     `json:"step_name" yaml:"step_name"`
+	StepOrder         int                        `json:"step_order" yaml:"step_order"`
+	StepType          string                     `json:"step_type,omitempty" yaml:"step_type,omitempty"`
+	RequiredRole      string                     `json:"required_role" yaml:"required_role"`
+	Canskip           bool                       `json:"can_skip" yaml:"can_skip"`
+	CanDelegate       bool                       `json:"can_delegate" yaml:"can_delegate"`
+	RequireComment    bool                       `json:"require_comment" yaml:"require_comment"`
+	TimeHours         int                        `json:"time_hours" yaml:"time_hours"`
+	TimeoutAction     string                     `json:"timeout_action,omitempty" yaml:"timeout_action,omitempty"`
+	Condition         *StepCondition             `json:"condition,omitempty" yaml:"condition,omitempty"`
+	CompletionPolicy  string                     `json:"completion_policy,omitempty" yaml:"completion_policy,omitempty"`
+	RequiredApprovals int                        `json:"required_approvals,omitempty" yaml:"required_approvals,omitempty"`
+	SubWorkflowCode   string                     `json:"sub_workflow_code,omitempty" yaml:"sub_workflow_code,omitempty"`
+	Assignments       []WorkflowBundleAssignment `json:"assignments" yaml:"assignments"`
+}
+
+type WorkflowBundleAssignment struct {
+	AssignedType string `json:"assigned_type" yaml:"assigned_type"`
+	// USER: UserCode, GROUP: GroupCode, *_LEVEL: số cấp, còn lại bỏ trống
+	AssignedIdentity string   `json:"assigned_identity,omitempty" yaml:"assigned_identity,omitempty"`
+	DepartmentCodes  []string `json:"department_codes,omitempty" yaml:"department_codes,omitempty"`
+	FactoryCode      string   `json:"factory_code,omitempty" yaml:"factory_code,omitempty"`
+	Priority         int      `json:"priority" yaml:"priority"`
+	IsActive         *bool    `json:"is_active,omitempty" yaml:"is_active,omitempty"` // Bỏ trống = true
+}
+
+// Kết quả Import
+type WorkflowImportRes struct {
+	ID          uint64 `json:"id"`
+	ServiceCode string `json:"service_code"`
+	Version     int    `json:"version"`
+	Created     bool   `json:"created"` // true = ServiceCode mới, false = thêm version cho quy trình đã có
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/service"
+	"CQS-KYC/utils"
+	"io"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type CalendarHandler struct {
+	service service.CalendarService
+}
+
+func NewCalendarHandler(svc service.CalendarService) *CalendarHandler {
+	return &CalendarHandler{service: svc}
+}
+
+func (h *CalendarHandler) Create(c fiber.Ctx) error {
+	var req dto.CalendarCreate
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	if err := h.service.Create(c.Context(), req); err != nil {
+		return utils.InternalErrorResponse(c, "failed to create calendar", err)
+	}
+	return utils.CreatedResponse(c, "create calendar success", nil)
+}
+
+func (h *CalendarHandler) GetByID(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	res, err := h.service.GetByID(c.Context(), id)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get calendar", err)
+	}
+	return utils.SuccessResponse(c, "get calendar success", res)
+}
+
+func (h *CalendarHandler) Update(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	var req dto.CalendarUpdate
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	if err := h.service.Update(c.Context(), id, req); err != nil {
+		return utils.InternalErrorResponse(c, "failed to update calendar", err)
+	}
+	return utils.SuccessResponse(c, "update calendar success", nil)
+}
+
+func (h *CalendarHandler) Delete(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	if err := h.service.Delete(c.Context(), id); err != nil {
+		return utils.InternalErrorResponse(c, "failed to delete calendar", err)
+	}
+	return utils.SuccessResponse(c, "delete calendar success", nil)
+}
+
+func (h *CalendarHandler) GetAll(c fiber.Ctx) error {
+	res, err := h.service.GetAll(c.Context())
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get all calendars", err)
+	}
+	return utils.SuccessResponse(c, "get all calendars success", res)
+}
+
+// POST /api/calendars/:id/holidays (Body: danh sách ngày nghỉ)
+func (h *CalendarHandler) AddHolidays(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	var req []dto.CalendarHolidayReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	if err := h.service.AddHolidays(c.Context(), id, req); err != nil {
+		return utils.InternalErrorResponse(c, "failed to add holidays", err)
+	}
+	return utils.SuccessResponse(c, "add holidays success", nil)
+}
+
+// DELETE /api/calendars/:id/holidays/:holidayId
+func (h *CalendarHandler) DeleteHoliday(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	holidayID, err := strconv.ParseUint(c.Params("holidayId"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid holiday id", err)
+	}
+	if err := h.service.DeleteHoliday(c.Context(), id, holidayID); err != nil {
+		return utils.InternalErrorResponse(c, "failed to delete holiday", err)
+	}
+	return utils.SuccessResponse(c, "delete holiday success", nil)
+}
+
+// POST /api/calendars/:id/holidays/import (Form-data "file" .ics hoặc raw body text/calendar)
+func (h *CalendarHandler) ImportICal(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+
+	data := c.Body()
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return utils.BadRequestResponse(c, "cannot open ical file", err)
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return utils.BadRequestResponse(c, "cannot read ical file", err)
+		}
+	}
+
+	res, err := h.service.ImportICal(c.Context(), id, data)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to import holidays", err)
+	}
+	return utils.SuccessResponse(c, "import holidays success", res)
+}
+
+// POST /api/calendars/calculate (Tính thử DueDate / số giờ làm việc)
+func (h *CalendarHandler) Calculate(c fiber.Ctx) error {
+	var req dto.CalendarCalcReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	res, err := h.service.Calculate(c.Context(), req)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to calculate", err)
+	}
+	return utils.SuccessResponse(c, "calculate success", res)
+}
+
+func (h *CalendarHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
+	calendarRouter := router.Group("/calendars")
+	for _, m := range ms {
+		calendarRouter.Use(m)
+	}
+	calendarRouter.Post("/", h.Create)
+	calendarRouter.Get("/", h.GetAll)
+	calendarRouter.Post("/calculate", h.Calculate)
+	calendarRouter.Get("/:id", h.GetByID)
+	calendarRouter.Put("/:id", h.Update)
+	calendarRouter.Delete("/:id", h.Delete)
+	calendarRouter.Post("/:id/holidays", h.AddHolidays)
+	calendarRouter.Post("/:id/holidays/import", h.ImportICal)
+	calendarRouter.Delete("/:id/holidays/:holidayId", h.DeleteHoliday)
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/service"
+	"CQS-KYC/utils"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type DelegationHandler struct {
+	service service.DelegationService
+}
+
+func NewDelegationHandler(svc service.DelegationService) *DelegationHandler {
+	return &DelegationHandler{service: svc}
+}
+
+// POST /api/delegations
+func (h *DelegationHandler) Create(c fiber.Ctx) error {
+	var req dto.DelegationCreate
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	if err := h.service.Create(c.Context(), getUserID(c), req); err != nil {
+		return utils.InternalErrorResponse(c, "failed to create delegation", err)
+	}
+	return utils.CreatedResponse(c, "create delegation success", nil)
+}
+
+// GET /api/delegations/:id
+func (h *DelegationHandler) GetByID(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	res, err := h.service.GetByID(c.Context(), getUserID(c), id)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get delegation", err)
+	}
+	return utils.SuccessResponse(c, "get delegation success", res)
+}
+
+// PUT /api/delegations/:id
+func (h *DelegationHandler) Update(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	var req dto.DelegationUpdate
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	if err := h.service.Update(c.Context(), getUserID(c), id, req); err != nil {
+		return utils.InternalErrorResponse(c, "failed to update delegation", err)
+	}
+	return utils.SuccessResponse(c, "update delegation success", nil)
+}
+
+// DELETE /api/delegations/:id
+func (h *DelegationHandler) Delete(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	if err := h.service.Delete(c.Context(), getUserID(c), id); err != nil {
+		return utils.InternalErrorResponse(c, "failed to delete delegation", err)
+	}
+	return utils.SuccessResponse(c, "delete delegation success", nil)
+}
+
+// GET /api/delegations/given (Mình ủy quyền cho người khác)
+func (h *DelegationHandler) GetGiven(c fiber.Ctx) error {
+	res, err := h.service.GetGiven(c.Context(), getUserID(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get delegations", err)
+	}
+	return utils.SuccessResponse(c, "get delegations success", res)
+}
+
+// GET /api/delegations/received (Mình được duyệt thay)
+func (h *DelegationHandler) GetReceived(c fiber.Ctx) error {
+	res, err := h.service.GetReceived(c.Context(), getUserID(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get delegations", err)
+	}
+	return utils.SuccessResponse(c, "get delegations success", res)
+}
+
+func (h *DelegationHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
+	delegationRouter := router.Group("/delegations")
+	for _, m := range ms {
+		delegationRouter.Use(m)
+	}
+	delegationRouter.Post("/", h.Create)
+	delegationRouter.Get("/given", h.GetGiven)
+	delegationRouter.Get("/received", h.GetReceived)
+	delegationRouter.Get("/:id", h.GetByID)
+	delegationRouter.Put("/:id", h.Update)
+	delegationRouter.Delete("/:id", h.Delete)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"CQS-KYC/internal/service"
+	"CQS-KYC/utils"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Gửi comment định kỳ để proxy/trình duyệt không đóng kết nối im lặng
+const eventHeartbeatInterval = 25 * time.Second
+
+type EventHandler struct {
+	service service.RealtimeService
+}
+
+func NewEventHandler(svc service.RealtimeService) *EventHandler {
+	return &EventHandler{service: svc}
+}
+
+// POST /api/events/ticket: Lấy vé (theo x-user-id) rồi mở EventSource với stream_url
+func (h *EventHandler) IssueTicket(c fiber.Ctx) error {
+	res, err := h.service.IssueTicket(c.Context(), getUserID(c), c.BaseURL()+"/api/events/stream")
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to issue event ticket", err)
+	}
+	return utils.SuccessResponse(c, "issue event ticket success", res)
+}
+
+// GET /api/events/stream?ticket=: Server-Sent Events.
+// event: TASK_ASSIGNED, TASK_OVERDUE, TASK_CLAIMED, TASK_RELEASED, INSTANCE_* (data: model.WorkflowEvent)
+func (h *EventHandler) Stream(c fiber.Ctx) error {
+	events, cancel, err := h.service.Subscribe(c.Context(), c.Query("ticket"))
+	if errors.Is(err, service.ErrInvalidTicket) {
+		return utils.UnauthorizedResponse(c, err.Error())
+	}
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to subscribe events", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // Nginx không gom buffer
+
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		heartbeat := time.NewTicker(eventHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		// Mất kết nối -> EventSource tự kết nối lại sau 3s (cần vé mới)
+		fmt.Fprint(w, "retry: 3000\n: connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return // Hub đóng (tắt server / client quá chậm)
+				}
+				data, err := json.Marshal(ev)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			// Client đã ngắt -> Flush lỗi
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func (h *EventHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
+	eventRouter := router.Group("/events")
+	for _, m := range ms {
+		eventRouter.Use(m)
+	}
+
+	eventRouter.Post("/ticket", h.IssueTicket)
+	eventRouter.Get("/stream", h.Stream)
+}
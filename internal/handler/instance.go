@@ -2,6 +2,7 @@ package handler
 
 import (
 	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
 	"CQS-KYC/internal/service"
 	"CQS-KYC/utils"
 	"strconv"
@@ -27,6 +28,35 @@ func getUserID(c fiber.Ctx) string {
 	return uid
 }
 
+// Header tùy chọn để Mobile App gửi mã thiết bị
+const HeaderDeviceID = "X-Device-ID"
+
+// Header tùy chọn: Client gửi lại request với cùng Key -> Không xử lý 2 lần
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 100
+	// Bulk: Key lưu dạng "bulk:<key>:<instanceID>" (tối đa 100 ký tự)
+	maxBulkIdempotencyKeyLength = 64
+)
+
+// Helper: Lấy thông tin nơi ký (IP thật qua Trusted Proxy, User-Agent, Device ID)
+func getClientInfo(c fiber.Ctx) model.ClientInfo {
+	return model.ClientInfo{
+		IPAddress: truncate(c.IP(), 50),
+		UserAgent: truncate(c.Get(fiber.HeaderUserAgent), 255),
+		DeviceID:  truncate(c.Get(HeaderDeviceID), 100),
+	}
+}
+
+// Cắt chuỗi theo size cột trong DB
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
+
 // POST /api/workflow/initiate
 func (h *InstanceHandler) Initiate(c fiber.Ctx) error {
 	var req dto.WorkflowInitiateReq
@@ -36,7 +66,7 @@ func (h *InstanceHandler) Initiate(c fiber.Ctx) error {
 
 	userID := getUserID(c) // Người tạo đơn
 
-	result, err := h.service.Initiate(c.Context(), userID, req)
+	result, err := h.service.Initiate(c.Context(), userID, req, getClientInfo(c))
 	if err != nil {
 		return utils.InternalErrorResponse(c, "Failed to initiate workflow", err)
 	}
@@ -60,11 +90,185 @@ func (h *InstanceHandler) ProcessAction(c fiber.Ctx) error {
 	userID := getUserID(c)
 	// userName := getUserName(c) // Nếu có JWT thì lấy tên thật, tạm thời lấy ID làm tên
 
-	if err := h.service.ProcessAction(c.Context(), instanceID, userID, userID, req); err != nil {
+	idempotencyKey := c.Get(HeaderIdempotencyKey)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return utils.BadRequestResponse(c, "Idempotency-Key is too long", nil)
+	}
+
+	result, err := h.service.ProcessAction(c.Context(), instanceID, userID, userID, req, idempotencyKey, getClientInfo(c))
+	if err != nil {
 		return utils.InternalErrorResponse(c, "Action failed", err)
 	}
+	if result.Replayed {
+		c.Set(HeaderIdempotentReplayed, "true")
+	}
+
+	return utils.SuccessResponse(c, "Action processed successfully", result)
+}
+
+// POST /api/instance/bulk-action (Duyệt/Từ chối hàng loạt từ Inbox, kết quả theo từng đơn)
+func (h *InstanceHandler) BulkAction(c fiber.Ctx) error {
+	var req dto.WorkflowBulkActionReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid body", err)
+	}
+
+	// Key được ghép thêm ID đơn -> Ngắn hơn Key duyệt từng đơn
+	idempotencyKey := c.Get(HeaderIdempotencyKey)
+	if len(idempotencyKey) > maxBulkIdempotencyKeyLength {
+		return utils.BadRequestResponse(c, "Idempotency-Key is too long", nil)
+	}
+
+	userID := getUserID(c)
+	res, err := h.service.BulkAction(c.Context(), userID, userID, req, idempotencyKey, getClientInfo(c))
+	if err != nil {
+		return utils.BadRequestResponse(c, "Bulk action failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Bulk action processed", res)
+}
+
+// POST /api/instance/:id/forward (Chuyển task cho người/nhóm khác)
+func (h *InstanceHandler) Forward(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	var req dto.WorkflowForwardReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid body", err)
+	}
+
+	userID := getUserID(c)
+	if err := h.service.Forward(c.Context(), instanceID, userID, userID, req, getClientInfo(c)); err != nil {
+		return utils.InternalErrorResponse(c, "Forward failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Task forwarded successfully", nil)
+}
+
+// POST /api/instance/admin/reassign (Admin chuyển toàn bộ task của 1 User)
+func (h *InstanceHandler) Reassign(c fiber.Ctx) error {
+	var req dto.WorkflowReassignReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid body", err)
+	}
+
+	adminID := getUserID(c)
+	result, err := h.service.Reassign(c.Context(), adminID, adminID, req, getClientInfo(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Reassign failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Tasks reassigned successfully", result)
+}
+
+// POST /api/instance/:id/claim (Nhận task nhóm)
+func (h *InstanceHandler) Claim(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	userID := getUserID(c)
+	if err := h.service.Claim(c.Context(), instanceID, userID, userID, getClientInfo(c)); err != nil {
+		return utils.InternalErrorResponse(c, "Claim failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Task claimed successfully", nil)
+}
+
+// POST /api/instance/:id/release (Trả task nhóm lại cho cả nhóm)
+func (h *InstanceHandler) Release(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	userID := getUserID(c)
+	if err := h.service.Release(c.Context(), instanceID, userID, userID, getClientInfo(c)); err != nil {
+		return utils.InternalErrorResponse(c, "Release failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Task released successfully", nil)
+}
+
+// POST /api/instance/admin/migrate (Admin chuyển đơn đang chạy sang version quy trình mới)
+func (h *InstanceHandler) Migrate(c fiber.Ctx) error {
+	var req dto.WorkflowMigrateReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid body", err)
+	}
+
+	adminID := getUserID(c)
+	result, err := h.service.MigrateInstances(c.Context(), adminID, adminID, req, getClientInfo(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Migrate failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Instances migrated", result)
+}
+
+// POST /api/instance/:id/skip (Admin bỏ qua bước hiện tại)
+func (h *InstanceHandler) SkipStep(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	var req dto.WorkflowSkipReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid body", err)
+	}
 
-	return utils.SuccessResponse(c, "Action processed successfully", nil)
+	adminID := getUserID(c)
+	if err := h.service.SkipStep(c.Context(), instanceID, adminID, adminID, req, getClientInfo(c)); err != nil {
+		return utils.InternalErrorResponse(c, "Skip step failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Step skipped successfully", nil)
+}
+
+// POST /api/instance/:id/cancel (Người tạo/Admin hủy đơn, đơn con hủy theo)
+func (h *InstanceHandler) Cancel(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	var req dto.WorkflowCancelReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid body", err)
+	}
+
+	userID := getUserID(c)
+	if err := h.service.Cancel(c.Context(), instanceID, userID, userID, req, getClientInfo(c)); err != nil {
+		return utils.InternalErrorResponse(c, "Cancel failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Request cancelled successfully", nil)
+}
+
+// POST /api/instance/:id/acknowledge (Xác nhận CC / Đồng ký kèm ý kiến)
+func (h *InstanceHandler) Acknowledge(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	var req dto.WorkflowAcknowledgeReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid body", err)
+	}
+
+	userID := getUserID(c)
+	action, err := h.service.Acknowledge(c.Context(), instanceID, userID, userID, req, getClientInfo(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Acknowledge failed", err)
+	}
+
+	return utils.SuccessResponse(c, "Acknowledged successfully", dto.WorkflowAcknowledgeRes{InstanceID: instanceID, Action: action})
 }
 
 // GET /api/workflow/tasks (My Tasks)
@@ -79,6 +283,91 @@ func (h *InstanceHandler) GetMyTasks(c fiber.Ctx) error {
 	return utils.SuccessResponse(c, "Pending tasks retrieved", tasks)
 }
 
+// GET /api/instance/tasks/search?doc_num=&service_code=&creator_id=&factory_id=&from=&to=&overdue=&sort=&order=&cursor=&limit=
+func (h *InstanceHandler) SearchMyTasks(c fiber.Ctx) error {
+	var req dto.TaskSearchReq
+	if err := c.Bind().Query(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid query", err)
+	}
+
+	res, err := h.service.SearchTasks(c.Context(), getUserID(c), req)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Failed to search tasks", err)
+	}
+
+	return utils.SuccessResponse(c, "Tasks retrieved", res)
+}
+
+// GET /api/instance?status=&creator_id=&service_code=&factory_id=&department_id=&doc_num=&from=&to=&cursor=&limit=
+func (h *InstanceHandler) ListInstances(c fiber.Ctx) error {
+	var req dto.InstanceListReq
+	if err := c.Bind().Query(&req); err != nil {
+		return utils.BadRequestResponse(c, "Invalid query", err)
+	}
+
+	res, err := h.service.ListInstances(c.Context(), getUserID(c), req)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Failed to list instances", err)
+	}
+
+	return utils.SuccessResponse(c, "Instances retrieved", res)
+}
+
+// GET /api/instance/:id (Chi tiết đơn)
+func (h *InstanceHandler) GetInstance(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	res, err := h.service.GetInstance(c.Context(), instanceID, getUserID(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Failed to get instance", err)
+	}
+
+	return utils.SuccessResponse(c, "Instance retrieved", res)
+}
+
+// GET /api/instance/:id/timeline (Lịch sử dạng timeline: thời gian từng bước, chữ ký, bước chưa đến)
+func (h *InstanceHandler) GetTimeline(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	res, err := h.service.GetTimeline(c.Context(), instanceID, getUserID(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Failed to get timeline", err)
+	}
+
+	return utils.SuccessResponse(c, "Timeline retrieved", res)
+}
+
+// GET /api/instance/tasks/done?limit= (Việc tôi đã xử lý)
+func (h *InstanceHandler) GetMyCompletedTasks(c fiber.Ctx) error {
+	userID := getUserID(c)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	tasks, err := h.service.GetCompletedTasks(c.Context(), userID, limit)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Failed to get completed tasks", err)
+	}
+
+	return utils.SuccessResponse(c, "Completed tasks retrieved", tasks)
+}
+
+// GET /api/instance/tasks/cc (Mục CC: Thông báo / Đồng ký chưa xác nhận)
+func (h *InstanceHandler) GetMyInfoTasks(c fiber.Ctx) error {
+	userID := getUserID(c)
+
+	tasks, err := h.service.GetInfoTasks(c.Context(), userID)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Failed to get CC tasks", err)
+	}
+
+	return utils.SuccessResponse(c, "CC tasks retrieved", tasks)
+}
+
 // GET /api/workflow/:id/history
 func (h *InstanceHandler) GetHistory(c fiber.Ctx) error {
 	idStr := c.Params("id")
@@ -87,7 +376,7 @@ func (h *InstanceHandler) GetHistory(c fiber.Ctx) error {
 		return utils.BadRequestResponse(c, "Invalid instance ID", err)
 	}
 
-	history, err := h.service.GetHistory(c.Context(), instanceID)
+	history, err := h.service.GetHistory(c.Context(), instanceID, getUserID(c))
 	if err != nil {
 		return utils.InternalErrorResponse(c, "Failed to get history", err)
 	}
@@ -95,14 +384,45 @@ func (h *InstanceHandler) GetHistory(c fiber.Ctx) error {
 	return utils.SuccessResponse(c, "History retrieved", history)
 }
 
+// GET /api/instance/:id/history/combined (Lịch sử gộp đơn cha + đơn con)
+func (h *InstanceHandler) GetCombinedHistory(c fiber.Ctx) error {
+	instanceID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "Invalid instance ID", err)
+	}
+
+	history, err := h.service.GetCombinedHistory(c.Context(), instanceID, getUserID(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "Failed to get combined history", err)
+	}
+
+	return utils.SuccessResponse(c, "Combined history retrieved", history)
+}
+
 // Setup Routes
 func (h *InstanceHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
 	instance := router.Group("/instance")
 	for _, m := range ms {
 		instance.Use(m)
 	}
-	instance.Post("/initiate", h.Initiate)        // Tạo đơn
-	instance.Get("/tasks", h.GetMyTasks)          // Xem việc cần làm (Quan trọng)
-	instance.Post("/:id/action", h.ProcessAction) // Duyệt/Hủy
-	instance.Get("/:id/history", h.GetHistory)    // Xem lịch sử
+	instance.Get("/", h.ListInstances)                          // Danh sách đơn (theo quyền xem)
+	instance.Post("/initiate", h.Initiate)                      // Tạo đơn
+	instance.Get("/tasks", h.GetMyTasks)                        // Xem việc cần làm (Quan trọng)
+	instance.Get("/tasks/search", h.SearchMyTasks)              // Inbox: Lọc, sắp xếp, phân trang
+	instance.Get("/tasks/done", h.GetMyCompletedTasks)          // Xem việc đã làm
+	instance.Get("/tasks/cc", h.GetMyInfoTasks)                 // Xem CC / Đồng ký (không chặn đơn)
+	instance.Post("/bulk-action", h.BulkAction)                 // Duyệt/Từ chối hàng loạt
+	instance.Post("/:id/action", h.ProcessAction)               // Duyệt/Hủy
+	instance.Post("/:id/claim", h.Claim)                        // Nhận task nhóm
+	instance.Post("/:id/release", h.Release)                    // Trả task nhóm
+	instance.Post("/:id/acknowledge", h.Acknowledge)            // Xác nhận CC / Đồng ký
+	instance.Post("/:id/forward", h.Forward)                    // Chuyển task
+	instance.Post("/admin/reassign", h.Reassign)                // Admin chuyển task hàng loạt
+	instance.Post("/admin/migrate", h.Migrate)                  // Admin chuyển đơn sang version quy trình mới
+	instance.Post("/:id/skip", h.SkipStep)                      // Admin bỏ qua bước (Canskip)
+	instance.Post("/:id/cancel", h.Cancel)                      // Hủy đơn (kèm đơn con)
+	instance.Get("/:id/history", h.GetHistory)                  // Xem lịch sử
+	instance.Get("/:id/history/combined", h.GetCombinedHistory) // Lịch sử gộp đơn cha/con
+	instance.Get("/:id/timeline", h.GetTimeline)                // Timeline theo thời gian
+	instance.Get("/:id", h.GetInstance)                         // Chi tiết đơn
 }
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/service"
+	"CQS-KYC/utils"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type NotificationHandler struct {
+	service service.NotificationService
+}
+
+func NewNotificationHandler(svc service.NotificationService) *NotificationHandler {
+	return &NotificationHandler{service: svc}
+}
+
+// GET /api/notifications/preferences (của User hiện tại)
+func (h *NotificationHandler) GetPreference(c fiber.Ctx) error {
+	res, err := h.service.GetPreference(c.Context(), getUserID(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get notification preferences", err)
+	}
+	return utils.SuccessResponse(c, "get notification preferences success", res)
+}
+
+// PUT /api/notifications/preferences
+func (h *NotificationHandler) UpdatePreference(c fiber.Ctx) error {
+	var req dto.NotificationPreferenceReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	res, err := h.service.UpdatePreference(c.Context(), getUserID(c), req)
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to update notification preferences", err)
+	}
+	return utils.SuccessResponse(c, "update notification preferences success", res)
+}
+
+// POST /api/notifications/test: Gửi email mẫu đến chính User để kiểm tra cấu hình SMTP
+func (h *NotificationHandler) SendTest(c fiber.Ctx) error {
+	var req dto.NotificationTestReq
+	if len(c.Body()) > 0 {
+		if err := c.Bind().Body(&req); err != nil {
+			return utils.BadRequestResponse(c, "invalid request body", err)
+		}
+	}
+	res, err := h.service.SendTest(c.Context(), getUserID(c), req)
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to send test email", err)
+	}
+	return utils.SuccessResponse(c, "send test email success", res)
+}
+
+func (h *NotificationHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
+	notificationRouter := router.Group("/notifications")
+	for _, m := range ms {
+		notificationRouter.Use(m)
+	}
+
+	notificationRouter.Get("/preferences", h.GetPreference)
+	notificationRouter.Put("/preferences", h.UpdatePreference)
+	notificationRouter.Post("/test", h.SendTest)
+}
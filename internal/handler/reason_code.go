@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/service"
+	"CQS-KYC/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type ReasonCodeHandler struct {
+	service service.ReasonCodeService
+}
+
+func NewReasonCodeHandler(svc service.ReasonCodeService) *ReasonCodeHandler {
+	return &ReasonCodeHandler{service: svc}
+}
+
+func (h *ReasonCodeHandler) Create(c fiber.Ctx) error {
+	var req dto.ReasonCodeCreate
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	if err := h.service.Create(c.Context(), req); err != nil {
+		return utils.InternalErrorResponse(c, "failed to create reason code", err)
+	}
+	return utils.CreatedResponse(c, "create reason code success", nil)
+}
+
+func (h *ReasonCodeHandler) GetByID(c fiber.Ctx) error {
+	var reason dto.ReasonCodeID
+	if err := c.Bind().URI(&reason.ID); err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	res, err := h.service.GetByID(c.Context(), reason.ID)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get reason code by id", err)
+	}
+	return utils.SuccessResponse(c, "get reason code by id success", res)
+}
+
+func (h *ReasonCodeHandler) Update(c fiber.Ctx) error {
+	var reason dto.ReasonCodeID
+	if err := c.Bind().URI(&reason.ID); err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	var req dto.ReasonCodeUpdate
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	if err := h.service.Update(c.Context(), reason.ID, req); err != nil {
+		return utils.InternalErrorResponse(c, "failed to update reason code", err)
+	}
+	return utils.SuccessResponse(c, "update reason code success", nil)
+}
+
+func (h *ReasonCodeHandler) Delete(c fiber.Ctx) error {
+	var reason dto.ReasonCodeID
+	if err := c.Bind().URI(&reason.ID); err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	if err := h.service.Delete(c.Context(), reason.ID); err != nil {
+		return utils.InternalErrorResponse(c, "failed to delete reason code", err)
+	}
+	return utils.SuccessResponse(c, "delete reason code success", nil)
+}
+
+// GET /api/reason-codes?service_code= (Lý do của quy trình + lý do dùng chung)
+func (h *ReasonCodeHandler) GetList(c fiber.Ctx) error {
+	res, err := h.service.GetList(c.Context(), c.Query("service_code"))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get reason code list", err)
+	}
+	return utils.SuccessResponse(c, "get reason code list success", res)
+}
+
+// GET /api/reason-codes/report?service_code=&from=2025-01-01&to=2025-02-01 (mặc định 30 ngày gần nhất)
+func (h *ReasonCodeHandler) Report(c fiber.Ctx) error {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			return utils.BadRequestResponse(c, "invalid from date, expected YYYY-MM-DD", err)
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			return utils.BadRequestResponse(c, "invalid to date, expected YYYY-MM-DD", err)
+		}
+		to = t.AddDate(0, 0, 1) // Tính trọn ngày "to"
+	}
+	res, err := h.service.Report(c.Context(), c.Query("service_code"), from, to)
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to get reason report", err)
+	}
+	return utils.SuccessResponse(c, "get reason report success", res)
+}
+
+func (h *ReasonCodeHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
+	reasonRouter := router.Group("/reason-codes")
+	for _, m := range ms {
+		reasonRouter.Use(m)
+	}
+
+	reasonRouter.Post("/", h.Create)
+	reasonRouter.Get("/", h.GetList)
+	reasonRouter.Get("/report", h.Report)
+	reasonRouter.Get("/:id", h.GetByID)
+	reasonRouter.Put("/:id", h.Update)
+	reasonRouter.Delete("/:id", h.Delete)
+}
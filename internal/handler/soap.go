@@ -24,8 +24,14 @@ func (h *SOAPHandler) HandleRequest(c fiber.Ctx) error {
 	// Lấy raw body
 	body := c.Body()
 
+	// IP của máy chủ ERP gọi sang (ghi vào chữ ký Submit)
+	client := getClientInfo(c)
+	if client.DeviceID == "" {
+		client.DeviceID = "ERP_SYSTEM"
+	}
+
 	// Gọi service xử lý (Logic bóc tách + Lưu DB)
-	err := h.service.ProcessSOAPRequest(body)
+	err := h.service.ProcessSOAPRequest(body, client)
 
 	// Chuỗi trả về chuẩn cho ERP (Hardcoded để đảm bảo performance)
 	resp := `<?xml version="1.0" encoding="utf-8"?>
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/service"
+	"CQS-KYC/utils"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type WebhookHandler struct {
+	service service.WebhookService
+}
+
+func NewWebhookHandler(svc service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{service: svc}
+}
+
+// POST /api/webhooks (secret chỉ trả về trong response này)
+func (h *WebhookHandler) Create(c fiber.Ctx) error {
+	var req dto.WebhookCreateReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	res, err := h.service.Create(c.Context(), getUserID(c), req)
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to create webhook", err)
+	}
+	return utils.CreatedResponse(c, "create webhook success", res)
+}
+
+func (h *WebhookHandler) GetList(c fiber.Ctx) error {
+	res, err := h.service.GetList(c.Context(), getUserID(c))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get webhook list", err)
+	}
+	return utils.SuccessResponse(c, "get webhook list success", res)
+}
+
+func (h *WebhookHandler) GetByID(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid webhook id", err)
+	}
+	res, err := h.service.GetByID(c.Context(), getUserID(c), id)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get webhook by id", err)
+	}
+	return utils.SuccessResponse(c, "get webhook by id success", res)
+}
+
+// PUT /api/webhooks/:id ("secret": "" -> Sinh khóa mới, trả về trong response)
+func (h *WebhookHandler) Update(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid webhook id", err)
+	}
+	var req dto.WebhookUpdateReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	res, err := h.service.Update(c.Context(), getUserID(c), id, req)
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to update webhook", err)
+	}
+	return utils.SuccessResponse(c, "update webhook success", res)
+}
+
+func (h *WebhookHandler) Delete(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid webhook id", err)
+	}
+	if err := h.service.Delete(c.Context(), getUserID(c), id); err != nil {
+		return utils.InternalErrorResponse(c, "failed to delete webhook", err)
+	}
+	return utils.SuccessResponse(c, "delete webhook success", nil)
+}
+
+// GET /api/webhooks/:id/deliveries?status=&instance_id=&cursor=&limit= (mới nhất trước)
+func (h *WebhookHandler) ListDeliveries(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid webhook id", err)
+	}
+	var req dto.WebhookDeliveryListReq
+	if err := c.Bind().Query(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid query", err)
+	}
+	res, err := h.service.ListDeliveries(c.Context(), getUserID(c), id, req)
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to get webhook deliveries", err)
+	}
+	return utils.SuccessResponse(c, "get webhook deliveries success", res)
+}
+
+// GET /api/webhooks/deliveries/:deliveryId (kèm payload đã gửi)
+func (h *WebhookHandler) GetDelivery(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("deliveryId"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid delivery id", err)
+	}
+	res, err := h.service.GetDelivery(c.Context(), getUserID(c), id)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to get webhook delivery", err)
+	}
+	return utils.SuccessResponse(c, "get webhook delivery success", res)
+}
+
+// POST /api/webhooks/deliveries/:deliveryId/redeliver: Gửi lại (tạo Delivery mới, cùng event id)
+func (h *WebhookHandler) Redeliver(c fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("deliveryId"), 10, 64)
+	if err != nil {
+		return utils.BadRequestResponse(c, "invalid delivery id", err)
+	}
+	res, err := h.service.Redeliver(c.Context(), getUserID(c), id)
+	if err != nil {
+		return utils.BadRequestResponse(c, "failed to redeliver webhook", err)
+	}
+	return utils.CreatedResponse(c, "redeliver webhook queued", res)
+}
+
+// Toàn bộ API Webhook chỉ dành cho Admin (kiểm tra ở Service)
+func (h *WebhookHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
+	webhookRouter := router.Group("/webhooks")
+	for _, m := range ms {
+		webhookRouter.Use(m)
+	}
+
+	webhookRouter.Post("/", h.Create)
+	webhookRouter.Get("/", h.GetList)
+	webhookRouter.Get("/deliveries/:deliveryId", h.GetDelivery)
+	webhookRouter.Post("/deliveries/:deliveryId/redeliver", h.Redeliver)
+	webhookRouter.Get("/:id", h.GetByID)
+	webhookRouter.Put("/:id", h.Update)
+	webhookRouter.Delete("/:id", h.Delete)
+	webhookRouter.Get("/:id/deliveries", h.ListDeliveries)
+}
@@ -4,6 +4,8 @@ import (
 	"CQS-KYC/internal/dto"
 	"CQS-KYC/internal/service"
 	"CQS-KYC/utils"
+	"fmt"
+	"strings"
 
 	"github.com/gofiber/fiber/v3"
 )
@@ -74,6 +76,86 @@ func (h *WorkflowHandler) GetAll(c fiber.Ctx) error {
 	return utils.SuccessResponse(c, "get all workflow definition success", wfls)
 }
 
+// POST /api/workflow/:id/dry-run (Chạy thử điều kiện rẽ nhánh với dữ liệu mẫu)
+func (h *WorkflowHandler) DryRun(c fiber.Ctx) error {
+	var wflID dto.WorkflowDefinitionRes
+	if err := c.Bind().URI(&wflID.ID); err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	var req dto.WorkflowDryRunReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	res, err := h.service.DryRun(c.Context(), wflID.ID, req)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to dry-run workflow conditions", err)
+	}
+	return utils.SuccessResponse(c, "dry-run workflow conditions success", res)
+}
+
+// GET /api/workflow/versions/:serviceCode (Tất cả version + thay đổi giữa các version)
+func (h *WorkflowHandler) ListVersions(c fiber.Ctx) error {
+	serviceCode := c.Params("serviceCode")
+	if serviceCode == "" {
+		return utils.BadRequestResponse(c, "service code is required", nil)
+	}
+	versions, err := h.service.ListVersions(c.Context(), serviceCode)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to list workflow versions", err)
+	}
+	return utils.SuccessResponse(c, "list workflow versions success", versions)
+}
+
+// POST /api/workflow/:id/simulate (Chạy thử: các bước + người duyệt engine sẽ giao)
+func (h *WorkflowHandler) Simulate(c fiber.Ctx) error {
+	var wflID dto.WorkflowDefinitionRes
+	if err := c.Bind().URI(&wflID.ID); err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	var req dto.WorkflowSimulateReq
+	if err := c.Bind().Body(&req); err != nil {
+		return utils.BadRequestResponse(c, "invalid request body", err)
+	}
+	res, err := h.service.Simulate(c.Context(), wflID.ID, req)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to simulate workflow", err)
+	}
+	return utils.SuccessResponse(c, "simulate workflow success", res)
+}
+
+// GET /api/workflow/:id/export?format=json|yaml (Tải bundle quy trình, tham chiếu theo Code)
+func (h *WorkflowHandler) Export(c fiber.Ctx) error {
+	var wflID dto.WorkflowDefinitionRes
+	if err := c.Bind().URI(&wflID.ID); err != nil {
+		return utils.BadRequestResponse(c, "invalid request id", err)
+	}
+	data, format, err := h.service.Export(c.Context(), wflID.ID, strings.ToLower(c.Query("format")))
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to export workflow definition", err)
+	}
+
+	contentType := fiber.MIMEApplicationJSONCharsetUTF8
+	if format == dto.BUNDLE_YAML {
+		contentType = "application/yaml; charset=utf-8"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="workflow_%d.%s"`, wflID.ID, format))
+	return c.Send(data)
+}
+
+// POST /api/workflow/import?format=json|yaml (Body là nội dung bundle; ServiceCode đã có -> tạo version mới)
+func (h *WorkflowHandler) Import(c fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format"))
+	if format == "" && strings.Contains(c.Get(fiber.HeaderContentType), "yaml") {
+		format = dto.BUNDLE_YAML
+	}
+	res, err := h.service.Import(c.Context(), c.Body(), format)
+	if err != nil {
+		return utils.InternalErrorResponse(c, "failed to import workflow definition", err)
+	}
+	return utils.SuccessResponse(c, "import workflow definition success", res)
+}
+
 func (h *WorkflowHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler) {
 	wfls := router.Group("/workflow")
 	for _, m := range ms {
@@ -81,7 +163,12 @@ func (h *WorkflowHandler) SetupRoutes(router fiber.Router, ms ...fiber.Handler)
 	}
 	wfls.Post("/", h.Create)
 	wfls.Get("/", h.GetAll)
+	wfls.Get("/versions/:serviceCode", h.ListVersions)
+	wfls.Post("/import", h.Import)
 	wfls.Get("/:id", h.GetByID)
 	wfls.Put("/:id", h.Update)
 	wfls.Delete("/:id", h.Delete)
+	wfls.Post("/:id/dry-run", h.DryRun)
+	wfls.Post("/:id/simulate", h.Simulate)
+	wfls.Get("/:id/export", h.Export)
 }
@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+// Lịch làm việc của 1 nhà máy (dùng để tính hạn SLA theo giờ hành chính)
+type WorkingCalendar struct {
+	ID        uint64 `gorm:"primaryKey" json:"id"`
+	FactoryID uint64 `gorm:"uniqueIndex;not null" json:"factory_id"` // Mỗi nhà máy 1 lịch
+	Name      string `gorm:"size:100" json:"name"`
+	Timezone  string `gorm:"size:50;default:'Asia/Ho_Chi_Minh'" json:"timezone"` // VD: Asia/Bangkok cho nhà máy TH
+
+	// Ngày làm việc trong tuần: 0 = Chủ nhật ... 6 = Thứ bảy
+	WorkDays []int `gorm:"type:json;serializer:json" json:"work_days"`
+	// Các ca trong ngày (tách ca để trừ giờ nghỉ trưa)
+	Shifts []WorkShift `gorm:"type:json;serializer:json" json:"shifts"`
+
+	IsActive  bool              `gorm:"default:true" json:"is_active"`
+	CreatedAt time.Time         `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time         `gorm:"autoUpdateTime" json:"updated_at"`
+	Factory   *Factory          `gorm:"foreignKey:FactoryID" json:"factory,omitempty"`
+	Holidays  []CalendarHoliday `gorm:"foreignKey:CalendarID;constraint:OnDelete:CASCADE" json:"holidays"`
+}
+
+func (WorkingCalendar) TableName() string {
+	return "working_calendars"
+}
+
+// Ca làm việc, định dạng "HH:MM" theo Timezone của lịch
+type WorkShift struct {
+	Start string `json:"start"` // VD: 08:00
+	End   string `json:"end"`   // VD: 12:00
+}
+
+// Ngày nghỉ lễ (hoặc ngày làm bù nếu IsWorkingDay = true)
+type CalendarHoliday struct {
+	ID           uint64    `gorm:"primaryKey" json:"id"`
+	CalendarID   uint64    `gorm:"not null;uniqueIndex:idx_calendar_date" json:"calendar_id"`
+	Date         string    `gorm:"size:10;not null;uniqueIndex:idx_calendar_date" json:"date"` // YYYY-MM-DD
+	Name         string    `gorm:"size:255" json:"name"`
+	IsWorkingDay bool      `gorm:"default:false" json:"is_working_day"`    // Ngày làm bù (VD: Thứ 7 đi làm bù Tết)
+	Source       string    `gorm:"size:20;default:'MANUAL'" json:"source"` // MANUAL, ICAL
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (CalendarHoliday) TableName() string {
+	return "calendar_holidays"
+}
+
+const (
+	HOLIDAY_SOURCE_MANUAL = "MANUAL"
+	HOLIDAY_SOURCE_ICAL   = "ICAL"
+)
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// Ủy quyền duyệt khi vắng mặt (Out-of-office)
+// Trong khoảng [StartDate, EndDate], DelegateID được duyệt thay cho DelegatorID
+type WorkflowDelegation struct {
+	ID          uint64 `gorm:"primaryKey" json:"id"`
+	DelegatorID string `gorm:"index;size:50;not null" json:"delegator_id"` // Người đi vắng (UserID)
+	DelegateID  string `gorm:"index;size:50;not null" json:"delegate_id"`  // Người duyệt thay (UserID)
+
+	StartDate time.Time `gorm:"index;not null" json:"start_date"`
+	EndDate   time.Time `gorm:"index;not null" json:"end_date"`
+
+	// Giới hạn theo loại đơn (ServiceCode). Rỗng = Ủy quyền tất cả
+	ServiceCodes []string `gorm:"type:json;serializer:json" json:"service_codes"`
+
+	Reason    string    `gorm:"size:255" json:"reason"`
+	IsActive  bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (WorkflowDelegation) TableName() string {
+	return "workflow_delegations"
+}
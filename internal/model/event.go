@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+// Sự kiện của đơn do Engine phát ra sau khi Transaction commit (Realtime, Email)
+type WorkflowEvent struct {
+	Type        string    `json:"type"` // EVENT_*
+	InstanceID  uint64    `json:"instance_id"`
+	ServiceCode string    `json:"service_code"`
+	DocNum      string    `json:"doc_num"`
+	DocType     string    `json:"doc_type"`
+	CreatorID   string    `json:"creator_id"`
+	Status      string    `json:"status"` // Trạng thái đơn lúc phát sinh
+	CurrentStep int       `json:"current_step"`
+	StepName    string    `json:"step_name,omitempty"`
+	TaskID      *uint64   `json:"task_id,omitempty"`
+	AssignedTo  string    `json:"assigned_to,omitempty"` // Sự kiện TASK_*: UserID hoặc GroupCode
+	IsGroup     bool      `json:"is_group,omitempty"`
+	ActorID     string    `json:"actor_id,omitempty"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// Sự kiện TASK_* báo cho người được giao, INSTANCE_* báo cho người tạo
+func (e *WorkflowEvent) IsTaskEvent() bool {
+	return e.TaskID != nil
+}
+
+const (
+	EVENT_TASK_ASSIGNED = "TASK_ASSIGNED" // Có task mới (kể cả Chuyển, Leo thang, CC)
+	EVENT_TASK_OVERDUE  = "TASK_OVERDUE"  // Task quá hạn
+	EVENT_TASK_CLAIMED  = "TASK_CLAIMED"  // 1 thành viên đã nhận task nhóm
+	EVENT_TASK_RELEASED = "TASK_RELEASED" // Task nhóm được trả lại (người nhận trả / hết thời gian giữ)
+
+	EVENT_INSTANCE_CREATED   = "INSTANCE_CREATED"
+	EVENT_STEP_ADVANCED      = "STEP_ADVANCED"      // Đơn sang bước mới
+	EVENT_INSTANCE_APPROVED  = "INSTANCE_APPROVED"  // Đơn được duyệt xong
+	EVENT_INSTANCE_REJECTED  = "INSTANCE_REJECTED"  // Đơn bị từ chối
+	EVENT_INSTANCE_RETURNED  = "INSTANCE_RETURNED"  // Đơn bị trả về
+	EVENT_INSTANCE_CANCELLED = "INSTANCE_CANCELLED" // Đơn bị hủy
+)
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// Lưu kết quả thao tác theo header Idempotency-Key.
+// Client gửi lại cùng Key (mạng chập chờn, bấm 2 lần) -> Trả lại kết quả cũ, không duyệt 2 lần
+type IdempotencyKey struct {
+	ID          uint64 `gorm:"primaryKey" json:"id"`
+	ActorID     string `gorm:"size:50;not null;uniqueIndex:idx_idempotency_actor_key" json:"actor_id"`
+	Key         string `gorm:"size:100;not null;uniqueIndex:idx_idempotency_actor_key" json:"key"`
+	RequestHash string `gorm:"size:64;not null" json:"request_hash"` // SHA-256 của nội dung request (phát hiện dùng lại Key cho request khác)
+
+	// --- KẾT QUẢ LẦN XỬ LÝ ĐẦU ---
+	InstanceID  uint64 `gorm:"index" json:"instance_id"`
+	Action      string `gorm:"size:50" json:"action"`
+	Status      string `gorm:"size:20" json:"status"` // Trạng thái đơn sau khi xử lý
+	CurrentStep int    `json:"current_step"`
+	LogID       uint64 `json:"log_id"` // Log chữ ký của lần xử lý đầu (replay trả lại cùng chữ ký)
+
+	CreatedAt time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// Kết quả xử lý 1 hành động trên đơn (không lưu thành bảng riêng)
+type ActionResult struct {
+	InstanceID  uint64
+	Action      string
+	Status      string
+	CurrentStep int
+	Version     int
+	Replayed    bool   // True = Trả lại kết quả cũ theo Idempotency-Key
+	LogID       uint64 // Log chữ ký của thao tác (Replayed -> Log của lần xử lý đầu)
+	Signature   string // SignatureHash của Log
+}
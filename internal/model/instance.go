@@ -9,7 +9,16 @@ import (
 
 type WorkflowInstance struct {
 	ID         uint64 `gorm:"primaryKey" json:"id"`
-	WorkflowID uint64 `gorm:"index;not null" json:"workflow_id"` // ID của quy trình mẹ
+	WorkflowID uint64 `gorm:"index;not null" json:"workflow_id"` // ID của quy trình mẹ (đúng version lúc tạo đơn)
+	// Version quy trình đơn đang chạy. Sửa quy trình tạo version mới, đơn cũ vẫn chạy theo version này
+	// cho đến khi Admin migrate sang version mới
+	WorkflowVersion int `gorm:"default:1" json:"workflow_version"`
+
+	// --- ĐƠN CON (Sub-workflow) ---
+	// Đơn được tạo bởi bước Sub-workflow của đơn cha (VD: Đề nghị mua hàng -> Đơn đặt hàng).
+	// Đơn cha đứng chờ ở ParentStepOrder đến khi đơn con kết thúc
+	ParentInstanceID *uint64 `gorm:"index" json:"parent_instance_id"`
+	ParentStepOrder  int     `json:"parent_step_order,omitempty"`
 
 	// --- THÔNG TIN TỪ ERP (BUSINESS KEY) ---
 	DocNum      string `gorm:"index;size:50;not null" json:"doc_num"`  // Mã đơn: PO-2024-001
@@ -22,14 +31,15 @@ type WorkflowInstance struct {
 	DepartmentID uint64 `gorm:"index;size:50" json:"department_id"` // VD: IT, ACC
 
 	// --- QUẢN LÝ TRẠNG THÁI ---
-	CurrentStep int `gorm:"default:1" json:"current_step"` // Đang ở bước mấy (1, 2, 3...)
-	TotalSteps  int `gorm:"default:0" json:"total_steps"`  // Tổng số bước của quy trình này
+	CurrentStep int `gorm:"default:1" json:"current_step"`     // Đang ở bước mấy (1, 2, 3...)
+	Version     int `gorm:"not null;default:0" json:"version"` // Tăng mỗi lần đơn thay đổi (Optimistic Concurrency)
+	TotalSteps  int `gorm:"default:0" json:"total_steps"`      // Tổng số bước của quy trình này
 
 	// Trạng thái nội bộ (System Status): :NEW, IN_PROGRESS, APPROVED, REJECTED
 	Status      string         `gorm:"index;size:20;default:'IN_PROGRESS'" json:"status"`
 	RequestData datatypes.JSON `gorm:"type:jsonb" json:"request_data"`
 	// --- METADATA ---
-	CreatorID   string     `gorm:"size:50;not null" json:"creator_id"` // UserID người tạo trên ERP
+	CreatorID   string     `gorm:"index;size:50;not null" json:"creator_id"` // UserID người tạo trên ERP
 	StartedAt   time.Time  `json:"started_at"`
 	CompletedAt *time.Time `json:"completed_at"` // Null nếu chưa xong
 
@@ -50,16 +60,35 @@ type WorkflowTask struct {
 	StepID    uint64 `gorm:"not null" json:"step_id"`
 	StepOrder int    `json:"step_order"`
 	StepName  string `gorm:"size:100" json:"step_name"` // Cache tên bước để hiển thị cho nhanh
+	// Cache loại bước: APPROVAL (chặn đơn) hoặc NOTIFY/COUNTERSIGN (không chặn, hiện ở mục CC riêng)
+	StepType string `gorm:"size:20;default:'APPROVAL';index" json:"step_type"`
 
 	// --- NGƯỜI ĐƯỢC GIAO VIỆC ---
 	// Logic: Hệ thống resolve từ Rule -> Ra Group hoặc User cụ thể -> Lưu vào đây
-	AssignedTo string `gorm:"index;size:50;not null" json:"assigned_to"` // UserID hoặc GroupCode
-	IsGroup    bool   `gorm:"default:false" json:"is_group"`             // True = Gán cho cả nhóm
+	// idx_task_inbox: Inbox lọc theo người nhận + trạng thái, sắp theo ngày nhận
+	AssignedTo string `gorm:"index;index:idx_task_inbox,priority:1;size:50;not null" json:"assigned_to"` // UserID hoặc GroupCode
+	IsGroup    bool   `gorm:"default:false;index:idx_task_inbox,priority:2" json:"is_group"`             // True = Gán cho cả nhóm
 
-	Status  string     `gorm:"size:20;default:'PENDING';index" json:"status"` // PENDING, DONE
-	DueDate *time.Time `json:"due_date"`                                      // Tính toán từ TimeoutHours
+	Status  string     `gorm:"size:20;default:'PENDING';index;index:idx_task_inbox,priority:3" json:"status"` // TASK_STATUS_*
+	DueDate *time.Time `gorm:"index" json:"due_date"`                                                         // Tính toán từ TimeoutHours
 
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	// --- SLA ---
+	OverdueAt       *time.Time `gorm:"index" json:"overdue_at"`        // Thời điểm Scheduler phát hiện quá hạn
+	EscalatedFromID *uint64    `gorm:"index" json:"escalated_from_id"` // Task gốc nếu đây là task leo thang lên Trưởng phòng
+
+	// --- NHẬN VIỆC (Task nhóm: 1 thành viên nhận xử lý, người khác chỉ xem) ---
+	ClaimedBy string     `gorm:"index;size:50" json:"claimed_by"`
+	ClaimedAt *time.Time `json:"claimed_at"`
+
+	// --- KẾT QUẢ XỬ LÝ (Task không bị xóa, giữ lại để thống kê) ---
+	CompletedAt *time.Time `json:"completed_at"`
+	CompletedBy string     `gorm:"index;size:50" json:"completed_by"` // UserID người xử lý (kể cả duyệt thay), SYSTEM nếu tự động
+	Outcome     string     `gorm:"size:50" json:"outcome"`            // Action đã thực hiện: APPROVE, REJECT...
+
+	CreatedAt time.Time `gorm:"autoCreateTime;index:idx_task_inbox,priority:4" json:"created_at"`
+
+	// Không lưu DB: UserID người ủy quyền nếu task hiển thị cho người duyệt thay
+	DelegatedFrom string `gorm:"-" json:"delegated_from,omitempty"`
 
 	// Relation để join lấy thông tin đơn hàng
 	Instance *WorkflowInstance `gorm:"foreignKey:InstanceID" json:"instance,omitempty"`
@@ -77,7 +106,13 @@ type WorkflowLog struct {
 	Action    string `gorm:"size:50;not null" json:"action"` // APPROVE, REJECT, SUBMIT
 	ActorID   string `gorm:"index;size:50;not null" json:"actor_id"`
 	ActorName string `gorm:"size:100" json:"actor_name"`
-	Comment   string `gorm:"type:text" json:"comment"`
+	// Nếu ActorID duyệt thay (Ủy quyền) -> Lưu người được giao task gốc
+	OnBehalfOfID string `gorm:"index;size:50" json:"on_behalf_of_id"`
+	// FORWARD/REASSIGN: UserID hoặc GroupCode nhận task
+	TargetID string `gorm:"size:50" json:"target_id,omitempty"`
+	Comment  string `gorm:"type:text" json:"comment"`
+	// REJECT/RETURN: Mã lý do trong danh mục ReasonCode của quy trình
+	ReasonCode string `gorm:"size:50;index" json:"reason_code,omitempty"`
 
 	// --- CÁC TRƯỜNG CHỮ KÝ SỐ (TÍCH HỢP VÀO ĐÂY) ---
 	// Thay vì bảng riêng, ta lưu thẳng Hash vào Log
@@ -85,11 +120,19 @@ type WorkflowLog struct {
 	DataSnapshotHash string `gorm:"size:255" json:"data_snapshot_hash"` // Hash nội dung đơn lúc ký
 	SignedTimestamp  int64  `gorm:"not null" json:"signed_timestamp"`   // UnixNano time
 	IPAddress        string `gorm:"size:50" json:"ip_address"`
-	DeviceInfo       string `gorm:"size:255" json:"device_info"`
+	DeviceInfo       string `gorm:"size:255" json:"device_info"` // User-Agent của client
+	DeviceID         string `gorm:"size:100" json:"device_id"`   // Header X-Device-ID (Mobile App gửi lên)
 
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
+// Thông tin nơi thực hiện thao tác ký (không lưu thành bảng riêng)
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+	DeviceID  string
+}
+
 const (
 	STATUS_NEW         = "NEW"
 	STATUS_IN_PROGRESS = "IN_PROGRESS"
@@ -98,11 +141,41 @@ const (
 	STATUS_CANCELLED   = "CANCELLED"
 )
 
+// Trạng thái Task
+const (
+	TASK_STATUS_PENDING    = "PENDING"    // Chờ xử lý
+	TASK_STATUS_CLAIMED    = "CLAIMED"    // 1 thành viên nhóm đã nhận xử lý
+	TASK_STATUS_DONE       = "DONE"       // Người được giao đã xử lý (xem Outcome)
+	TASK_STATUS_SUPERSEDED = "SUPERSEDED" // Bước đã xong (đủ người duyệt / bị từ chối) -> Task còn lại tự đóng
+	TASK_STATUS_CANCELLED  = "CANCELLED"  // Admin bỏ qua bước / Hủy đơn
+	TASK_STATUS_EXPIRED    = "EXPIRED"    // Quá hạn, hệ thống tự xử lý (SLA)
+)
+
+// Task chưa đóng (còn phải xử lý)
+var TASK_OPEN_STATUSES = []string{TASK_STATUS_PENDING, TASK_STATUS_CLAIMED}
+
+// Actor của các hành động do hệ thống tự thực hiện (SLA, Auto-skip...)
+const SYSTEM_ACTOR = "SYSTEM"
+
 // Hành động trong Log
 const (
-	ACTION_SUBMIT  = "SUBMIT"  // Gửi đơn
-	ACTION_APPROVE = "APPROVE" // Duyệt
-	ACTION_REJECT  = "REJECT"  // Từ chối (Kết thúc luôn)
-	ACTION_RETURN  = "RETURN"  // Trả về bước trước (Hoặc trả về đầu)
-	ACTION_CANCEL  = "CANCEL"  // Hủy đơn
+	ACTION_SUBMIT   = "SUBMIT"   // Gửi đơn
+	ACTION_APPROVE  = "APPROVE"  // Duyệt
+	ACTION_REJECT   = "REJECT"   // Từ chối (Kết thúc luôn)
+	ACTION_RETURN   = "RETURN"   // Trả về bước trước (Hoặc trả về đầu)
+	ACTION_CANCEL   = "CANCEL"   // Hủy đơn
+	ACTION_FORWARD  = "FORWARD"  // Người duyệt chuyển task cho người/nhóm khác
+	ACTION_REASSIGN = "REASSIGN" // Admin chuyển task (VD: nhân viên nghỉ việc)
+	ACTION_ESCALATE = "ESCALATE" // Quá hạn -> Leo thang lên Trưởng phòng
+	ACTION_SKIP     = "SKIP"     // Bỏ qua bước (Canskip)
+	ACTION_CLAIM    = "CLAIM"    // Thành viên nhóm nhận xử lý task nhóm
+	ACTION_RELEASE  = "RELEASE"  // Trả task nhóm lại cho cả nhóm
+	ACTION_MIGRATE  = "MIGRATE"  // Admin chuyển đơn sang version quy trình mới
+
+	ACTION_SUBFLOW_START = "SUBFLOW_START" // Bước Sub-workflow tạo đơn con (TargetID = ID đơn con)
+	ACTION_SUBFLOW_END   = "SUBFLOW_END"   // Đơn con kết thúc, đơn cha chạy tiếp
+
+	ACTION_NOTIFY      = "NOTIFY"      // Engine gửi CC/Đồng ký (không chặn đơn)
+	ACTION_ACKNOWLEDGE = "ACKNOWLEDGE" // Người nhận CC xác nhận đã xem
+	ACTION_COUNTERSIGN = "COUNTERSIGN" // Người nhận đồng ký kèm ý kiến
 )
@@ -0,0 +1,85 @@
+package model
+
+import "time"
+
+// Email thông báo chờ gửi (Outbox): Engine ghi trong cùng Transaction với thao tác,
+// NotificationService gửi dần qua SMTP, lỗi thì thử lại sau
+type Notification struct {
+	ID          uint64           `gorm:"primaryKey" json:"id"`
+	Event       string           `gorm:"size:50;not null;index" json:"event"` // EVENT_*
+	InstanceID  uint64           `gorm:"index;not null" json:"instance_id"`
+	TaskID      *uint64          `json:"task_id"`
+	RecipientID string           `gorm:"size:50;not null;index" json:"recipient_id"` // UserID (task nhóm đã tách theo thành viên)
+	Data        NotificationData `gorm:"type:json;serializer:json" json:"data"`      // Dữ liệu lúc phát sinh sự kiện
+
+	Status        string     `gorm:"size:20;default:'PENDING';index:idx_notification_due,priority:1" json:"status"` // NOTIFICATION_*
+	Attempts      int        `gorm:"default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index:idx_notification_due,priority:2" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (Notification) TableName() string {
+	return "workflow_notifications"
+}
+
+// Snapshot đơn/bước lúc phát sinh sự kiện (tên Phòng ban lấy theo ngôn ngữ người nhận lúc gửi)
+type NotificationData struct {
+	DocNum       string     `json:"doc_num"`
+	DocType      string     `json:"doc_type"`
+	ServiceCode  string     `json:"service_code"`
+	WorkflowID   uint64     `json:"workflow_id"`
+	DepartmentID uint64     `json:"department_id"`
+	StepName     string     `json:"step_name,omitempty"`
+	ActorName    string     `json:"actor_name,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	ReasonCode   string     `json:"reason_code,omitempty"`
+	DueDate      *time.Time `json:"due_date,omitempty"`
+}
+
+// Cài đặt nhận thông báo của User (chưa có = Nhận tất cả, ngôn ngữ mặc định)
+type NotificationPreference struct {
+	ID           uint64    `gorm:"primaryKey" json:"id"`
+	UserID       string    `gorm:"size:50;not null;uniqueIndex" json:"user_id"`
+	Language     string    `gorm:"size:5" json:"language"` // LANG_*. Trống = Mặc định hệ thống
+	EmailEnabled bool      `gorm:"not null" json:"email_enabled"`
+	MutedEvents  []string  `gorm:"type:json;serializer:json" json:"muted_events"` // EVENT_* không muốn nhận
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+func (p *NotificationPreference) Wants(event string) bool {
+	if !p.EmailEnabled {
+		return false
+	}
+	for _, e := range p.MutedEvents {
+		if e == event {
+			return false
+		}
+	}
+	return true
+}
+
+// Sự kiện gửi email (EVENT_* trong event.go)
+var NOTIFICATION_EVENTS = []string{EVENT_TASK_ASSIGNED, EVENT_TASK_OVERDUE, EVENT_INSTANCE_APPROVED, EVENT_INSTANCE_REJECTED, EVENT_INSTANCE_RETURNED}
+
+// Trạng thái gửi
+const (
+	NOTIFICATION_PENDING = "PENDING"
+	NOTIFICATION_SENT    = "SENT"
+	NOTIFICATION_FAILED  = "FAILED"  // Hết số lần thử
+	NOTIFICATION_SKIPPED = "SKIPPED" // User tắt thông báo / không có email
+)
+
+// Ngôn ngữ (theo cột NameVN/NameEN/NameTW của dữ liệu gốc)
+const (
+	LANG_VI = "vi"
+	LANG_EN = "en"
+	LANG_TW = "tw"
+)
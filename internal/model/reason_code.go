@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Danh mục lý do Từ chối/Trả về theo quy trình (báo cáo gom theo Code thay vì đọc Comment)
+type ReasonCode struct {
+	ID          uint64   `gorm:"primaryKey" json:"id"`
+	ServiceCode string   `gorm:"size:100;uniqueIndex:idx_reason_service_code" json:"service_code"` // "" = Dùng chung mọi quy trình
+	Code        string   `gorm:"size:50;not null;uniqueIndex:idx_reason_service_code" json:"code"`
+	Label       string   `gorm:"size:255;not null" json:"label"`
+	Actions     []string `gorm:"type:json;serializer:json" json:"actions"` // REJECT, RETURN (trống = cả 2)
+	SortOrder   int      `gorm:"default:0" json:"sort_order"`
+	IsActive    bool     `gorm:"default:true" json:"is_active"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (ReasonCode) TableName() string {
+	return "workflow_reason_codes"
+}
+
+// Hành động dùng lý do (ReasonCode)
+var REASON_ACTIONS = []string{ACTION_REJECT, ACTION_RETURN}
+
+// Lý do áp dụng cho action (Actions trống = mọi hành động trong REASON_ACTIONS)
+func (r *ReasonCode) AppliesTo(action string) bool {
+	if len(r.Actions) == 0 {
+		return true
+	}
+	for _, a := range r.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
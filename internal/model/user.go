@@ -28,3 +28,9 @@ type User struct {
 func (User) TableName() string {
 	return "users"
 }
+
+const (
+	ROLE_USER    = "user"
+	ROLE_ADMIN   = "admin"
+	ROLE_AUDITOR = "auditor" // Kiểm soát viên: Xem mọi đơn, không có quyền quản trị
+)
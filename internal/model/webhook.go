@@ -0,0 +1,82 @@
+package model
+
+import "time"
+
+// Hệ thống khác đăng ký nhận sự kiện của đơn qua HTTP POST (payload JSON có chữ ký HMAC)
+type WebhookSubscription struct {
+	ID           uint64    `gorm:"primaryKey" json:"id"`
+	Name         string    `gorm:"size:100;not null" json:"name"`
+	URL          string    `gorm:"size:500;not null" json:"url"`
+	Secret       string    `gorm:"size:128;not null" json:"-"`                     // Khóa ký HMAC-SHA256, không trả ra API
+	Events       []string  `gorm:"type:json;serializer:json" json:"events"`        // WEBHOOK_EVENTS. Trống = Tất cả
+	ServiceCodes []string  `gorm:"type:json;serializer:json" json:"service_codes"` // Trống = Mọi quy trình
+	IsActive     bool      `gorm:"not null" json:"is_active"`
+	CreatedBy    string    `gorm:"size:50" json:"created_by"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// Nhận sự kiện này không (Event + ServiceCode)
+func (s *WebhookSubscription) Matches(event, serviceCode string) bool {
+	if !s.IsActive {
+		return false
+	}
+	if len(s.Events) > 0 && !containsString(s.Events, event) {
+		return false
+	}
+	return len(s.ServiceCodes) == 0 || containsString(s.ServiceCodes, serviceCode)
+}
+
+// 1 lần gửi sự kiện đến 1 Subscription (Engine ghi cùng Transaction, WebhookService gửi và thử lại)
+type WebhookDelivery struct {
+	ID             uint64 `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint64 `gorm:"not null;index" json:"subscription_id"`
+	EventID        string `gorm:"size:32;not null;index" json:"event_id"` // Giống nhau giữa các Subscription và khi gửi lại -> Bên nhận chống trùng
+	Event          string `gorm:"size:50;not null" json:"event"`
+	InstanceID     uint64 `gorm:"index" json:"instance_id"`
+	Payload        string `gorm:"type:text;not null" json:"payload"` // Body JSON đúng như đã ký
+
+	Status        string    `gorm:"size:20;default:'PENDING';index:idx_webhook_due,priority:1" json:"status"` // WEBHOOK_*
+	Attempts      int       `gorm:"default:0" json:"attempts"`
+	NextAttemptAt time.Time `gorm:"index:idx_webhook_due,priority:2" json:"next_attempt_at"`
+
+	// Kết quả lần gửi gần nhất
+	ResponseStatus int        `json:"response_status"`
+	ResponseBody   string     `gorm:"type:text" json:"response_body,omitempty"` // Cắt ngắn
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	DurationMs     int64      `json:"duration_ms"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+
+	RedeliveryOf *uint64   `gorm:"index" json:"redelivery_of"` // Gửi lại thủ công từ Delivery này
+	CreatedAt    time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Subscription *WebhookSubscription `gorm:"foreignKey:SubscriptionID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// Sự kiện gửi qua Webhook
+var WEBHOOK_EVENTS = []string{EVENT_INSTANCE_CREATED, EVENT_STEP_ADVANCED, EVENT_INSTANCE_APPROVED, EVENT_INSTANCE_REJECTED, EVENT_INSTANCE_CANCELLED}
+
+const (
+	WEBHOOK_PENDING   = "PENDING"
+	WEBHOOK_SUCCESS   = "SUCCESS"
+	WEBHOOK_FAILED    = "FAILED"    // Hết số lần thử
+	WEBHOOK_CANCELLED = "CANCELLED" // Subscription đã tắt/xóa trước khi gửi được
+)
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,16 +1,19 @@
 package model
 
 type WorkflowDefinition struct {
-	ID           uint64         `gorm:"primaryKey;autoIncrement" json:"id"`
-	ServiceCode  string         `gorm:"size:100;not null;uniqueIndex:idx_service_code" json:"service_code"`
-	Operation    string         `gorm:"size:50;index" json:"operation"` // Tên thao tác PURI05
-	WorkflowName string         `json:"workflow_name"`
-	Description  string         `json:"description"`
-	Version      int            `gorm:"default:1" json:"version"`
-	IsActive     bool           `gorm:"default:true" json:"is_active"`
-	CreatedAt    int64          `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt    int64          `gorm:"autoUpdateTime" json:"updated_at"`
-	Steps        []WorkflowStep `gorm:"foreignKey:WorkflowDefinitionID;constraint:OnDelete:CASCADE" json:"steps"`
+	ID           uint64 `gorm:"primaryKey;autoIncrement" json:"id"`
+	ServiceCode  string `gorm:"size:100;not null;uniqueIndex:idx_service_code" json:"service_code"`
+	Operation    string `gorm:"size:50;index" json:"operation"` // Tên thao tác PURI05
+	WorkflowName string `json:"workflow_name"`
+	Description  string `json:"description"`
+	Version      int    `gorm:"default:1" json:"version"`
+	// ServiceCode gốc của cả chuỗi version (ServiceCode của bản cũ bị đổi tên khi archive)
+	BaseServiceCode   string         `gorm:"size:100;index" json:"base_service_code"`
+	PreviousVersionID *uint64        `json:"previous_version_id"`
+	IsActive          bool           `gorm:"default:true" json:"is_active"`
+	CreatedAt         int64          `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         int64          `gorm:"autoUpdateTime" json:"updated_at"`
+	Steps             []WorkflowStep `gorm:"foreignKey:WorkflowDefinitionID;constraint:OnDelete:CASCADE" json:"steps"`
 }
 
 func (WorkflowDefinition) TableName() string {
@@ -23,11 +26,17 @@ type WorkflowStep struct {
 	StepCode             string                   `gorm:"size:100;not null" json:"step_code"`
 	StepName             string                   `gorm:"not null" json:"step_name"`
 	StepOrder            int                      `gorm:"not null" json:"step_order"`
+	StepType             string                   `gorm:"size:20;default:'APPROVAL'" json:"step_type"` // APPROVAL chặn đơn, NOTIFY/COUNTERSIGN không chặn
 	RequiredRole         string                   `gorm:"size:100;not null" json:"required_role"`
 	Canskip              bool                     `gorm:"default:false" json:"can_skip"`
 	CanDelegate          bool                     `gorm:"default:false" json:"can_delegate"`
 	RequireComment       bool                     `gorm:"default:false" json:"require_comment"`
 	TimeHours            int                      `gorm:"default:0" json:"time_hours"`
+	TimeoutAction        string                   `gorm:"size:20;default:'ESCALATE'" json:"timeout_action"` // Xử lý khi quá hạn TimeHours
+	Condition            *StepCondition           `gorm:"type:json;serializer:json" json:"condition"`       // Null = bước luôn áp dụng
+	CompletionPolicy     string                   `gorm:"size:20;default:'ANY'" json:"completion_policy"`   // Bước có nhiều người duyệt song song
+	RequiredApprovals    int                      `gorm:"default:0" json:"required_approvals"`              // Số người cần duyệt khi QUORUM
+	SubWorkflowCode      string                   `gorm:"size:100" json:"sub_workflow_code"`                // != "": Tạo đơn con theo quy trình này và chờ kết quả (không giao task)
 	CreatedAt            int64                    `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt            int64                    `gorm:"autoUpdateTime" json:"updated_at"`
 	Assignments          []WorkflowStepAssignment `gorm:"foreignKey:StepID;constraint:OnDelete:CASCADE" json:"assignments"`
@@ -37,12 +46,17 @@ func (WorkflowStep) TableName() string {
 	return "workflow_steps"
 }
 
+// Bước duyệt chặn đơn (đơn chờ ở bước này đến khi đủ người duyệt)
+func (s *WorkflowStep) IsBlocking() bool {
+	return IsBlockingStepType(s.StepType)
+}
+
 type WorkflowStepAssignment struct {
 	ID               uint64   `gorm:"primaryKey;autoIncrement" json:"id"`
 	StepID           uint64   `gorm:"not null;index:idx_workflow_step" json:"step_id"`
 	DepartmentIDs    []uint64 `gorm:"type:json;serializer:json" json:"department_ids"`
 	FactoryID        *uint64  `gorm:"index;size:50" json:"factory_id"`
-	AssignedType     string   `gorm:"size:50;not null" json:"assigned_type"`
+	AssignedType     string   `gorm:"size:50;not null" json:"assigned_type"` // ASSIGN_*
 	AssignedIdentity string   `gorm:"size:100;not null" json:"assigned_identity"`
 	Priority         int      `gorm:"default:0" json:"priority"`
 	IsActive         bool     `gorm:"default:true" json:"is_active"`
@@ -53,3 +67,73 @@ type WorkflowStepAssignment struct {
 func (WorkflowStepAssignment) TableName() string {
 	return "workflow_step_assignments"
 }
+
+// Chính sách khi task quá hạn (WorkflowStep.TimeoutAction)
+const (
+	TIMEOUT_NONE         = "NONE"         // Chỉ đánh dấu quá hạn
+	TIMEOUT_ESCALATE     = "ESCALATE"     // Chuyển lên Trưởng phòng
+	TIMEOUT_AUTO_APPROVE = "AUTO_APPROVE" // Hệ thống tự duyệt
+	TIMEOUT_AUTO_REJECT  = "AUTO_REJECT"  // Hệ thống tự từ chối
+)
+
+// Loại rule gán (WorkflowStepAssignment.AssignedType) -> Ý nghĩa của AssignedIdentity
+const (
+	ASSIGN_USER            = "USER"            // UserID
+	ASSIGN_GROUP           = "GROUP"           // GroupCode
+	ASSIGN_CREATOR_MANAGER = "CREATOR_MANAGER" // Bỏ trống: Quản lý trực tiếp của người tạo đơn
+	ASSIGN_DEPARTMENT_HEAD = "DEPARTMENT_HEAD" // Bỏ trống: Trưởng phòng của phòng ban trên đơn
+	ASSIGN_MANAGER_LEVEL   = "MANAGER_LEVEL"   // Số cấp N: Trưởng phòng ban cha N cấp
+	ASSIGN_POSITION_LEVEL  = "POSITION_LEVEL"  // Position.Level: Mọi user có cấp bậc này trong nhà máy
+)
+
+// Loại bước (WorkflowStep.StepType, cache vào WorkflowTask.StepType)
+const (
+	STEP_TYPE_APPROVAL    = "APPROVAL"    // Bước duyệt: Đơn chờ đến khi bước hoàn thành
+	STEP_TYPE_NOTIFY      = "NOTIFY"      // CC: Chỉ thông báo, người nhận bấm "Đã xem"
+	STEP_TYPE_COUNTERSIGN = "COUNTERSIGN" // Đồng ký: Người nhận ký kèm ý kiến, không chặn đơn
+)
+
+// Bước trống StepType (dữ liệu cũ) = APPROVAL
+func IsBlockingStepType(stepType string) bool {
+	return stepType == "" || stepType == STEP_TYPE_APPROVAL
+}
+
+// Điều kiện hoàn thành bước khi có nhiều Task song song (WorkflowStep.CompletionPolicy)
+const (
+	COMPLETION_ANY    = "ANY"    // 1 người duyệt là đủ
+	COMPLETION_ALL    = "ALL"    // Tất cả phải duyệt (VD: QA và Finance cùng ký)
+	COMPLETION_QUORUM = "QUORUM" // Đủ RequiredApprovals người duyệt (N trên M)
+)
+
+// Điều kiện áp dụng bước, tính trên WorkflowInstance.RequestData.
+// Mỗi node là 1 trong 2 dạng:
+//   - Tổ hợp: All (AND) / Any (OR) / Not
+//   - So sánh: Field + Op + Value/Values/Min/Max
+//
+// VD: {"all":[{"field":"amount","op":"gt","value":50000000},{"field":"category","op":"in","values":["IT","CAPEX"]}]}
+type StepCondition struct {
+	All []StepCondition `json:"all,omitempty"`
+	Any []StepCondition `json:"any,omitempty"`
+	Not *StepCondition  `json:"not,omitempty"`
+
+	Field  string        `json:"field,omitempty"` // Đường dẫn trong RequestData, VD: "amount", "header.category"
+	Op     string        `json:"op,omitempty"`
+	Value  interface{}   `json:"value,omitempty"`  // eq, ne, gt, gte, lt, lte
+	Values []interface{} `json:"values,omitempty"` // in, not_in
+	Min    interface{}   `json:"min,omitempty"`    // between (bao gồm 2 đầu)
+	Max    interface{}   `json:"max,omitempty"`
+}
+
+// Toán tử so sánh của StepCondition
+const (
+	COND_EQ      = "eq"
+	COND_NE      = "ne"
+	COND_GT      = "gt"
+	COND_GTE     = "gte"
+	COND_LT      = "lt"
+	COND_LTE     = "lte"
+	COND_BETWEEN = "between"
+	COND_IN      = "in"
+	COND_NOT_IN  = "not_in"
+	COND_EXISTS  = "exists" // Field có trong RequestData và khác null
+)
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// Người/nhóm nhận task sau khi resolve rule gán
+	Assignee struct {
+		ID      string // UserID hoặc GroupCode
+		IsGroup bool
+	}
+
+	// Resolve 1 rule gán (WorkflowStepAssignment) thành người nhận task.
+	// Mỗi AssignedType có 1 resolver, thêm loại mới chỉ cần đăng ký vào defaultAssigneeResolvers
+	AssigneeResolver interface {
+		Resolve(tx *gorm.DB, instance *model.WorkflowInstance, assign *model.WorkflowStepAssignment) ([]Assignee, error)
+	}
+
+	userResolver           struct{}
+	groupResolver          struct{}
+	creatorManagerResolver struct{}
+	departmentHeadResolver struct{}
+	managerLevelResolver   struct{}
+	positionLevelResolver  struct{}
+)
+
+// Giới hạn số cấp đi ngược cây phòng ban (tránh vòng lặp nếu ParentID cấu hình sai)
+const maxDepartmentDepth = 20
+
+func defaultAssigneeResolvers() map[string]AssigneeResolver {
+	return map[string]AssigneeResolver{
+		model.ASSIGN_USER:            userResolver{},
+		model.ASSIGN_GROUP:           groupResolver{},
+		model.ASSIGN_CREATOR_MANAGER: creatorManagerResolver{},
+		model.ASSIGN_DEPARTMENT_HEAD: departmentHeadResolver{},
+		model.ASSIGN_MANAGER_LEVEL:   managerLevelResolver{},
+		model.ASSIGN_POSITION_LEVEL:  positionLevelResolver{},
+	}
+}
+
+// Kiểm tra AssignedType có resolver (Service dùng khi tạo/sửa quy trình)
+func IsAssignmentTypeSupported(assignedType string) bool {
+	_, ok := defaultAssigneeResolvers()[assignedType]
+	return ok
+}
+
+// USER: AssignedIdentity = UserID
+func (userResolver) Resolve(tx *gorm.DB, instance *model.WorkflowInstance, assign *model.WorkflowStepAssignment) ([]Assignee, error) {
+	return []Assignee{{ID: assign.AssignedIdentity}}, nil
+}
+
+// GROUP: AssignedIdentity = GroupCode
+func (groupResolver) Resolve(tx *gorm.DB, instance *model.WorkflowInstance, assign *model.WorkflowStepAssignment) ([]Assignee, error) {
+	return []Assignee{{ID: assign.AssignedIdentity, IsGroup: true}}, nil
+}
+
+// CREATOR_MANAGER: Quản lý trực tiếp của người tạo đơn (User.ManagerID)
+func (creatorManagerResolver) Resolve(tx *gorm.DB, instance *model.WorkflowInstance, assign *model.WorkflowStepAssignment) ([]Assignee, error) {
+	var creator model.User
+	err := tx.Where("id = ?", instance.CreatorID).First(&creator).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if creator.ManagerID == nil || *creator.ManagerID == 0 {
+		return nil, nil
+	}
+	return []Assignee{{ID: strconv.FormatUint(*creator.ManagerID, 10)}}, nil
+}
+
+// DEPARTMENT_HEAD: Trưởng phòng của phòng ban trên đơn
+func (departmentHeadResolver) Resolve(tx *gorm.DB, instance *model.WorkflowInstance, assign *model.WorkflowStepAssignment) ([]Assignee, error) {
+	return singleAssignee(findDepartmentHead(tx, instance.DepartmentID))
+}
+
+// MANAGER_LEVEL: Trưởng phòng của phòng ban cha N cấp (AssignedIdentity = N, 0 = phòng ban của đơn)
+func (managerLevelResolver) Resolve(tx *gorm.DB, instance *model.WorkflowInstance, assign *model.WorkflowStepAssignment) ([]Assignee, error) {
+	levels, err := parseLevel(assign.AssignedIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if levels > maxDepartmentDepth {
+		return nil, fmt.Errorf("manager level %d exceeds max depth %d", levels, maxDepartmentDepth)
+	}
+
+	deptID := instance.DepartmentID
+	for i := 0; i < levels; i++ {
+		var dept model.Department
+		err := tx.First(&dept, deptID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dept.ParentID == nil || *dept.ParentID == 0 {
+			return nil, nil // Hết cây phòng ban
+		}
+		deptID = *dept.ParentID
+	}
+	return singleAssignee(findDepartmentHead(tx, deptID))
+}
+
+// POSITION_LEVEL: Tất cả user đang hoạt động trong nhà máy của đơn có Position.Level = AssignedIdentity
+func (positionLevelResolver) Resolve(tx *gorm.DB, instance *model.WorkflowInstance, assign *model.WorkflowStepAssignment) ([]Assignee, error) {
+	level, err := parseLevel(assign.AssignedIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []uint64
+	if err := tx.Model(&model.User{}).
+		Joins("JOIN positions ON positions.id = users.position_id").
+		Where("positions.level = ? AND users.factory_id = ? AND users.is_active = ?", level, instance.FactoryID, true).
+		Order("users.id ASC").
+		Pluck("users.id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+
+	res := make([]Assignee, 0, len(userIDs))
+	for _, id := range userIDs {
+		res = append(res, Assignee{ID: strconv.FormatUint(id, 10)})
+	}
+	return res, nil
+}
+
+// Trưởng phòng: Department.ManagerID, nếu chưa set thì lấy từ bảng managers
+func findDepartmentHead(tx *gorm.DB, deptID uint64) (string, error) {
+	var dept model.Department
+	err := tx.First(&dept, deptID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+	if err == nil && dept.ManagerID != nil && *dept.ManagerID != 0 {
+		return strconv.FormatUint(*dept.ManagerID, 10), nil
+	}
+
+	var manager model.Manager
+	err = tx.Where("department_id = ?", deptID).First(&manager).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(manager.UserID, 10), nil
+}
+
+func singleAssignee(userID string, err error) ([]Assignee, error) {
+	if err != nil || userID == "" {
+		return nil, err
+	}
+	return []Assignee{{ID: userID}}, nil
+}
+
+func parseLevel(v string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid level %q, expected a non-negative number", v)
+	}
+	return n, nil
+}
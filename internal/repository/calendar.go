@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	calendarRepo struct {
+		db *gorm.DB
+	}
+	CalendarRepo interface {
+		Create(ctx context.Context, cal *model.WorkingCalendar) error
+		GetByID(ctx context.Context, id uint64) (*model.WorkingCalendar, error)
+		GetByFactoryID(ctx context.Context, factoryID uint64) (*model.WorkingCalendar, error)
+		// Lịch của nhà máy kể cả đã tắt (FactoryID là unique)
+		FindAnyByFactoryID(ctx context.Context, factoryID uint64) (*model.WorkingCalendar, error)
+		Update(ctx context.Context, id uint64, req map[string]interface{}) error
+		Delete(ctx context.Context, id uint64) error
+		GetAll(ctx context.Context) ([]model.WorkingCalendar, error)
+
+		// Ngày nghỉ: trùng ngày thì ghi đè tên / loại
+		UpsertHolidays(ctx context.Context, calendarID uint64, holidays []model.CalendarHoliday) error
+		DeleteHoliday(ctx context.Context, calendarID, holidayID uint64) error
+	}
+)
+
+func NewCalendarRepo(db *gorm.DB) CalendarRepo {
+	return &calendarRepo{
+		db: db,
+	}
+}
+
+func (r *calendarRepo) Create(ctx context.Context, cal *model.WorkingCalendar) error {
+	return r.db.WithContext(ctx).Create(cal).Error
+}
+func (r *calendarRepo) GetByID(ctx context.Context, id uint64) (*model.WorkingCalendar, error) {
+	var cal model.WorkingCalendar
+	if err := r.db.WithContext(ctx).
+		Preload("Holidays", func(db *gorm.DB) *gorm.DB { return db.Order("date ASC") }).
+		First(&cal, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("failed to get calendar by id %w", err)
+	}
+	return &cal, nil
+}
+func (r *calendarRepo) GetByFactoryID(ctx context.Context, factoryID uint64) (*model.WorkingCalendar, error) {
+	var cal model.WorkingCalendar
+	if err := r.db.WithContext(ctx).
+		Preload("Holidays").
+		Where("factory_id = ? AND is_active = ?", factoryID, true).
+		First(&cal).Error; err != nil {
+		return nil, err
+	}
+	return &cal, nil
+}
+func (r *calendarRepo) FindAnyByFactoryID(ctx context.Context, factoryID uint64) (*model.WorkingCalendar, error) {
+	var cal model.WorkingCalendar
+	if err := r.db.WithContext(ctx).Where("factory_id = ?", factoryID).First(&cal).Error; err != nil {
+		return nil, err
+	}
+	return &cal, nil
+}
+func (r *calendarRepo) Update(ctx context.Context, id uint64, req map[string]interface{}) error {
+	return r.db.WithContext(ctx).Model(&model.WorkingCalendar{}).Where("id = ?", id).Updates(req).Error
+}
+func (r *calendarRepo) Delete(ctx context.Context, id uint64) error {
+	return r.db.WithContext(ctx).Delete(&model.WorkingCalendar{}, id).Error
+}
+func (r *calendarRepo) GetAll(ctx context.Context) ([]model.WorkingCalendar, error) {
+	var cals []model.WorkingCalendar
+	if err := r.db.WithContext(ctx).Find(&cals).Error; err != nil {
+		return nil, fmt.Errorf("failed to get all calendars %w", err)
+	}
+	return cals, nil
+}
+
+func (r *calendarRepo) UpsertHolidays(ctx context.Context, calendarID uint64, holidays []model.CalendarHoliday) error {
+	if len(holidays) == 0 {
+		return nil
+	}
+	for i := range holidays {
+		holidays[i].ID = 0
+		holidays[i].CalendarID = calendarID
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "calendar_id"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "is_working_day", "source"}),
+	}).Create(&holidays).Error
+}
+func (r *calendarRepo) DeleteHoliday(ctx context.Context, calendarID, holidayID uint64) error {
+	return r.db.WithContext(ctx).
+		Where("id = ? AND calendar_id = ?", holidayID, calendarID).
+		Delete(&model.CalendarHoliday{}).Error
+}
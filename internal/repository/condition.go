@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Giới hạn độ sâu của cây điều kiện (chống cấu hình lỗi/đệ quy quá sâu)
+const maxConditionDepth = 10
+
+// Kiểm tra cấu trúc điều kiện (Service dùng khi tạo/sửa quy trình)
+func ValidateCondition(cond *model.StepCondition) error {
+	return validateCondition(cond, 0)
+}
+
+func validateCondition(cond *model.StepCondition, depth int) error {
+	if cond == nil {
+		return nil
+	}
+	if depth > maxConditionDepth {
+		return fmt.Errorf("condition nested deeper than %d levels", maxConditionDepth)
+	}
+
+	kinds := 0
+	if len(cond.All) > 0 {
+		kinds++
+	}
+	if len(cond.Any) > 0 {
+		kinds++
+	}
+	if cond.Not != nil {
+		kinds++
+	}
+	if cond.Field != "" || cond.Op != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return errors.New("condition node must have exactly one of all, any, not or field/op")
+	}
+
+	// Node tổ hợp: kiểm tra các node con
+	children := append(append([]model.StepCondition{}, cond.All...), cond.Any...)
+	if cond.Not != nil {
+		children = append(children, *cond.Not)
+	}
+	if len(children) > 0 {
+		for i := range children {
+			if err := validateCondition(&children[i], depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if cond.Field == "" {
+		return fmt.Errorf("condition op %s: field is required", cond.Op)
+	}
+
+	switch cond.Op {
+	case model.COND_EQ, model.COND_NE, model.COND_GT, model.COND_GTE, model.COND_LT, model.COND_LTE:
+		if cond.Value == nil {
+			return fmt.Errorf("condition %s %s: value is required", cond.Field, cond.Op)
+		}
+	case model.COND_BETWEEN:
+		if cond.Min == nil || cond.Max == nil {
+			return fmt.Errorf("condition %s between: min and max are required", cond.Field)
+		}
+	case model.COND_IN, model.COND_NOT_IN:
+		if len(cond.Values) == 0 {
+			return fmt.Errorf("condition %s %s: values are required", cond.Field, cond.Op)
+		}
+	case model.COND_EXISTS:
+	default:
+		return fmt.Errorf("condition %s: invalid op %q", cond.Field, cond.Op)
+	}
+	return nil
+}
+
+// Tính điều kiện trên RequestData (JSON). Điều kiện null = luôn đúng.
+// Field không tồn tại -> so sánh trả về false (riêng ne/not_in cũng false)
+func EvaluateCondition(cond *model.StepCondition, requestData []byte) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	if err := ValidateCondition(cond); err != nil {
+		return false, err
+	}
+
+	var data interface{}
+	if len(bytes.TrimSpace(requestData)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(requestData))
+		dec.UseNumber() // Giữ nguyên số lớn (tiền VND) không bị làm tròn float
+		if err := dec.Decode(&data); err != nil {
+			return false, fmt.Errorf("invalid request data: %w", err)
+		}
+	}
+	return evalCondition(cond, data), nil
+}
+
+func evalCondition(cond *model.StepCondition, data interface{}) bool {
+	switch {
+	case len(cond.All) > 0:
+		for i := range cond.All {
+			if !evalCondition(&cond.All[i], data) {
+				return false
+			}
+		}
+		return true
+	case len(cond.Any) > 0:
+		for i := range cond.Any {
+			if evalCondition(&cond.Any[i], data) {
+				return true
+			}
+		}
+		return false
+	case cond.Not != nil:
+		return !evalCondition(cond.Not, data)
+	}
+
+	actual, ok := lookupField(data, cond.Field)
+	if cond.Op == model.COND_EXISTS {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+
+	switch cond.Op {
+	case model.COND_EQ:
+		return compareValues(actual, cond.Value) == 0
+	case model.COND_NE:
+		return compareValues(actual, cond.Value) != 0
+	case model.COND_GT:
+		c := compareValues(actual, cond.Value)
+		return c != cmpInvalid && c > 0
+	case model.COND_GTE:
+		c := compareValues(actual, cond.Value)
+		return c != cmpInvalid && c >= 0
+	case model.COND_LT:
+		c := compareValues(actual, cond.Value)
+		return c != cmpInvalid && c < 0
+	case model.COND_LTE:
+		c := compareValues(actual, cond.Value)
+		return c != cmpInvalid && c <= 0
+	case model.COND_BETWEEN:
+		lo, hi := compareValues(actual, cond.Min), compareValues(actual, cond.Max)
+		return lo != cmpInvalid && hi != cmpInvalid && lo >= 0 && hi <= 0
+	case model.COND_IN, model.COND_NOT_IN:
+		found := false
+		for _, v := range cond.Values {
+			if compareValues(actual, v) == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (cond.Op == model.COND_IN)
+	}
+	return false
+}
+
+// Lấy giá trị theo đường dẫn "a.b.0.c" (số = index mảng)
+func lookupField(data interface{}, path string) (interface{}, bool) {
+	cur := data
+	for _, key := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			cur = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	if cur == nil {
+		return nil, false
+	}
+	return cur, true
+}
+
+// Kết quả so sánh không hợp lệ (VD: so sánh lớn/nhỏ giữa số và chữ)
+const cmpInvalid = -2
+
+// So sánh 2 giá trị: -1, 0, 1 hoặc cmpInvalid.
+// Ưu tiên so sánh số (ERP gửi số dạng chuỗi "15000.00"), sau đó bool, cuối cùng chuỗi
+func compareValues(a, b interface{}) int {
+	fa, okA := toNumber(a)
+	fb, okB := toNumber(b)
+	if okA && okB {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	// Số so với chuỗi không phải số thuần (VD: "1,5") -> Không hợp lệ, không so sánh theo chữ
+	if isNumberType(a) || isNumberType(b) {
+		return cmpInvalid
+	}
+	if ba, ok := a.(bool); ok {
+		if bb, ok := toBool(b); ok && ba == bb {
+			return 0
+		}
+		return cmpInvalid
+	}
+	sa, okA := toText(a)
+	sb, okB := toText(b)
+	if !okA || !okB {
+		return cmpInvalid
+	}
+	return strings.Compare(sa, sb)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		// Chỉ nhận số thuần (VD: "15000.00"). Không đoán dấu phân cách hàng nghìn: "1,5" có thể là 1.5 hoặc 15
+		n = strings.TrimSpace(n)
+		if !plainNumberPattern.MatchString(n) {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+var plainNumberPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d+)?|\.\d+)$`)
+
+// Giá trị kiểu số (không tính chuỗi)
+func isNumberType(v interface{}) bool {
+	switch v.(type) {
+	case json.Number, float64, int, int64:
+		return true
+	}
+	return false
+}
+
+func toBool(v interface{}) (bool, bool) {
+	switch b := v.(type) {
+	case bool:
+		return b, true
+	case string:
+		r, err := strconv.ParseBool(b)
+		return r, err == nil
+	}
+	return false, false
+}
+
+func toText(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case float64, int, int64, bool:
+		return fmt.Sprint(t), true
+	}
+	return "", false
+}
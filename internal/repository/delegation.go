@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	delegationRepo struct {
+		db *gorm.DB
+	}
+	DelegationRepo interface {
+		Create(ctx context.Context, d *model.WorkflowDelegation) error
+		GetByID(ctx context.Context, id uint64) (*model.WorkflowDelegation, error)
+		Update(ctx context.Context, id uint64, req map[string]interface{}) error
+		Delete(ctx context.Context, id uint64) error
+		GetByDelegator(ctx context.Context, delegatorID string) ([]model.WorkflowDelegation, error)
+		GetByDelegate(ctx context.Context, delegateID string) ([]model.WorkflowDelegation, error)
+		// Các ủy quyền đang hiệu lực tại thời điểm "at" mà delegateID được duyệt thay
+		GetActiveForDelegate(ctx context.Context, delegateID string, at time.Time) ([]model.WorkflowDelegation, error)
+	}
+)
+
+func NewDelegationRepo(db *gorm.DB) DelegationRepo {
+	return &delegationRepo{
+		db: db,
+	}
+}
+
+func (r *delegationRepo) Create(ctx context.Context, d *model.WorkflowDelegation) error {
+	return r.db.WithContext(ctx).Create(d).Error
+}
+func (r *delegationRepo) GetByID(ctx context.Context, id uint64) (*model.WorkflowDelegation, error) {
+	var d model.WorkflowDelegation
+	if err := r.db.WithContext(ctx).First(&d, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("failed to get delegation by id %w", err)
+	}
+	return &d, nil
+}
+func (r *delegationRepo) Update(ctx context.Context, id uint64, req map[string]interface{}) error {
+	return r.db.WithContext(ctx).Model(&model.WorkflowDelegation{}).Where("id = ?", id).Updates(req).Error
+}
+func (r *delegationRepo) Delete(ctx context.Context, id uint64) error {
+	return r.db.WithContext(ctx).Delete(&model.WorkflowDelegation{}, id).Error
+}
+func (r *delegationRepo) GetByDelegator(ctx context.Context, delegatorID string) ([]model.WorkflowDelegation, error) {
+	var list []model.WorkflowDelegation
+	if err := r.db.WithContext(ctx).
+		Where("delegator_id = ?", delegatorID).
+		Order("start_date DESC").
+		Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("failed to get delegations of %s: %w", delegatorID, err)
+	}
+	return list, nil
+}
+func (r *delegationRepo) GetByDelegate(ctx context.Context, delegateID string) ([]model.WorkflowDelegation, error) {
+	var list []model.WorkflowDelegation
+	if err := r.db.WithContext(ctx).
+		Where("delegate_id = ?", delegateID).
+		Order("start_date DESC").
+		Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("failed to get delegations for %s: %w", delegateID, err)
+	}
+	return list, nil
+}
+func (r *delegationRepo) GetActiveForDelegate(ctx context.Context, delegateID string, at time.Time) ([]model.WorkflowDelegation, error) {
+	var list []model.WorkflowDelegation
+	if err := r.db.WithContext(ctx).
+		Where("delegate_id = ? AND is_active = ?", delegateID, true).
+		Where("start_date <= ? AND end_date >= ?", at, at).
+		Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("failed to get active delegations for %s: %w", delegateID, err)
+	}
+	return list, nil
+}
+
+// Ủy quyền có áp dụng cho loại đơn này không (ServiceCodes rỗng = tất cả)
+func delegationCovers(d model.WorkflowDelegation, serviceCode string) bool {
+	if len(d.ServiceCodes) == 0 {
+		return true
+	}
+	for _, code := range d.ServiceCodes {
+		if code == serviceCode {
+			return true
+		}
+	}
+	return false
+}
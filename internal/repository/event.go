@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Nơi nhận sự kiện của Engine (VD: Realtime Hub). Publish không được chặn lâu
+type EventPublisher interface {
+	Publish(events ...model.WorkflowEvent)
+}
+
+type eventBufferKey struct{}
+
+// Sự kiện phát sinh trong 1 Transaction, chờ Commit mới phát
+type eventBuffer struct {
+	mu     sync.Mutex
+	events []model.WorkflowEvent
+}
+
+// Gom sự kiện của các thao tác chạy với ctx trả về, gọi done(err) sau khi Commit/Rollback:
+// err == nil -> Phát, ngược lại bỏ. Dùng khi tự mở Transaction rồi gọi InitiateWorkflow
+func (e *instanceRepo) CollectEvents(ctx context.Context) (context.Context, func(err error)) {
+	buf := &eventBuffer{}
+	ctx = context.WithValue(ctx, eventBufferKey{}, buf)
+	return ctx, func(err error) {
+		if err != nil || e.events == nil {
+			return
+		}
+		buf.mu.Lock()
+		events := buf.events
+		buf.events = nil
+		buf.mu.Unlock()
+		if len(events) > 0 {
+			e.events.Publish(events...)
+		}
+	}
+}
+
+// Transaction của Engine: Sự kiện chỉ phát khi Commit thành công
+func (e *instanceRepo) transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	ctx, done := e.CollectEvents(ctx)
+	err := e.db.WithContext(ctx).Transaction(fn)
+	done(err)
+	return err
+}
+
+// Ghi nhận sự kiện vào Transaction hiện tại (tx không có buffer -> Phát ngay)
+func (e *instanceRepo) emit(tx *gorm.DB, event model.WorkflowEvent) {
+	if e.events == nil {
+		return
+	}
+	if tx.Statement.Context != nil {
+		if buf, ok := tx.Statement.Context.Value(eventBufferKey{}).(*eventBuffer); ok {
+			buf.mu.Lock()
+			buf.events = append(buf.events, event)
+			buf.mu.Unlock()
+			return
+		}
+	}
+	e.events.Publish(event)
+}
+
+func newInstanceEvent(eventType string, instance *model.WorkflowInstance) model.WorkflowEvent {
+	return model.WorkflowEvent{
+		Type:        eventType,
+		InstanceID:  instance.ID,
+		ServiceCode: instance.ServiceCode,
+		DocNum:      instance.DocNum,
+		DocType:     instance.DocType,
+		CreatorID:   instance.CreatorID,
+		Status:      instance.Status,
+		CurrentStep: instance.CurrentStep,
+		OccurredAt:  time.Now(),
+	}
+}
+
+func newTaskEvent(eventType string, instance *model.WorkflowInstance, task *model.WorkflowTask, actorID string) model.WorkflowEvent {
+	event := newInstanceEvent(eventType, instance)
+	taskID := task.ID
+	event.TaskID = &taskID
+	event.StepName = task.StepName
+	event.AssignedTo = task.AssignedTo
+	event.IsGroup = task.IsGroup
+	event.ActorID = actorID
+	return event
+}
@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sắp xếp Inbox
+const (
+	INBOX_SORT_RECEIVED = "received_at" // Ngày nhận task (mặc định)
+	INBOX_SORT_DUE      = "due_date"    // Hạn xử lý (task không có hạn xếp cuối)
+)
+
+// Task không có DueDate xếp sau mọi task có hạn
+var noDueDate = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+type (
+	// Bộ lọc Inbox (việc cần duyệt của User, gồm cả việc duyệt thay)
+	TaskFilter struct {
+		DocNum      string // Chứa chuỗi (không phân biệt hoa thường)
+		ServiceCode string
+		DocType     string
+		CreatorID   string
+		FactoryID   uint64
+		From        *time.Time // Ngày nhận task >= From
+		To          *time.Time // Ngày nhận task < To
+		Overdue     *bool      // true: Đã quá hạn, false: Chưa quá hạn / không có hạn
+
+		SortBy   string // INBOX_SORT_*
+		SortDesc bool
+		Cursor   string // NextCursor của trang trước
+		Limit    int
+	}
+	// Số task theo quy trình (badge trên Inbox)
+	TaskCount struct {
+		ServiceCode string
+		Total       int64
+		Overdue     int64
+	}
+)
+
+// Tìm việc cần duyệt theo bộ lọc, phân trang bằng cursor (không bị lệch trang khi có task mới).
+// Trả về nextCursor = "" nếu đã hết
+func (e *instanceRepo) SearchPendingTasks(ctx context.Context, userID string, filter TaskFilter) ([]model.WorkflowTask, string, error) {
+	query, delegations, err := e.inboxQuery(ctx, userID, filter, true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sortKey := "workflow_tasks.created_at"
+	if filter.SortBy == INBOX_SORT_DUE {
+		sortKey = "COALESCE(workflow_tasks.due_date, ?)"
+	}
+	dir, cmp := "ASC", ">"
+	if filter.SortDesc {
+		dir, cmp = "DESC", "<"
+	}
+	keyArgs := func(args ...interface{}) []interface{} {
+		if filter.SortBy == INBOX_SORT_DUE {
+			return append([]interface{}{noDueDate}, args...)
+		}
+		return args
+	}
+
+	if filter.Cursor != "" {
+		at, id, err := decodeInboxCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		// (key, id) > (at, id) theo chiều sắp xếp
+		args := append(keyArgs(at), keyArgs(at, id)...)
+		query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND workflow_tasks.id %s ?))", sortKey, cmp, sortKey, cmp), args...)
+	}
+
+	var tasks []model.WorkflowTask
+	err = query.
+		Select("workflow_tasks.*").
+		Preload("Instance").
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                fmt.Sprintf("%s %s, workflow_tasks.id %s", sortKey, dir, dir),
+			Vars:               keyArgs(),
+			WithoutParentheses: true,
+		}}).
+		Limit(filter.Limit + 1). // Lấy dư 1 để biết còn trang sau
+		Find(&tasks).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(tasks) > filter.Limit {
+		tasks = tasks[:filter.Limit]
+		last := tasks[len(tasks)-1]
+		at := last.CreatedAt
+		if filter.SortBy == INBOX_SORT_DUE {
+			at = noDueDate
+			if last.DueDate != nil {
+				at = *last.DueDate
+			}
+		}
+		nextCursor = encodeInboxCursor(at, last.ID)
+	}
+
+	e.markDelegatedTasks(ctx, userID, delegations, tasks)
+	return tasks, nextCursor, nil
+}
+
+// Đếm việc cần duyệt theo quy trình (bỏ qua filter.ServiceCode để Inbox hiện đủ các tab)
+func (e *instanceRepo) CountPendingTasks(ctx context.Context, userID string, filter TaskFilter) ([]TaskCount, error) {
+	query, _, err := e.inboxQuery(ctx, userID, filter, false)
+	if err != nil {
+		return nil, err
+	}
+	var counts []TaskCount
+	err = query.
+		Select("workflow_instances.service_code, COUNT(*) AS total, "+
+			"SUM(CASE WHEN workflow_tasks.due_date < ? THEN 1 ELSE 0 END) AS overdue", time.Now()).
+		Group("workflow_instances.service_code").
+		Order("workflow_instances.service_code ASC").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// Task đang mở của User (giao trực tiếp/nhóm) + task của người ủy quyền (bước CanDelegate, đúng phạm vi quy trình)
+func (e *instanceRepo) inboxQuery(ctx context.Context, userID string, filter TaskFilter, byService bool) (*gorm.DB, []model.WorkflowDelegation, error) {
+	delegations, err := e.delegationRepo.GetActiveForDelegate(ctx, userID, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scope := e.db.Where(e.assignedScope(userID, e.getUserGroups(ctx, userID)))
+	for _, d := range delegations {
+		delegated := e.db.Where("workflow_steps.can_delegate = ?", true).
+			Where(e.assignedScope(d.DelegatorID, e.getUserGroups(ctx, d.DelegatorID)))
+		if len(d.ServiceCodes) > 0 {
+			delegated = delegated.Where("workflow_instances.service_code IN ?", d.ServiceCodes)
+		}
+		scope = scope.Or(delegated)
+	}
+
+	query := e.db.WithContext(ctx).
+		Model(&model.WorkflowTask{}).
+		Joins("JOIN workflow_instances ON workflow_instances.id = workflow_tasks.instance_id AND workflow_instances.deleted_at IS NULL").
+		Joins("JOIN workflow_steps ON workflow_steps.id = workflow_tasks.step_id").
+		Where("workflow_tasks.status IN ? AND workflow_tasks.step_type = ?", model.TASK_OPEN_STATUSES, model.STEP_TYPE_APPROVAL).
+		Where(scope)
+
+	if filter.DocNum != "" {
+		query = query.Where("workflow_instances.doc_num ILIKE ?", "%"+escapeLike(filter.DocNum)+"%")
+	}
+	if byService && filter.ServiceCode != "" {
+		query = query.Where("workflow_instances.service_code = ?", filter.ServiceCode)
+	}
+	if filter.DocType != "" {
+		query = query.Where("workflow_instances.doc_type = ?", filter.DocType)
+	}
+	if filter.CreatorID != "" {
+		query = query.Where("workflow_instances.creator_id = ?", filter.CreatorID)
+	}
+	if filter.FactoryID != 0 {
+		query = query.Where("workflow_instances.factory_id = ?", filter.FactoryID)
+	}
+	if filter.From != nil {
+		query = query.Where("workflow_tasks.created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("workflow_tasks.created_at < ?", *filter.To)
+	}
+	if filter.Overdue != nil {
+		now := time.Now()
+		if *filter.Overdue {
+			query = query.Where("workflow_tasks.due_date < ?", now)
+		} else {
+			query = query.Where("(workflow_tasks.due_date IS NULL OR workflow_tasks.due_date >= ?)", now)
+		}
+	}
+	return query, delegations, nil
+}
+
+// Gắn DelegatedFrom cho task không phải của User (hiển thị "Duyệt thay ...")
+func (e *instanceRepo) markDelegatedTasks(ctx context.Context, userID string, delegations []model.WorkflowDelegation, tasks []model.WorkflowTask) {
+	if len(delegations) == 0 {
+		return
+	}
+	assignedTo := func(id string, groups []string, task *model.WorkflowTask) bool {
+		if !task.IsGroup {
+			return task.AssignedTo == id
+		}
+		for _, g := range groups {
+			if g == task.AssignedTo {
+				return true
+			}
+		}
+		return false
+	}
+
+	myGroups := e.getUserGroups(ctx, userID)
+	groupsOf := make(map[string][]string, len(delegations))
+	for i := range tasks {
+		t := &tasks[i]
+		if assignedTo(userID, myGroups, t) {
+			continue
+		}
+		for _, d := range delegations {
+			if t.Instance == nil || !delegationCovers(d, t.Instance.ServiceCode) {
+				continue
+			}
+			groups, ok := groupsOf[d.DelegatorID]
+			if !ok {
+				groups = e.getUserGroups(ctx, d.DelegatorID)
+				groupsOf[d.DelegatorID] = groups
+			}
+			if assignedTo(d.DelegatorID, groups, t) {
+				t.DelegatedFrom = d.DelegatorID
+				break
+			}
+		}
+	}
+}
+
+// Cursor = base64("<UnixMicro>|<TaskID>") của task cuối trang
+func encodeInboxCursor(at time.Time, id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d|%d", at.UnixMicro(), id)))
+}
+
+// Cursor sai định dạng / bị sửa tay
+var errInvalidCursor = errors.New("invalid cursor")
+
+func decodeInboxCursor(cursor string) (time.Time, uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	return time.UnixMicro(micros), id, nil
+}
+
+// Ký tự đặc biệt của LIKE trong từ khóa tìm kiếm
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -3,219 +3,1653 @@ package repository
 import (
 	"CQS-KYC/internal/model" // Import package utils chứa SignatureHelper
 	"context"                // Cần để parse JSON DepartmentIDs
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type (
 	instanceRepo struct {
 		db              *gorm.DB
 		groupRepo       GroupRepo
-		signatureHelper SignatureHelper // Sửa lại đường dẫn import
+		delegationRepo  DelegationRepo
+		dueDateCalc     DueDateCalculator
+		signatureHelper SignatureHelper             // Sửa lại đường dẫn import
+		resolvers       map[string]AssigneeResolver // AssignedType -> Resolver
+		commentRequired map[string]bool             // Action luôn bắt buộc Comment (VD: REJECT, RETURN)
+		notifications   bool                        // Ghi email thông báo vào hàng đợi (workflow_notifications)
+		events          EventPublisher              // Nil = Không phát sự kiện realtime
+		webhooks        bool                        // Ghi webhook vào hàng đợi (webhook_deliveries)
+	}
+	// Kết quả chạy thử 1 bước (Simulate), không ghi DB
+	SimulatedStep struct {
+		Step       model.WorkflowStep
+		Tasks      []model.WorkflowTask // Task engine sẽ tạo
+		SkipReason string               // != "" nếu bước bị bỏ qua
+		Error      string               // Engine sẽ báo lỗi ở bước này (dừng chạy thử)
+	}
+	// Kết quả Admin chuyển task hàng loạt
+	ReassignResult struct {
+		Moved            int      // Task cá nhân đã chuyển
+		ReleasedClaims   int      // Task nhóm người cũ đã nhận -> Trả lại cho nhóm
+		SkippedInstances []uint64 // Người nhận đã có task mở trên đơn -> Không chuyển
 	}
 	InstanceRepo interface {
 		// Core Flow
-		InitiateWorkflow(tx *gorm.DB, workflowID uint64, serviceCode, docNum, docType, creatorID string, factoryID, deptID uint64, requestData []byte, ip, device string) (*model.WorkflowInstance, error)
-		ProcessAction(ctx context.Context, instanceID uint64, actorID, actorName, action, comment string) error
+		InitiateWorkflow(tx *gorm.DB, workflowID uint64, serviceCode, docNum, docType, creatorID string, factoryID, deptID uint64, requestData []byte, client model.ClientInfo) (*model.WorkflowInstance, error)
+		// Mở Transaction ngoài Engine (VD: InitiateWorkflow): Mở tx với ctx trả về, gọi done(err) sau Commit để phát sự kiện
+		CollectEvents(ctx context.Context) (context.Context, func(err error))
+		// idempotencyKey != "": Gửi lại cùng Key -> Trả kết quả lần đầu, không xử lý lại
+		// reasonCode: Mã lý do REJECT/RETURN (bắt buộc nếu quy trình có danh mục lý do)
+		ProcessAction(ctx context.Context, instanceID uint64, actorID, actorName, action, comment, reasonCode, idempotencyKey string, client model.ClientInfo) (*model.ActionResult, error)
+
+		// Chuyển việc (Forward/Reassign)
+		ForwardTask(ctx context.Context, instanceID uint64, actorID, actorName, targetID string, targetIsGroup bool, comment string, client model.ClientInfo) error
+		ReassignTasks(ctx context.Context, fromUserID, toUserID, adminID, adminName, serviceCode, comment string, client model.ClientInfo) (*ReassignResult, error)
+
+		// Nhận/Trả task nhóm (Claim/Release)
+		ClaimTask(ctx context.Context, instanceID uint64, actorID, actorName string, client model.ClientInfo) error
+		ReleaseTask(ctx context.Context, instanceID uint64, actorID, actorName string, client model.ClientInfo) error
+		ReleaseExpiredClaims(ctx context.Context, claimedBefore time.Time) (int, error)
+		PurgeIdempotencyKeys(ctx context.Context, createdBefore time.Time) (int64, error)
+
+		// Admin chuyển đơn đang chạy sang version quy trình khác
+		// stepMapping: StepOrder cũ -> StepOrder mới (không có thì map theo StepCode)
+		MigrateInstance(ctx context.Context, instanceID, fromWorkflowID, toWorkflowID uint64, stepMapping map[int]int, adminID, adminName, comment string, client model.ClientInfo) error
+		GetInProgressInstanceIDs(ctx context.Context, workflowID uint64) ([]uint64, error)
+		// InstanceID -> ServiceCode (đơn không tồn tại không có trong map)
+		GetServiceCodes(ctx context.Context, instanceIDs []uint64) (map[uint64]string, error)
+
+		// CC/Đồng ký: Người nhận xác nhận (ghi Log có chữ ký), trả về Action đã ghi
+		AcknowledgeTask(ctx context.Context, instanceID uint64, actorID, actorName, comment string, client model.ClientInfo) (string, error)
+
+		// Admin bỏ qua bước hiện tại (chỉ khi bước cho phép Canskip)
+		SkipStep(ctx context.Context, instanceID uint64, adminID, adminName, comment string, client model.ClientInfo) error
+
+		// Hủy đơn (người tạo, hoặc Admin khi asAdmin). Đơn con đang chạy bị hủy theo,
+		// hủy đơn con thì đơn cha đang chờ bị từ chối
+		CancelInstance(ctx context.Context, instanceID uint64, actorID, actorName, comment string, asAdmin bool, client model.ClientInfo) error
+
+		// Chạy thử quy trình cho 1 người tạo + RequestData mẫu: Trả về các bước và người duyệt engine sẽ giao
+		Simulate(ctx context.Context, workflowID uint64, creatorID string, factoryID, deptID uint64, requestData []byte) ([]SimulatedStep, error)
+
+		// View Data (CÁI EM ĐANG THIẾU)
+		GetPendingTasks(ctx context.Context, userID string) ([]model.WorkflowTask, error)
+		// Inbox có lọc/sắp xếp/phân trang (cursor) và đếm theo quy trình
+		SearchPendingTasks(ctx context.Context, userID string, filter TaskFilter) ([]model.WorkflowTask, string, error)
+		CountPendingTasks(ctx context.Context, userID string, filter TaskFilter) ([]TaskCount, error)
+		GetInfoTasks(ctx context.Context, userID string) ([]model.WorkflowTask, error) // CC/Đồng ký chưa xác nhận
+		GetCompletedTasks(ctx context.Context, userID string, limit int) ([]model.WorkflowTask, error)
+		// viewerID != "": Chỉ trả về nếu User liên quan đến đơn (không có quyền -> NotFound)
+		GetHistory(ctx context.Context, instanceID uint64, viewerID string) ([]model.WorkflowLog, error)
+		// Danh sách / chi tiết đơn (InstanceFilter.VisibleTo, viewerID: giới hạn theo User)
+		ListInstances(ctx context.Context, filter InstanceFilter) ([]model.WorkflowInstance, string, int64, error)
+		GetInstanceDetail(ctx context.Context, id uint64, viewerID string) (*model.WorkflowInstance, error)
+		// Lịch sử gộp của cả cây đơn cha/con chứa instanceID (đơn gốc đứng đầu)
+		GetCombinedHistory(ctx context.Context, instanceID uint64, viewerID string) ([]model.WorkflowInstance, []model.WorkflowLog, error)
+
+		// SLA (Scheduler gọi định kỳ)
+		GetOverdueTasks(ctx context.Context, now time.Time) ([]model.WorkflowTask, error)
+		HandleOverdueTask(ctx context.Context, instanceID, taskID uint64, now time.Time) error
+	}
+)
+
+// Số tầng đơn con tối đa (chống cấu hình Sub-workflow gọi vòng)
+const maxSubWorkflowDepth = 5
+
+func NewWorkflowEngine(db *gorm.DB, groupRepo GroupRepo, delegationRepo DelegationRepo, dueDateCalc DueDateCalculator, signatureHelper SignatureHelper, commentRequiredActions []string, notifications, webhooks bool, events EventPublisher) InstanceRepo {
+	commentRequired := make(map[string]bool, len(commentRequiredActions))
+	for _, a := range commentRequiredActions {
+		commentRequired[strings.ToUpper(a)] = true
+	}
+	return &instanceRepo{db: db, groupRepo: groupRepo, delegationRepo: delegationRepo, dueDateCalc: dueDateCalc, signatureHelper: signatureHelper, resolvers: defaultAssigneeResolvers(), commentRequired: commentRequired, notifications: notifications, webhooks: webhooks, events: events}
+}
+
+// =============================================================================
+// 1. KHỞI TẠO (INITIATE)
+// =============================================================================
+func (e *instanceRepo) InitiateWorkflow(
+	tx *gorm.DB,
+	workflowID uint64,
+	serviceCode, docNum, docType, creatorID string,
+	factoryID, deptID uint64,
+	requestData []byte, client model.ClientInfo,
+) (*model.WorkflowInstance, error) {
+
+	if tx == nil {
+		return nil, errors.New("transaction is required")
+	}
+
+	// 1. Load Workflow & Đếm tổng số bước
+	var workflow model.WorkflowDefinition
+	if err := tx.Preload("Steps").First(&workflow, workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow definition not found: %d", workflowID)
+	}
+	if len(workflow.Steps) == 0 {
+		return nil, errors.New("workflow definition has no steps")
+	}
+	if !workflow.IsActive {
+		return nil, fmt.Errorf("workflow definition %d is archived (version %d)", workflow.ID, workflow.Version)
+	}
+
+	// 2. Tạo Instance (Gắn cố định với version hiện tại của quy trình)
+	instance := model.WorkflowInstance{
+		WorkflowID:      workflow.ID,
+		WorkflowVersion: workflow.Version,
+		ServiceCode:     serviceCode,
+		DocNum:          docNum,
+		DocType:         docType,
+		FactoryID:       factoryID,
+		DepartmentID:    deptID,
+		RequestData:     requestData,
+		CurrentStep:     workflow.Steps[0].StepOrder, // Tạm thời, enterNextStep sẽ set bước đầu tiên thực tế
+		TotalSteps:      len(workflow.Steps),         // Tính tổng số bước
+		Status:          model.STATUS_IN_PROGRESS,
+		CreatorID:       creatorID,
+		StartedAt:       time.Now(),
+	}
+
+	if err := tx.Create(&instance).Error; err != nil {
+		return nil, err
+	}
+
+	// 3. Tạo Log Submit (Chữ ký người tạo)
+	log := e.newSignedLog(&instance, 0, "Submit", model.ACTION_SUBMIT, creatorID, "System Creator", "Created via ERP System", client)
+	if err := tx.Create(&log).Error; err != nil {
+		return nil, err
+	}
+	if err := e.emitLifecycle(tx, newInstanceEvent(model.EVENT_INSTANCE_CREATED, &instance)); err != nil {
+		return nil, err
+	}
+
+	// 4. Phân bổ Task cho bước đầu tiên (có thể bỏ qua các bước Canskip)
+	if err := e.enterNextStep(tx, &instance, true, ""); err != nil {
+		return nil, fmt.Errorf("failed to assign first task: %v", err)
+	}
+
+	return &instance, nil
+}
+
+// =============================================================================
+// 2. XỬ LÝ DUYỆT (PROCESS ACTION)
+// =============================================================================
+func (e *instanceRepo) ProcessAction(
+	ctx context.Context,
+	instanceID uint64,
+	actorID, actorName, action, comment, reasonCode, idempotencyKey string,
+	client model.ClientInfo,
+) (*model.ActionResult, error) {
+	switch action {
+	case model.ACTION_APPROVE, model.ACTION_REJECT, model.ACTION_RETURN:
+	default:
+		return nil, fmt.Errorf("invalid action %s, must be one of %s, %s, %s", action, model.ACTION_APPROVE, model.ACTION_REJECT, model.ACTION_RETURN)
+	}
+
+	var result *model.ActionResult
+	err := e.transaction(ctx, func(tx *gorm.DB) error {
+		// 0. Idempotency: Giữ chỗ Key trong cùng Transaction (rollback nếu xử lý lỗi -> Client gửi lại được)
+		var idem *model.IdempotencyKey
+		if idempotencyKey != "" {
+			replay, reserved, err := e.reserveIdempotencyKey(tx, actorID, idempotencyKey, instanceID, action, comment, reasonCode)
+			if err != nil {
+				return err
+			}
+			if replay != nil {
+				result = replay
+				return nil
+			}
+			idem = reserved
+		}
+
+		// 1. Load Instance (Khóa dòng để xử lý tuần tự)
+		var instance model.WorkflowInstance
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+			return err
+		}
+
+		if instance.Status != model.STATUS_IN_PROGRESS {
+			return errors.New("request is not in progress")
+		}
+
+		// 2. Check quyền: Tìm task của User/Group của User, hoặc của người ủy quyền cho User
+		myTask, onBehalfOfID, err := e.findActionableTask(ctx, tx, &instance, actorID)
+		if err != nil {
+			return err
+		}
+
+		// 3. Kiểm tra ý kiến / lý do theo cấu hình bước và danh mục lý do
+		if err := e.checkActionInput(tx, &instance, myTask, action, comment, reasonCode); err != nil {
+			return err
+		}
+
+		// 4. Xử lý Action
+		if err := e.applyAction(tx, &instance, myTask, actorID, actorName, onBehalfOfID, action, comment, reasonCode, client); err != nil {
+			return err
+		}
+
+		// Chữ ký vừa ghi (mỗi đơn 1 chữ ký riêng, kể cả khi duyệt hàng loạt)
+		var signed model.WorkflowLog
+		if err := tx.Select("id", "signature_hash").
+			Where("instance_id = ? AND actor_id = ? AND action = ?", instance.ID, actorID, action).
+			Order("id DESC").
+			First(&signed).Error; err != nil {
+			return err
+		}
+
+		result = &model.ActionResult{
+			InstanceID:  instance.ID,
+			Action:      action,
+			Status:      instance.Status,
+			CurrentStep: instance.CurrentStep,
+			Version:     instance.Version,
+			LogID:       signed.ID,
+			Signature:   signed.SignatureHash,
+		}
+		if idem != nil {
+			return tx.Model(idem).Updates(map[string]interface{}{
+				"status":       instance.Status,
+				"current_step": instance.CurrentStep,
+				"log_id":       signed.ID,
+			}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Giữ chỗ Idempotency-Key. Key đã dùng -> Trả kết quả cũ (replay).
+// Nếu 2 request cùng Key đến đồng thời, request sau chờ unique index đến khi request trước commit
+func (e *instanceRepo) reserveIdempotencyKey(
+	tx *gorm.DB,
+	actorID, key string,
+	instanceID uint64,
+	action, comment, reasonCode string,
+) (*model.ActionResult, *model.IdempotencyKey, error) {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s", instanceID, action, comment, reasonCode)))
+	hash := hex.EncodeToString(sum[:])
+
+	idem := model.IdempotencyKey{
+		ActorID:     actorID,
+		Key:         key,
+		RequestHash: hash,
+		InstanceID:  instanceID,
+		Action:      action,
+	}
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&idem)
+	if res.Error != nil {
+		return nil, nil, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return nil, &idem, nil
+	}
+
+	var existing model.IdempotencyKey
+	if err := tx.Where("actor_id = ? AND key = ?", actorID, key).First(&existing).Error; err != nil {
+		return nil, nil, err
+	}
+	if existing.RequestHash != hash {
+		return nil, nil, errors.New("idempotency key was already used for a different request")
+	}
+	result := &model.ActionResult{
+		InstanceID:  existing.InstanceID,
+		Action:      existing.Action,
+		Status:      existing.Status,
+		CurrentStep: existing.CurrentStep,
+		Replayed:    true,
+		LogID:       existing.LogID,
+	}
+	// Trả lại chữ ký của lần xử lý đầu (VD: Duyệt hàng loạt gửi lại cả lô)
+	if existing.LogID != 0 {
+		var signed model.WorkflowLog
+		if err := tx.Select("id", "signature_hash").First(&signed, existing.LogID).Error; err != nil {
+			return nil, nil, err
+		}
+		result.Signature = signed.SignatureHash
+	}
+	return result, nil, nil
+}
+
+// Bước RequireComment: Mọi hành động phải có ý kiến. Action trong commentRequired (REJECT/RETURN) luôn phải có.
+// REJECT/RETURN trên quy trình có danh mục lý do -> Bắt buộc chọn 1 mã lý do đang hoạt động
+func (e *instanceRepo) checkActionInput(tx *gorm.DB, instance *model.WorkflowInstance, task *model.WorkflowTask, action, comment, reasonCode string) error {
+	if strings.TrimSpace(comment) == "" {
+		var step model.WorkflowStep
+		if err := tx.Select("id", "step_name", "require_comment").First(&step, task.StepID).Error; err != nil {
+			return err
+		}
+		if step.RequireComment {
+			return fmt.Errorf("step %s requires a comment", step.StepName)
+		}
+		if e.commentRequired[action] {
+			return fmt.Errorf("a comment is required to %s", strings.ToLower(action))
+		}
+	}
+
+	usesReason := false
+	for _, a := range model.REASON_ACTIONS {
+		if a == action {
+			usesReason = true
+			break
+		}
+	}
+	if !usesReason {
+		if reasonCode != "" {
+			return fmt.Errorf("reason_code is only accepted for %s", strings.Join(model.REASON_ACTIONS, "/"))
+		}
+		return nil
+	}
+
+	reasons, err := activeReasonCodes(tx, instance.ServiceCode, action)
+	if err != nil {
+		return err
+	}
+	codes := make([]string, 0, len(reasons))
+	for _, r := range reasons {
+		if r.Code == reasonCode {
+			return nil
+		}
+		codes = append(codes, r.Code)
+	}
+	switch {
+	case reasonCode == "" && len(codes) == 0:
+		return nil // Quy trình chưa có danh mục lý do
+	case reasonCode == "":
+		return fmt.Errorf("reason_code is required to %s, one of: %s", strings.ToLower(action), strings.Join(codes, ", "))
+	}
+	return fmt.Errorf("invalid reason_code %s for %s on %s", reasonCode, action, instance.ServiceCode)
+}
+
+// Thực thi hành động trên task (dùng chung cho người duyệt và hệ thống SLA)
+func (e *instanceRepo) applyAction(
+	tx *gorm.DB,
+	instance *model.WorkflowInstance,
+	myTask *model.WorkflowTask,
+	actorID, actorName, onBehalfOfID, action, comment, reasonCode string,
+	client model.ClientInfo,
+) error {
+	// 1. Đóng Task (giữ lại để biết ai xử lý, mất bao lâu)
+	status := model.TASK_STATUS_DONE
+	if actorID == model.SYSTEM_ACTOR {
+		status = model.TASK_STATUS_EXPIRED // SLA tự duyệt/từ chối
+	}
+	if err := e.closeTask(tx, myTask, status, actorID, action); err != nil {
+		return err
+	}
+
+	// 2. Ghi Log (Lấy tên bước từ Task, ko cần query lại Step)
+	log := e.newSignedLog(instance, instance.CurrentStep, myTask.StepName, action, actorID, actorName, comment, client)
+	log.OnBehalfOfID = onBehalfOfID
+	log.ReasonCode = reasonCode
+	if err := tx.Create(&log).Error; err != nil {
+		return err
+	}
+
+	// 3. Điều hướng (Routing)
+	if action == model.ACTION_REJECT {
+		// REJECT: Hủy toàn bộ, các Task duyệt còn lại tự đóng (CC vẫn giữ để người nhận xem)
+		if err := e.supersedeTasks(tx, e.blockingTasks(tx, instance.ID)); err != nil {
+			return err
+		}
+		return e.finishInstance(tx, instance, model.STATUS_REJECTED)
+	}
+
+	if action == model.ACTION_APPROVE {
+		// Task gốc và Task leo thang của nó là 1 "suất" duyệt -> Duyệt 1 thì đóng cái kia
+		if err := e.supersedeTasks(tx, e.escalationPairScope(tx, myTask)); err != nil {
+			return err
+		}
+
+		// APPROVE: Kiểm tra bước đã đủ điều kiện hoàn thành (ANY/ALL/QUORUM) chưa
+		done, err := e.stepCompleted(tx, instance)
+		if err != nil || !done {
+			return err
+		}
+		if err := e.supersedeTasks(tx, tx.Where("instance_id = ? AND step_order = ?", instance.ID, instance.CurrentStep)); err != nil {
+			return err
+		}
+
+		// Sang bước tiếp theo
+		return e.enterNextStep(tx, instance, false, actorID)
+	}
+
+	if action == model.ACTION_RETURN {
+		// RETURN: Đóng các Task duyệt còn lại, mở lại bước duyệt trước đó.
+		// Đang ở bước duyệt đầu: Đơn không có trạng thái chờ người tạo sửa/gửi lại -> Mở lại bước đầu cho người duyệt
+		if err := e.supersedeTasks(tx, e.blockingTasks(tx, instance.ID)); err != nil {
+			return err
+		}
+		prevOrder, err := e.previousApprovalStep(tx, instance)
+		if err != nil {
+			return err
+		}
+		if prevOrder == 0 {
+			err = e.enterNextStep(tx, instance, true, "")
+		} else {
+			instance.CurrentStep = prevOrder - 1
+			err = e.enterNextStep(tx, instance, false, "")
+		}
+		if err != nil || instance.Status != model.STATUS_IN_PROGRESS {
+			return err
+		}
+		return e.notifyCreator(tx, model.EVENT_INSTANCE_RETURNED, instance, myTask.StepName, actorName, comment, reasonCode)
+	}
+	return fmt.Errorf("unsupported action %s", action)
+}
+
+// StepOrder của bước duyệt gần nhất trước bước hiện tại đã giao task (0 = Không có, đang ở bước đầu)
+func (e *instanceRepo) previousApprovalStep(tx *gorm.DB, instance *model.WorkflowInstance) (int, error) {
+	var task model.WorkflowTask
+	err := tx.Model(&model.WorkflowTask{}).
+		Joins("JOIN workflow_steps ON workflow_steps.id = workflow_tasks.step_id").
+		Where("workflow_tasks.instance_id = ? AND workflow_tasks.step_type = ? AND workflow_tasks.step_order < ?", instance.ID, model.STEP_TYPE_APPROVAL, instance.CurrentStep).
+		Where("workflow_steps.workflow_definition_id = ?", instance.WorkflowID).
+		Order("workflow_tasks.step_order DESC").
+		First(&task).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return task.StepOrder, nil
+}
+
+// Bước hiện tại đã đủ người duyệt theo CompletionPolicy chưa
+func (e *instanceRepo) stepCompleted(tx *gorm.DB, instance *model.WorkflowInstance) (bool, error) {
+	var step model.WorkflowStep
+	if err := tx.Where("workflow_definition_id = ? AND step_order = ?", instance.WorkflowID, instance.CurrentStep).
+		First(&step).Error; err != nil {
+		return false, err
+	}
+	if step.CompletionPolicy == "" || step.CompletionPolicy == model.COMPLETION_ANY {
+		return true, nil
+	}
+
+	var pending int64
+	if err := tx.Model(&model.WorkflowTask{}).
+		Where("instance_id = ? AND step_order = ? AND status IN ?", instance.ID, step.StepOrder, model.TASK_OPEN_STATUSES).
+		Count(&pending).Error; err != nil {
+		return false, err
+	}
+	if pending == 0 {
+		return true, nil // Không còn ai phải duyệt
+	}
+	if step.CompletionPolicy == model.COMPLETION_ALL {
+		return false, nil
+	}
+
+	// QUORUM: Đếm số Task của bước này đã được duyệt (bỏ qua lượt duyệt trước lần trả về gần nhất)
+	query := tx.Model(&model.WorkflowTask{}).
+		Where("instance_id = ? AND step_order = ? AND outcome = ?", instance.ID, step.StepOrder, model.ACTION_APPROVE)
+	var lastReturn model.WorkflowLog
+	err := tx.Select("id", "created_at").
+		Where("instance_id = ? AND action = ?", instance.ID, model.ACTION_RETURN).
+		Order("id DESC").First(&lastReturn).Error
+	if err == nil {
+		query = query.Where("completed_at > ?", lastReturn.CreatedAt)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	var approvals int64
+	if err := query.Count(&approvals).Error; err != nil {
+		return false, err
+	}
+	return approvals >= int64(step.RequiredApprovals), nil
+}
+
+// Task gốc <-> Task leo thang (EscalatedFromID) của task vừa xử lý
+func (e *instanceRepo) escalationPairScope(tx *gorm.DB, task *model.WorkflowTask) *gorm.DB {
+	if task.EscalatedFromID != nil {
+		return tx.Where("id = ? OR escalated_from_id = ?", *task.EscalatedFromID, *task.EscalatedFromID)
+	}
+	return tx.Where("escalated_from_id = ?", task.ID)
+}
+
+// Ghi nhận kết quả xử lý Task
+func (e *instanceRepo) closeTask(tx *gorm.DB, task *model.WorkflowTask, status, actorID, outcome string) error {
+	now := time.Now()
+	task.Status = status
+	task.CompletedAt = &now
+	task.CompletedBy = actorID
+	task.Outcome = outcome
+	return tx.Model(task).Updates(map[string]interface{}{
+		"status":       status,
+		"completed_at": now,
+		"completed_by": actorID,
+		"outcome":      outcome,
+	}).Error
+}
+
+// Đóng các Task PENDING trong phạm vi scope (không cần xử lý nữa)
+func (e *instanceRepo) supersedeTasks(tx *gorm.DB, scope *gorm.DB) error {
+	return e.closeOpenTasks(tx, scope, model.TASK_STATUS_SUPERSEDED)
+}
+
+func (e *instanceRepo) closeOpenTasks(tx *gorm.DB, scope *gorm.DB, status string) error {
+	return tx.Model(&model.WorkflowTask{}).
+		Where(scope).
+		Where("status IN ?", model.TASK_OPEN_STATUSES).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"completed_at": time.Now(),
+		}).Error
+}
+
+// =============================================================================
+// 2.1 CHUYỂN VIỆC (FORWARD / REASSIGN)
+// =============================================================================
+
+// Người duyệt chuyển task đang chờ của mình cho User hoặc Group khác.
+// Chỉ cho phép ở bước có CanDelegate = true
+func (e *instanceRepo) ForwardTask(
+	ctx context.Context,
+	instanceID uint64,
+	actorID, actorName, targetID string,
+	targetIsGroup bool,
+	comment string,
+	client model.ClientInfo,
+) error {
+	return e.transaction(ctx, func(tx *gorm.DB) error {
+		var instance model.WorkflowInstance
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+			return err
+		}
+		if instance.Status != model.STATUS_IN_PROGRESS {
+			return errors.New("request is not in progress")
+		}
+
+		task, onBehalfOfID, err := e.findActionableTask(ctx, tx, &instance, actorID)
+		if err != nil {
+			return err
+		}
+
+		var step model.WorkflowStep
+		if err := tx.First(&step, task.StepID).Error; err != nil {
+			return err
+		}
+		if !step.CanDelegate {
+			return fmt.Errorf("step %s does not allow forwarding", step.StepName)
+		}
+		if !targetIsGroup && targetID == actorID {
+			return errors.New("cannot forward a task to yourself")
+		}
+		if err := e.validateAssignee(tx, instance.ID, targetID, targetIsGroup); err != nil {
+			return err
+		}
+
+		if err := tx.Model(task).Updates(map[string]interface{}{
+			"assigned_to": targetID,
+			"is_group":    targetIsGroup,
+			"status":      model.TASK_STATUS_PENDING, // Người nhận mới -> Bỏ claim cũ
+			"claimed_by":  "",
+			"claimed_at":  nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		log := e.newSignedLog(&instance, task.StepOrder, task.StepName, model.ACTION_FORWARD, actorID, actorName, comment, client)
+		log.OnBehalfOfID = onBehalfOfID
+		log.TargetID = targetID
+		if err := tx.Create(&log).Error; err != nil {
+			return err
+		}
+		task.AssignedTo, task.IsGroup = targetID, targetIsGroup
+		return e.notifyTasks(tx, model.EVENT_TASK_ASSIGNED, &instance, []model.WorkflowTask{*task})
+	})
+}
+
+// Admin chuyển toàn bộ task PENDING (giao trực tiếp) từ User này sang User khác.
+// serviceCode rỗng = tất cả loại đơn. Mỗi đơn có 1 log REASSIGN ký bởi Admin
+func (e *instanceRepo) ReassignTasks(
+	ctx context.Context,
+	fromUserID, toUserID, adminID, adminName, serviceCode, comment string,
+	client model.ClientInfo,
+) (*ReassignResult, error) {
+	result := &ReassignResult{}
+	err := e.transaction(ctx, func(tx *gorm.DB) error {
+		if fromUserID == toUserID {
+			return errors.New("source and target user must be different")
+		}
+		if err := e.validateAssignee(tx, 0, toUserID, false); err != nil {
+			return err
+		}
+
+		// Task cá nhân đang chờ + Task nhóm người cũ đã nhận
+		query := tx.Model(&model.WorkflowTask{}).
+			Where("(workflow_tasks.status = ? AND workflow_tasks.assigned_to = ? AND workflow_tasks.is_group = ?) OR (workflow_tasks.status = ? AND workflow_tasks.claimed_by = ?)",
+				model.TASK_STATUS_PENDING, fromUserID, false, model.TASK_STATUS_CLAIMED, fromUserID)
+		if serviceCode != "" {
+			query = query.Joins("JOIN workflow_instances ON workflow_instances.id = workflow_tasks.instance_id").
+				Where("workflow_instances.service_code = ?", serviceCode)
+		}
+		var instanceIDs []uint64
+		if err := query.Group("workflow_tasks.instance_id").Order("workflow_tasks.instance_id ASC").
+			Pluck("workflow_tasks.instance_id", &instanceIDs).Error; err != nil {
+			return err
+		}
+
+		// Khóa từng đơn theo thứ tự ID (tránh Deadlock, không chạy song song với ProcessAction)
+		for _, instanceID := range instanceIDs {
+			var instance model.WorkflowInstance
+			if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+				return err
+			}
+			if instance.Status != model.STATUS_IN_PROGRESS {
+				continue
+			}
+			var tasks []model.WorkflowTask
+			if err := tx.Where("instance_id = ?", instance.ID).
+				Where("(status = ? AND assigned_to = ? AND is_group = ?) OR (status = ? AND claimed_by = ?)",
+					model.TASK_STATUS_PENDING, fromUserID, false, model.TASK_STATUS_CLAIMED, fromUserID).
+				Order("id ASC").
+				Find(&tasks).Error; err != nil {
+				return err
+			}
+
+			skipped := false
+			for i := range tasks {
+				task := &tasks[i]
+
+				// Task nhóm: Trả lại cho cả nhóm (người nhận mới chưa chắc thuộc nhóm)
+				if task.IsGroup {
+					if err := e.releaseClaim(tx, task); err != nil {
+						return err
+					}
+					log := e.newSignedLog(&instance, task.StepOrder, task.StepName, model.ACTION_RELEASE, adminID, adminName, comment, client)
+					log.OnBehalfOfID = fromUserID
+					log.TargetID = task.AssignedTo
+					if err := tx.Create(&log).Error; err != nil {
+						return err
+					}
+					e.emit(tx, newTaskEvent(model.EVENT_TASK_RELEASED, &instance, task, adminID))
+					result.ReleasedClaims++
+					continue
+				}
+
+				// Người nhận đã có task mở trên đơn này -> Không chuyển (tránh 1 người duyệt 2 lần), Admin xử lý riêng
+				// (đã bỏ qua 1 task duyệt của đơn -> Bỏ qua các task duyệt còn lại, mỗi đơn chỉ báo 1 lần)
+				if task.StepType == model.STEP_TYPE_APPROVAL {
+					if skipped {
+						continue
+					}
+					if err := e.validateAssignee(tx, instance.ID, toUserID, false); err != nil {
+						result.SkippedInstances = append(result.SkippedInstances, instance.ID)
+						skipped = true
+						continue
+					}
+				}
+				if err := tx.Model(task).Update("assigned_to", toUserID).Error; err != nil {
+					return err
+				}
+				task.AssignedTo = toUserID
+				if err := e.notifyTasks(tx, model.EVENT_TASK_ASSIGNED, &instance, []model.WorkflowTask{*task}); err != nil {
+					return err
+				}
+
+				log := e.newSignedLog(&instance, task.StepOrder, task.StepName, model.ACTION_REASSIGN, adminID, adminName, comment, client)
+				log.OnBehalfOfID = fromUserID
+				log.TargetID = toUserID
+				if err := tx.Create(&log).Error; err != nil {
+					return err
+				}
+				result.Moved++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Thành viên nhóm nhận task nhóm: Các thành viên khác vẫn thấy task nhưng không xử lý được
+func (e *instanceRepo) ClaimTask(ctx context.Context, instanceID uint64, actorID, actorName string, client model.ClientInfo) error {
+	return e.transaction(ctx, func(tx *gorm.DB) error {
+		var instance model.WorkflowInstance
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+			return err
+		}
+		if instance.Status != model.STATUS_IN_PROGRESS {
+			return errors.New("request is not in progress")
+		}
+
+		task, onBehalfOfID, err := e.findActionableTask(ctx, tx, &instance, actorID)
+		if err != nil {
+			return err
+		}
+		if !task.IsGroup {
+			return errors.New("only group tasks can be claimed")
+		}
+		if task.Status == model.TASK_STATUS_CLAIMED {
+			return nil // Đã nhận rồi
+		}
+
+		// Khóa lạc quan: Chỉ 1 người nhận được nếu 2 người bấm cùng lúc
+		now := time.Now()
+		res := tx.Model(&model.WorkflowTask{}).
+			Where("id = ? AND status = ?", task.ID, model.TASK_STATUS_PENDING).
+			Updates(map[string]interface{}{
+				"status":     model.TASK_STATUS_CLAIMED,
+				"claimed_by": actorID,
+				"claimed_at": now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("task has just been claimed by another user")
+		}
+
+		log := e.newSignedLog(&instance, task.StepOrder, task.StepName, model.ACTION_CLAIM, actorID, actorName, "", client)
+		log.OnBehalfOfID = onBehalfOfID
+		log.TargetID = task.AssignedTo
+		if err := tx.Create(&log).Error; err != nil {
+			return err
+		}
+		e.emit(tx, newTaskEvent(model.EVENT_TASK_CLAIMED, &instance, task, actorID))
+		return nil
+	})
+}
+
+// Người đã nhận trả task lại cho cả nhóm
+func (e *instanceRepo) ReleaseTask(ctx context.Context, instanceID uint64, actorID, actorName string, client model.ClientInfo) error {
+	return e.transaction(ctx, func(tx *gorm.DB) error {
+		var instance model.WorkflowInstance
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+			return err
+		}
+
+		var task model.WorkflowTask
+		if err := tx.Where("instance_id = ? AND status = ? AND claimed_by = ?", instance.ID, model.TASK_STATUS_CLAIMED, actorID).
+			First(&task).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("you have not claimed any task on this request")
+			}
+			return err
+		}
+
+		if err := e.releaseClaim(tx, &task); err != nil {
+			return err
+		}
+
+		log := e.newSignedLog(&instance, task.StepOrder, task.StepName, model.ACTION_RELEASE, actorID, actorName, "", client)
+		log.TargetID = task.AssignedTo
+		if err := tx.Create(&log).Error; err != nil {
+			return err
+		}
+		e.emit(tx, newTaskEvent(model.EVENT_TASK_RELEASED, &instance, &task, actorID))
+		return nil
+	})
+}
+
+// Tự trả các task nhận quá lâu mà chưa xử lý (Scheduler gọi định kỳ)
+func (e *instanceRepo) ReleaseExpiredClaims(ctx context.Context, claimedBefore time.Time) (int, error) {
+	released := 0
+	err := e.transaction(ctx, func(tx *gorm.DB) error {
+		var tasks []model.WorkflowTask
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Instance").
+			Where("status = ? AND claimed_at < ?", model.TASK_STATUS_CLAIMED, claimedBefore).
+			Find(&tasks).Error; err != nil {
+			return err
+		}
+
+		for i := range tasks {
+			task := &tasks[i]
+			if err := e.releaseClaim(tx, task); err != nil {
+				return err
+			}
+			if task.Instance != nil {
+				log := e.newSignedLog(task.Instance, task.StepOrder, task.StepName, model.ACTION_RELEASE, model.SYSTEM_ACTOR, "Claim Timeout", "Auto-released: claim timeout", model.ClientInfo{DeviceID: model.SYSTEM_ACTOR})
+				log.OnBehalfOfID = task.ClaimedBy
+				log.TargetID = task.AssignedTo
+				if err := tx.Create(&log).Error; err != nil {
+					return err
+				}
+				e.emit(tx, newTaskEvent(model.EVENT_TASK_RELEASED, task.Instance, task, model.SYSTEM_ACTOR))
+			}
+			released++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return released, nil
+}
+
+// Xóa Idempotency-Key cũ (Client chỉ retry trong thời gian ngắn)
+func (e *instanceRepo) PurgeIdempotencyKeys(ctx context.Context, createdBefore time.Time) (int64, error) {
+	res := e.db.WithContext(ctx).Where("created_at < ?", createdBefore).Delete(&model.IdempotencyKey{})
+	return res.RowsAffected, res.Error
+}
+
+func (e *instanceRepo) releaseClaim(tx *gorm.DB, task *model.WorkflowTask) error {
+	return tx.Model(&model.WorkflowTask{}).
+		Where("id = ? AND status = ?", task.ID, model.TASK_STATUS_CLAIMED).
+		Updates(map[string]interface{}{
+			"status":     model.TASK_STATUS_PENDING,
+			"claimed_by": "",
+			"claimed_at": nil,
+		}).Error
+}
+
+// Người nhận CC/Đồng ký xác nhận. Không đổi trạng thái đơn (làm được cả khi đơn đã kết thúc)
+func (e *instanceRepo) AcknowledgeTask(
+	ctx context.Context,
+	instanceID uint64,
+	actorID, actorName, comment string,
+	client model.ClientInfo,
+) (string, error) {
+	var action string
+	err := e.transaction(ctx, func(tx *gorm.DB) error {
+		var instance model.WorkflowInstance
+		if err := tx.First(&instance, instanceID).Error; err != nil {
+			return err
+		}
+
+		// Khóa Task: 2 thành viên nhóm cùng xác nhận thì chỉ 1 người được ghi nhận
+		var task model.WorkflowTask
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("instance_id = ? AND status IN ? AND step_type <> ?", instance.ID, model.TASK_OPEN_STATUSES, model.STEP_TYPE_APPROVAL).
+			Where(e.assignedScope(actorID, e.getUserGroups(ctx, actorID))).
+			Order("id ASC").
+			First(&task).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("you have no notification to acknowledge on this request")
+		}
+		if err != nil {
+			return err
+		}
+
+		action = model.ACTION_ACKNOWLEDGE
+		if task.StepType == model.STEP_TYPE_COUNTERSIGN {
+			if strings.TrimSpace(comment) == "" {
+				return fmt.Errorf("step %s requires a comment to countersign", task.StepName)
+			}
+			action = model.ACTION_COUNTERSIGN
+		}
+		if err := e.closeTask(tx, &task, model.TASK_STATUS_DONE, actorID, action); err != nil {
+			return err
+		}
+
+		log := e.newSignedLog(&instance, task.StepOrder, task.StepName, action, actorID, actorName, comment, client)
+		if task.IsGroup {
+			log.TargetID = task.AssignedTo
+		}
+		return tx.Create(&log).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return action, nil
+}
+
+// Admin bỏ qua bước hiện tại: Xóa task đang chờ, ghi log SKIP rồi chuyển bước tiếp theo
+func (e *instanceRepo) SkipStep(
+	ctx context.Context,
+	instanceID uint64,
+	adminID, adminName, comment string,
+	client model.ClientInfo,
+) error {
+	return e.transaction(ctx, func(tx *gorm.DB) error {
+		var instance model.WorkflowInstance
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+			return err
+		}
+		if instance.Status != model.STATUS_IN_PROGRESS {
+			return errors.New("request is not in progress")
+		}
+
+		var step model.WorkflowStep
+		if err := tx.Where("workflow_definition_id = ? AND step_order = ?", instance.WorkflowID, instance.CurrentStep).
+			First(&step).Error; err != nil {
+			return err
+		}
+		if !step.Canskip {
+			return fmt.Errorf("step %s cannot be skipped", step.StepName)
+		}
+
+		if err := e.closeOpenTasks(tx, tx.Where("instance_id = ? AND step_order = ?", instance.ID, step.StepOrder), model.TASK_STATUS_CANCELLED); err != nil {
+			return err
+		}
+		// Bước Sub-workflow: Đơn con đang chờ không còn cần nữa
+		reason := fmt.Sprintf("Parent request #%d skipped step %s", instance.ID, step.StepName)
+		if err := e.cancelChildren(tx, &instance, step.StepOrder, adminID, adminName, reason, client); err != nil {
+			return err
+		}
+
+		log := e.newSignedLog(&instance, step.StepOrder, step.StepName, model.ACTION_SKIP, adminID, adminName, comment, client)
+		if err := tx.Create(&log).Error; err != nil {
+			return err
+		}
+
+		return e.enterNextStep(tx, &instance, false, "")
+	})
+}
+
+// Admin chuyển 1 đơn đang chạy sang version khác của cùng quy trình.
+// Task đang mở bị hủy, bước tương ứng ở version mới được giao lại từ đầu
+func (e *instanceRepo) MigrateInstance(
+	ctx context.Context,
+	instanceID, fromWorkflowID, toWorkflowID uint64,
+	stepMapping map[int]int,
+	adminID, adminName, comment string,
+	client model.ClientInfo,
+) error {
+	return e.transaction(ctx, func(tx *gorm.DB) error {
+		var instance model.WorkflowInstance
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+			return err
+		}
+		if instance.Status != model.STATUS_IN_PROGRESS {
+			return errors.New("request is not in progress")
+		}
+		if instance.WorkflowID != fromWorkflowID {
+			return fmt.Errorf("request runs on workflow %d, not %d", instance.WorkflowID, fromWorkflowID)
+		}
+		if instance.WorkflowID == toWorkflowID {
+			return errors.New("request already runs on this workflow version")
+		}
+
+		var from, to model.WorkflowDefinition
+		if err := tx.Preload("Steps").First(&from, instance.WorkflowID).Error; err != nil {
+			return err
+		}
+		if err := tx.Preload("Steps").First(&to, toWorkflowID).Error; err != nil {
+			return fmt.Errorf("target workflow %d not found: %w", toWorkflowID, err)
+		}
+		if baseServiceCode(&from) != baseServiceCode(&to) {
+			return fmt.Errorf("workflow %d is not a version of %s", to.ID, baseServiceCode(&from))
+		}
+
+		// 1. Tìm bước tương ứng ở version mới
+		var current *model.WorkflowStep
+		for i := range from.Steps {
+			if from.Steps[i].StepOrder == instance.CurrentStep {
+				current = &from.Steps[i]
+				break
+			}
+		}
+		if current == nil {
+			return fmt.Errorf("current step %d not found in version %d", instance.CurrentStep, from.Version)
+		}
+		var target *model.WorkflowStep
+		for i := range to.Steps {
+			s := &to.Steps[i]
+			if order, ok := stepMapping[current.StepOrder]; ok {
+				if s.StepOrder == order {
+					target = s
+					break
+				}
+			} else if s.StepCode == current.StepCode {
+				target = s
+				break
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("no step in version %d maps to step %s (order %d)", to.Version, current.StepCode, current.StepOrder)
+		}
+		if current.SubWorkflowCode != "" || target.SubWorkflowCode != "" {
+			return errors.New("cannot migrate a request from or to a sub-workflow step, wait for the sub-workflow to finish")
+		}
+		if !target.IsBlocking() {
+			return fmt.Errorf("step %s of version %d is a %s step, map to an approval step", target.StepCode, to.Version, target.StepType)
+		}
+
+		// 2. Hủy task duyệt đang mở của version cũ
+		if err := e.closeOpenTasks(tx, e.blockingTasks(tx, instance.ID), model.TASK_STATUS_CANCELLED); err != nil {
+			return err
+		}
+
+		// 3. Chuyển đơn sang version mới
+		log := e.newSignedLog(&instance, current.StepOrder, current.StepName, model.ACTION_MIGRATE, adminID, adminName, comment, client)
+		log.TargetID = fmt.Sprintf("v%d:%d", to.Version, target.StepOrder)
+		if err := tx.Create(&log).Error; err != nil {
+			return err
+		}
+
+		instance.WorkflowID = to.ID
+		instance.WorkflowVersion = to.Version
+		instance.TotalSteps = len(to.Steps)
+		instance.CurrentStep = target.StepOrder
+		if err := tx.Save(&instance).Error; err != nil {
+			return err
+		}
+
+		// 4. Giao task của bước tương ứng
+		tasks, err := e.resolveTasks(tx, &instance, target)
+		if err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			return fmt.Errorf("configuration error: step %d of version %d has no valid assignment for factory %d dept %d", target.StepOrder, to.Version, instance.FactoryID, instance.DepartmentID)
+		}
+		if err := tx.Create(&tasks).Error; err != nil {
+			return err
+		}
+		return e.notifyTasks(tx, model.EVENT_TASK_ASSIGNED, &instance, tasks)
+	})
+}
+
+func (e *instanceRepo) GetInProgressInstanceIDs(ctx context.Context, workflowID uint64) ([]uint64, error) {
+	var ids []uint64
+	err := e.db.WithContext(ctx).
+		Model(&model.WorkflowInstance{}).
+		Where("workflow_id = ? AND status = ?", workflowID, model.STATUS_IN_PROGRESS).
+		Order("id ASC").
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+func (e *instanceRepo) GetServiceCodes(ctx context.Context, instanceIDs []uint64) (map[uint64]string, error) {
+	var rows []model.WorkflowInstance
+	if err := e.db.WithContext(ctx).
+		Select("id", "service_code").
+		Where("id IN ?", instanceIDs).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[uint64]string, len(rows))
+	for _, r := range rows {
+		res[r.ID] = r.ServiceCode
+	}
+	return res, nil
+}
+
+// =============================================================================
+// 3. HELPER LOGIC (QUAN TRỌNG)
+// =============================================================================
+
+// Khóa dòng Instance đến hết Transaction (SELECT ... FOR UPDATE) và tăng Version.
+// 2 người duyệt cùng lúc trên 1 đơn sẽ được xử lý tuần tự, người sau thấy trạng thái mới nhất
+func (e *instanceRepo) lockInstance(tx *gorm.DB, instance *model.WorkflowInstance, instanceID uint64) error {
+	if err := e.lockAncestors(tx, instanceID); err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(instance, instanceID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(instance).UpdateColumn("version", gorm.Expr("version + 1")).Error; err != nil {
+		return err
+	}
+	instance.Version++
+	return nil
+}
+
+// Đơn con: Khóa các đơn cha từ gốc xuống trước khi khóa đơn con. Mọi thao tác trên cây cha/con
+// (duyệt đơn con -> resumeParent, hủy đơn cha -> cancelChildren) cùng khóa theo thứ tự cha -> con, tránh deadlock
+func (e *instanceRepo) lockAncestors(tx *gorm.DB, instanceID uint64) error {
+	var ancestors []uint64
+	id := instanceID
+	for depth := 0; depth <= maxSubWorkflowDepth; depth++ {
+		var row model.WorkflowInstance
+		if err := tx.Select("id", "parent_instance_id").First(&row, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) && id == instanceID {
+				return nil // Để lockInstance trả về NotFound
+			}
+			return err
+		}
+		if row.ParentInstanceID == nil {
+			break
+		}
+		id = *row.ParentInstanceID
+		ancestors = append(ancestors, id)
+	}
+
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		var parent model.WorkflowInstance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&parent, ancestors[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tạo Log có chữ ký số cho 1 hành động trên đơn
+func (e *instanceRepo) newSignedLog(
+	instance *model.WorkflowInstance,
+	stepOrder int, stepName, action, actorID, actorName, comment string,
+	client model.ClientInfo,
+) model.WorkflowLog {
+	sigHash, dataHash, signedTime := e.signatureHelper.GenerateSignature(actorID, instance.DocNum, action, stepOrder, instance.RequestData)
+	return model.WorkflowLog{
+		InstanceID:       instance.ID,
+		StepOrder:        stepOrder,
+		StepName:         stepName,
+		Action:           action,
+		ActorID:          actorID,
+		ActorName:        actorName,
+		Comment:          comment,
+		SignatureHash:    sigHash,
+		DataSnapshotHash: dataHash,
+		SignedTimestamp:  signedTime,
+		IPAddress:        client.IPAddress,
+		DeviceInfo:       client.UserAgent,
+		DeviceID:         client.DeviceID,
+	}
+}
+
+// Kiểm tra người/nhóm nhận task hợp lệ và chưa có task PENDING trên đơn này
+func (e *instanceRepo) validateAssignee(tx *gorm.DB, instanceID uint64, targetID string, isGroup bool) error {
+	if targetID == "" {
+		return errors.New("target is required")
+	}
+	if isGroup {
+		var group model.UserGroup
+		if err := tx.Where("group_code = ? AND is_active = ?", targetID, true).First(&group).Error; err != nil {
+			return fmt.Errorf("group %s not found or inactive", targetID)
+		}
+	} else {
+		var user model.User
+		if err := tx.Where("id = ? AND is_active = ?", targetID, true).First(&user).Error; err != nil {
+			return fmt.Errorf("user %s not found or inactive", targetID)
+		}
+	}
+
+	if instanceID != 0 {
+		var count int64
+		if err := tx.Model(&model.WorkflowTask{}).
+			Where("instance_id = ? AND status IN ? AND assigned_to = ? AND is_group = ? AND step_type = ?", instanceID, model.TASK_OPEN_STATUSES, targetID, isGroup, model.STEP_TYPE_APPROVAL).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%s already has a pending task on this request", targetID)
+		}
+	}
+	return nil
+}
+
+// Task duyệt (chặn đơn) của 1 đơn, không gồm CC/Đồng ký
+func (e *instanceRepo) blockingTasks(tx *gorm.DB, instanceID uint64) *gorm.DB {
+	return tx.Where("instance_id = ? AND step_type = ?", instanceID, model.STEP_TYPE_APPROVAL)
+}
+
+func stepTypeOrDefault(stepType string) string {
+	if stepType == "" {
+		return model.STEP_TYPE_APPROVAL
+	}
+	return stepType
+}
+
+// Điều kiện: Task giao cho User (is_group=false) HOẶC giao cho Group của User (is_group=true)
+func (e *instanceRepo) assignedScope(userID string, userGroups []string) *gorm.DB {
+	return e.db.Where("assigned_to = ? AND is_group = ?", userID, false).
+		Or("assigned_to IN ? AND is_group = ?", userGroups, true)
+}
+
+// Task có thể xử lý: Chưa ai nhận, hoặc chính actor đã nhận
+func (e *instanceRepo) claimableScope(actorID string) *gorm.DB {
+	return e.db.Where("status = ? OR (status = ? AND claimed_by = ?)", model.TASK_STATUS_PENDING, model.TASK_STATUS_CLAIMED, actorID)
+}
+
+func isOpenTask(task *model.WorkflowTask) bool {
+	return task.Status == model.TASK_STATUS_PENDING || task.Status == model.TASK_STATUS_CLAIMED
+}
+
+// Tìm task đang mở mà actor được phép xử lý.
+// Trả về onBehalfOfID != "" nếu actor đang duyệt thay (Ủy quyền)
+func (e *instanceRepo) findActionableTask(ctx context.Context, tx *gorm.DB, instance *model.WorkflowInstance, actorID string) (*model.WorkflowTask, string, error) {
+	var task model.WorkflowTask
+
+	// 1. Task của chính mình (chỉ Task duyệt, CC/Đồng ký xác nhận qua AcknowledgeTask)
+	err := tx.Where("instance_id = ? AND step_type = ?", instance.ID, model.STEP_TYPE_APPROVAL).
+		Where(e.claimableScope(actorID)).
+		Where(e.assignedScope(actorID, e.getUserGroups(ctx, actorID))).
+		First(&task).Error
+	if err == nil {
+		return &task, "", nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", err
+	}
+
+	// 2. Task của người đã ủy quyền cho mình
+	delegations, err := e.delegationRepo.GetActiveForDelegate(ctx, actorID, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	var refused error
+	for _, d := range delegations {
+		if !delegationCovers(d, instance.ServiceCode) {
+			continue
+		}
+		// Lỗi DB phải trả về (500), không được coi như "không có task"
+		delegatorGroups, err := e.groupRepo.GetGroupsByUserID(ctx, d.DelegatorID)
+		if err != nil {
+			return nil, "", err
+		}
+		err = tx.Where("instance_id = ? AND step_type = ?", instance.ID, model.STEP_TYPE_APPROVAL).
+			Where(e.claimableScope(actorID)).
+			Where(e.assignedScope(d.DelegatorID, delegatorGroups)).
+			First(&task).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		// Bước có CanDelegate=false -> Không cho duyệt thay
+		var step model.WorkflowStep
+		if err := tx.First(&step, task.StepID).Error; err != nil {
+			return nil, "", err
+		}
+		if !step.CanDelegate {
+			refused = fmt.Errorf("step %s does not allow delegation", step.StepName)
+			continue
+		}
+		return &task, d.DelegatorID, nil
+	}
+	if refused != nil {
+		return nil, "", refused
+	}
+
+	// Task nhóm đã có người khác nhận
+	var claimed model.WorkflowTask
+	if err := tx.Where("instance_id = ? AND status = ? AND claimed_by <> ?", instance.ID, model.TASK_STATUS_CLAIMED, actorID).
+		Where(e.assignedScope(actorID, e.getUserGroups(ctx, actorID))).
+		First(&claimed).Error; err == nil {
+		return nil, "", fmt.Errorf("task is claimed by user %s", claimed.ClaimedBy)
+	}
+
+	return nil, "", errors.New("you do not have permission to approve this request")
+}
+
+// Chuyển đơn sang bước kế tiếp sau instance.CurrentStep (hoặc từ đầu nếu fromStart).
+// Bước có Condition không khớp RequestData luôn bị bỏ qua (ghi log SKIP).
+// Bước có Canskip = true sẽ được bỏ qua (ghi log SKIP) khi:
+//   - Không có rule gán nào khớp Factory/Department của đơn
+//   - Người duyệt chính là người tạo đơn hoặc người vừa duyệt bước trước
+//
+// Hết bước -> Đơn APPROVED
+func (e *instanceRepo) enterNextStep(tx *gorm.DB, instance *model.WorkflowInstance, fromStart bool, prevApprover string) error {
+	fromOrder := instance.CurrentStep
+	if fromStart {
+		fromOrder = 0
+	}
+
+	for {
+		var step model.WorkflowStep
+		err := tx.Where("workflow_definition_id = ? AND step_order > ?", instance.WorkflowID, fromOrder).
+			Order("step_order ASC").First(&step).Error
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Hết bước -> SUCCESS
+			// Ở đây có thể bắn Webhook thông báo về ERP
+			return e.finishInstance(tx, instance, model.STATUS_APPROVED)
+		}
+		if err != nil {
+			return err
+		}
 
-		// View Data (CÁI EM ĐANG THIẾU)
-		GetPendingTasks(ctx context.Context, userID string) ([]model.WorkflowTask, error)
-		GetHistory(ctx context.Context, instanceID uint64) ([]model.WorkflowLog, error)
-	}
-)
+		tasks, skipReason, err := e.planStep(tx, instance, &step, prevApprover)
+		if err != nil {
+			return err
+		}
+
+		if skipReason != "" {
+			log := e.newSignedLog(instance, step.StepOrder, step.StepName, model.ACTION_SKIP, model.SYSTEM_ACTOR, "Workflow Engine", skipReason, model.ClientInfo{DeviceID: model.SYSTEM_ACTOR})
+			if err := tx.Create(&log).Error; err != nil {
+				return err
+			}
+			fromOrder = step.StepOrder
+			continue
+		}
+
+		// CC/Đồng ký: Giao task nhưng không dừng, đi tiếp bước sau
+		if !step.IsBlocking() {
+			if err := tx.Create(&tasks).Error; err != nil {
+				return err
+			}
+			if err := e.notifyTasks(tx, model.EVENT_TASK_ASSIGNED, instance, tasks); err != nil {
+				return err
+			}
+			log := e.newSignedLog(instance, step.StepOrder, step.StepName, model.ACTION_NOTIFY, model.SYSTEM_ACTOR, "Workflow Engine",
+				fmt.Sprintf("Sent to %d recipient(s)", len(tasks)), model.ClientInfo{DeviceID: model.SYSTEM_ACTOR})
+			if err := tx.Create(&log).Error; err != nil {
+				return err
+			}
+			fromOrder = step.StepOrder
+			continue
+		}
+
+		// Dừng ở bước này -> Update Instance & Tạo Task mới
+		instance.CurrentStep = step.StepOrder
+		if err := tx.Save(instance).Error; err != nil {
+			return err
+		}
+		advanced := newInstanceEvent(model.EVENT_STEP_ADVANCED, instance)
+		advanced.StepName = step.StepName
+		if err := e.emitLifecycle(tx, advanced); err != nil {
+			return err
+		}
+		if step.SubWorkflowCode == "" {
+			if err := tx.Create(&tasks).Error; err != nil {
+				return err
+			}
+			return e.notifyTasks(tx, model.EVENT_TASK_ASSIGNED, instance, tasks)
+		}
 
-func NewWorkflowEngine(db *gorm.DB, groupRepo GroupRepo, signatureHelper SignatureHelper) InstanceRepo {
-	return &instanceRepo{db: db, groupRepo: groupRepo, signatureHelper: signatureHelper}
+		// Bước Sub-workflow: Tạo đơn con, đơn cha chờ đến khi đơn con kết thúc
+		finished, err := e.startSubWorkflow(tx, instance, &step)
+		if err != nil || !finished {
+			return err
+		}
+		fromOrder = step.StepOrder
+		prevApprover = ""
+	}
 }
 
-// =============================================================================
-// 1. KHỞI TẠO (INITIATE)
-// =============================================================================
-func (e *instanceRepo) InitiateWorkflow(
-	tx *gorm.DB,
-	workflowID uint64,
-	serviceCode, docNum, docType, creatorID string,
-	factoryID, deptID uint64,
-	requestData []byte, ip, device string,
-) (*model.WorkflowInstance, error) {
+// Tạo đơn con cho bước Sub-workflow. Trả về true nếu đơn con đã duyệt xong ngay (mọi bước đều bị bỏ qua)
+func (e *instanceRepo) startSubWorkflow(tx *gorm.DB, parent *model.WorkflowInstance, step *model.WorkflowStep) (bool, error) {
+	if err := e.checkSubWorkflowDepth(tx, parent); err != nil {
+		return false, err
+	}
 
-	if tx == nil {
-		return nil, errors.New("transaction is required")
+	var def model.WorkflowDefinition
+	if err := tx.Where("service_code = ? AND is_active = ?", step.SubWorkflowCode, true).First(&def).Error; err != nil {
+		return false, fmt.Errorf("sub-workflow %s of step %d not found: %w", step.SubWorkflowCode, step.StepOrder, err)
 	}
 
-	// 1. Load Workflow & Đếm tổng số bước
-	var workflow model.WorkflowDefinition
-	if err := tx.Preload("Steps").First(&workflow, workflowID).Error; err != nil {
-		return nil, fmt.Errorf("workflow definition not found: %d", workflowID)
+	engine := model.ClientInfo{DeviceID: model.SYSTEM_ACTOR}
+	child, err := e.InitiateWorkflow(tx, def.ID, def.ServiceCode, parent.DocNum, parent.DocType, parent.CreatorID, parent.FactoryID, parent.DepartmentID, parent.RequestData, engine)
+	if err != nil {
+		return false, fmt.Errorf("failed to start sub-workflow %s: %w", step.SubWorkflowCode, err)
 	}
-	if len(workflow.Steps) == 0 {
-		return nil, errors.New("workflow definition has no steps")
+	// Gắn đơn cha sau khi khởi tạo: Nếu đơn con xong ngay thì đơn cha tự đi tiếp, không cần báo ngược
+	if err := tx.Model(child).Updates(map[string]interface{}{
+		"parent_instance_id": parent.ID,
+		"parent_step_order":  step.StepOrder,
+	}).Error; err != nil {
+		return false, err
 	}
 
-	// 2. Tạo Instance
-	instance := model.WorkflowInstance{
-		WorkflowID:   workflow.ID,
-		ServiceCode:  serviceCode,
-		DocNum:       docNum,
-		DocType:      docType,
-		FactoryID:    factoryID,
-		DepartmentID: deptID,
-		RequestData:  requestData,
-		CurrentStep:  workflow.Steps[0].StepOrder, // Bước đầu tiên
-		TotalSteps:   len(workflow.Steps),         // Tính tổng số bước
-		Status:       model.STATUS_IN_PROGRESS,
-		CreatorID:    creatorID,
-		StartedAt:    time.Now(),
+	log := e.newSignedLog(parent, step.StepOrder, step.StepName, model.ACTION_SUBFLOW_START, model.SYSTEM_ACTOR, "Workflow Engine",
+		fmt.Sprintf("Started sub-workflow %s (request #%d)", def.ServiceCode, child.ID), engine)
+	log.TargetID = strconv.FormatUint(child.ID, 10)
+	if err := tx.Create(&log).Error; err != nil {
+		return false, err
+	}
+	if child.Status == model.STATUS_IN_PROGRESS {
+		return false, nil
 	}
 
-	if err := tx.Create(&instance).Error; err != nil {
-		return nil, err
+	end := e.newSignedLog(parent, step.StepOrder, step.StepName, model.ACTION_SUBFLOW_END, model.SYSTEM_ACTOR, "Workflow Engine",
+		fmt.Sprintf("Sub-workflow %s (request #%d) finished: %s", def.ServiceCode, child.ID, child.Status), engine)
+	end.TargetID = log.TargetID
+	return true, tx.Create(&end).Error
+}
+
+// Chặn cấu hình Sub-workflow lồng nhau vô hạn (A -> B -> A ...)
+func (e *instanceRepo) checkSubWorkflowDepth(tx *gorm.DB, instance *model.WorkflowInstance) error {
+	depth := 0
+	parentID := instance.ParentInstanceID
+	for parentID != nil {
+		depth++
+		if depth >= maxSubWorkflowDepth {
+			return fmt.Errorf("sub-workflows nested deeper than %d levels, check the workflow configuration for cycles", maxSubWorkflowDepth)
+		}
+		var parent model.WorkflowInstance
+		if err := tx.Select("id", "parent_instance_id").First(&parent, *parentID).Error; err != nil {
+			return err
+		}
+		parentID = parent.ParentInstanceID
 	}
+	return nil
+}
 
-	// 3. Tạo Log Submit (Chữ ký người tạo)
-	sigHash, dataHash, signedTime := e.signatureHelper.GenerateSignature(creatorID, docNum, model.ACTION_SUBMIT, 0, requestData)
+// Đơn kết thúc (APPROVED/REJECTED). Đơn con -> Báo kết quả cho đơn cha đang chờ
+func (e *instanceRepo) finishInstance(tx *gorm.DB, instance *model.WorkflowInstance, status string) error {
+	now := time.Now()
+	instance.Status = status
+	instance.CompletedAt = &now
+	if err := tx.Save(instance).Error; err != nil {
+		return err
+	}
+	if err := e.notifyFinished(tx, instance); err != nil {
+		return err
+	}
+	if instance.ParentInstanceID == nil {
+		return nil
+	}
+	return e.resumeParent(tx, instance)
+}
 
-	log := model.WorkflowLog{
-		InstanceID:       instance.ID,
-		StepOrder:        0,
-		StepName:         "Submit",
-		Action:           model.ACTION_SUBMIT,
-		ActorID:          creatorID,
-		ActorName:        "System Creator",
-		Comment:          "Created via ERP System",
-		SignatureHash:    sigHash,
-		DataSnapshotHash: dataHash,
-		SignedTimestamp:  signedTime,
-		IPAddress:        ip,
-		DeviceInfo:       device,
+// Đơn con kết thúc: Duyệt -> Đơn cha sang bước tiếp theo, còn lại (Từ chối/Hủy) -> Đơn cha bị từ chối
+func (e *instanceRepo) resumeParent(tx *gorm.DB, child *model.WorkflowInstance) error {
+	// Đơn cha đã được khóa trước đơn con (lockAncestors) -> Khóa lại không đổi thứ tự khóa
+	var parent model.WorkflowInstance
+	if err := e.lockInstance(tx, &parent, *child.ParentInstanceID); err != nil {
+		return err
 	}
-	if err := tx.Create(&log).Error; err != nil {
-		return nil, err
+	// Đơn cha đã bị hủy/bỏ qua bước chờ -> Không làm gì
+	if parent.Status != model.STATUS_IN_PROGRESS || parent.CurrentStep != child.ParentStepOrder {
+		return nil
 	}
 
-	// 4. Phân bổ Task cho bước đầu tiên
-	if err := e.distributeTasks(tx, &instance, &workflow.Steps[0]); err != nil {
-		return nil, fmt.Errorf("failed to assign first task: %v", err)
+	var step model.WorkflowStep
+	if err := tx.Where("workflow_definition_id = ? AND step_order = ?", parent.WorkflowID, parent.CurrentStep).
+		First(&step).Error; err != nil {
+		return err
+	}
+	log := e.newSignedLog(&parent, step.StepOrder, step.StepName, model.ACTION_SUBFLOW_END, model.SYSTEM_ACTOR, "Workflow Engine",
+		fmt.Sprintf("Sub-workflow %s (request #%d) finished: %s", child.ServiceCode, child.ID, child.Status), model.ClientInfo{DeviceID: model.SYSTEM_ACTOR})
+	log.TargetID = strconv.FormatUint(child.ID, 10)
+	if err := tx.Create(&log).Error; err != nil {
+		return err
 	}
 
-	return &instance, nil
+	if child.Status == model.STATUS_APPROVED {
+		return e.enterNextStep(tx, &parent, false, "")
+	}
+	if err := e.supersedeTasks(tx, e.blockingTasks(tx, parent.ID)); err != nil {
+		return err
+	}
+	return e.finishInstance(tx, &parent, model.STATUS_REJECTED)
 }
 
 // =============================================================================
-// 2. XỬ LÝ DUYỆT (PROCESS ACTION)
+// HỦY ĐƠN (CANCEL)
 // =============================================================================
-func (e *instanceRepo) ProcessAction(
+func (e *instanceRepo) CancelInstance(
 	ctx context.Context,
 	instanceID uint64,
-	actorID, actorName, action, comment string,
+	actorID, actorName, comment string,
+	asAdmin bool,
+	client model.ClientInfo,
 ) error {
-	return e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// 1. Load Instance
+	return e.transaction(ctx, func(tx *gorm.DB) error {
 		var instance model.WorkflowInstance
-		if err := tx.First(&instance, instanceID).Error; err != nil {
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
 			return err
 		}
-
 		if instance.Status != model.STATUS_IN_PROGRESS {
 			return errors.New("request is not in progress")
 		}
-
-		// 2. Check quyền: Tìm task của User hoặc Group của User
-		userGroups := e.getUserGroups(ctx, actorID) // Sửa: thêm ctx
-
-		var myTask model.WorkflowTask
-		// Logic: Task assigned to ME (is_group=false) OR assigned to MY GROUP (is_group=true)
-		query := tx.Where("instance_id = ? AND status = ?", instanceID, "PENDING").
-			Where(
-				tx.Where("assigned_to = ? AND is_group = ?", actorID, false).
-					Or("assigned_to IN ? AND is_group = ?", userGroups, true),
-			)
-
-		if err := query.First(&myTask).Error; err != nil {
-			return errors.New("you do not have permission to approve this request")
+		if !asAdmin && instance.CreatorID != actorID {
+			return errors.New("only the creator or an admin can cancel this request")
 		}
 
-		// 3. Xử lý Action
-		// 3.1 Xóa Task (Done task)
-		if err := tx.Delete(&myTask).Error; err != nil {
+		if err := e.cancelTree(tx, &instance, actorID, actorName, comment, client); err != nil {
 			return err
 		}
+		if instance.ParentInstanceID == nil {
+			return nil
+		}
+		return e.resumeParent(tx, &instance)
+	})
+}
+
+// Hủy đơn và toàn bộ đơn con đang chạy của nó (không báo đơn cha)
+func (e *instanceRepo) cancelTree(tx *gorm.DB, instance *model.WorkflowInstance, actorID, actorName, comment string, client model.ClientInfo) error {
+	if err := e.closeOpenTasks(tx, tx.Where("instance_id = ?", instance.ID), model.TASK_STATUS_CANCELLED); err != nil {
+		return err
+	}
+
+	log := e.newSignedLog(instance, instance.CurrentStep, "Cancel", model.ACTION_CANCEL, actorID, actorName, comment, client)
+	if err := tx.Create(&log).Error; err != nil {
+		return err
+	}
 
-		// 3.2 Ghi Log
-		sigHash, dataHash, signedTime := e.signatureHelper.GenerateSignature(actorID, instance.DocNum, action, instance.CurrentStep, instance.RequestData)
+	now := time.Now()
+	instance.Status = model.STATUS_CANCELLED
+	instance.CompletedAt = &now
+	if err := tx.Save(instance).Error; err != nil {
+		return err
+	}
+	cancelled := newInstanceEvent(model.EVENT_INSTANCE_CANCELLED, instance)
+	cancelled.ActorID = actorID
+	if err := e.emitLifecycle(tx, cancelled); err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("Parent request #%d cancelled", instance.ID)
+	return e.cancelChildren(tx, instance, 0, actorID, actorName, reason, client)
+}
+
+// Hủy các đơn con đang chạy của đơn cha (stepOrder > 0: chỉ đơn con của bước đó)
+func (e *instanceRepo) cancelChildren(tx *gorm.DB, parent *model.WorkflowInstance, stepOrder int, actorID, actorName, comment string, client model.ClientInfo) error {
+	query := tx.Model(&model.WorkflowInstance{}).
+		Where("parent_instance_id = ? AND status = ?", parent.ID, model.STATUS_IN_PROGRESS)
+	if stepOrder > 0 {
+		query = query.Where("parent_step_order = ?", stepOrder)
+	}
+	var childIDs []uint64
+	if err := query.Order("id ASC").Pluck("id", &childIDs).Error; err != nil {
+		return err
+	}
 
-		log := model.WorkflowLog{
-			InstanceID:       instance.ID,
-			StepOrder:        instance.CurrentStep,
-			StepName:         myTask.StepName, // Lấy tên từ Task, ko cần query lại Step
-			Action:           action,
-			ActorID:          actorID,
-			ActorName:        actorName,
-			Comment:          comment,
-			SignatureHash:    sigHash,
-			DataSnapshotHash: dataHash,
-			SignedTimestamp:  signedTime,
+	for _, id := range childIDs {
+		var child model.WorkflowInstance
+		if err := e.lockInstance(tx, &child, id); err != nil {
+			return err
 		}
-		if err := tx.Create(&log).Error; err != nil {
+		if child.Status != model.STATUS_IN_PROGRESS {
+			continue
+		}
+		if err := e.cancelTree(tx, &child, actorID, actorName, comment, client); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		// 3.3 Điều hướng (Routing)
-		if action == model.ACTION_REJECT {
-			// REJECT: Hủy toàn bộ
-			if err := tx.Where("instance_id = ?", instanceID).Delete(&model.WorkflowTask{}).Error; err != nil {
-				return err
-			}
-
-			now := time.Now()
-			instance.Status = model.STATUS_REJECTED
-			instance.CompletedAt = &now
-			return tx.Save(&instance).Error
-		}
+func (e *instanceRepo) Simulate(ctx context.Context, workflowID uint64, creatorID string, factoryID, deptID uint64, requestData []byte) ([]SimulatedStep, error) {
+	db := e.db.WithContext(ctx)
 
-		if action == model.ACTION_APPROVE {
-			// APPROVE: Tìm bước tiếp theo
-			var nextStep model.WorkflowStep
-			err := tx.Where("workflow_definition_id = ? AND step_order > ?", instance.WorkflowID, instance.CurrentStep).
-				Order("step_order ASC").First(&nextStep).Error
+	var workflow model.WorkflowDefinition
+	if err := db.Preload("Steps", func(q *gorm.DB) *gorm.DB { return q.Order("step_order ASC") }).
+		First(&workflow, workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow definition not found: %d", workflowID)
+	}
 
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				// Hết bước -> SUCCESS
-				now := time.Now()
-				instance.Status = model.STATUS_APPROVED
-				instance.CompletedAt = &now
-				// Ở đây có thể bắn Webhook thông báo về ERP
-				return tx.Save(&instance).Error
-			}
+	// Đơn giả lập (không lưu DB)
+	instance := model.WorkflowInstance{
+		WorkflowID:      workflow.ID,
+		WorkflowVersion: workflow.Version,
+		ServiceCode:     workflow.ServiceCode,
+		FactoryID:       factoryID,
+		DepartmentID:    deptID,
+		RequestData:     requestData,
+		CreatorID:       creatorID,
+		Status:          model.STATUS_IN_PROGRESS,
+	}
 
-			// Còn bước -> Update Instance & Tạo Task mới
-			instance.CurrentStep = nextStep.StepOrder
-			if err := tx.Save(&instance).Error; err != nil {
-				return err
-			}
+	res := make([]SimulatedStep, 0, len(workflow.Steps))
+	prevApprover := ""
+	for i := range workflow.Steps {
+		step := workflow.Steps[i]
+		tasks, skipReason, err := e.planStep(db, &instance, &step, prevApprover)
+		item := SimulatedStep{Step: step, Tasks: tasks, SkipReason: skipReason}
+		if err != nil {
+			item.Error = err.Error()
+			res = append(res, item)
+			break
+		}
+		res = append(res, item)
+		if skipReason != "" || !step.IsBlocking() {
+			continue
+		}
 
-			return e.distributeTasks(tx, &instance, &nextStep)
+		// Chỉ biết chắc người duyệt bước trước khi bước đó có đúng 1 người
+		prevApprover = ""
+		if len(tasks) == 1 && !tasks[0].IsGroup {
+			prevApprover = tasks[0].AssignedTo
 		}
+	}
+	return res, nil
+}
 
-		return nil
-	})
+// Quyết định bước có áp dụng cho đơn không: Trả về Task cần tạo, hoặc lý do bỏ qua (skipReason != "")
+func (e *instanceRepo) planStep(tx *gorm.DB, instance *model.WorkflowInstance, step *model.WorkflowStep, prevApprover string) ([]model.WorkflowTask, string, error) {
+	// Bước có điều kiện (VD: chỉ đơn > 50 triệu mới cần Giám đốc duyệt)
+	applies, err := EvaluateCondition(step.Condition, instance.RequestData)
+	if err != nil {
+		return nil, "", fmt.Errorf("step %d condition: %w", step.StepOrder, err)
+	}
+	if !applies {
+		return nil, "Auto-skipped: step condition not met", nil
+	}
+	// Bước Sub-workflow không giao task (đơn con tự có người duyệt)
+	if step.SubWorkflowCode != "" {
+		return nil, "", nil
+	}
+
+	tasks, err := e.resolveTasks(tx, instance, step)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(tasks) == 0 {
+		// CC không có người nhận thì bỏ qua, không chặn đơn
+		if !step.IsBlocking() {
+			return nil, "Auto-skipped: no recipients", nil
+		}
+		if !step.Canskip {
+			return nil, "", fmt.Errorf("configuration error: step %d has no valid assignment for factory %d dept %d", step.StepOrder, instance.FactoryID, instance.DepartmentID)
+		}
+		return nil, "Auto-skipped: no matching assignment", nil
+	}
+	if step.Canskip && assignedOnlyTo(tasks, instance.CreatorID, prevApprover) {
+		return nil, "Auto-skipped: approver is the creator or previous approver", nil
+	}
+	return tasks, "", nil
 }
 
-// =============================================================================
-// 3. HELPER LOGIC (QUAN TRỌNG)
-// =============================================================================
-func (e *instanceRepo) distributeTasks(tx *gorm.DB, instance *model.WorkflowInstance, step *model.WorkflowStep) error {
+// Resolve rule gán của bước thành danh sách Task (chưa lưu DB)
+func (e *instanceRepo) resolveTasks(tx *gorm.DB, instance *model.WorkflowInstance, step *model.WorkflowStep) ([]model.WorkflowTask, error) {
 	// Lấy tất cả rule gán của bước này
 	var assignments []model.WorkflowStepAssignment
 	// Lưu ý: step.ID phải đúng là ID của bảng steps
 	if err := tx.Where("step_id = ? AND is_active = ?", step.ID, true).Find(&assignments).Error; err != nil {
-		return err
+		return nil, err
+	}
+
+	// Hạn xử lý theo TimeHours của bước (0 = không giới hạn)
+	// CC/Đồng ký không có hạn (không quá hạn, không leo thang)
+	var dueDate *time.Time
+	if step.TimeHours > 0 && step.IsBlocking() {
+		due, err := e.dueDateCalc.AddWorkingHours(tx.Statement.Context, instance.FactoryID, time.Now(), step.TimeHours)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute due date: %w", err)
+		}
+		dueDate = &due
 	}
 
-	tasksCreated := 0
+	tasks := make([]model.WorkflowTask, 0, len(assignments))
+	seen := make(map[string]bool)
 	for _, assign := range assignments {
 		// 1. Lọc theo Factory (Nếu rule có set Factory)
 		if assign.FactoryID != nil && *assign.FactoryID != instance.FactoryID {
@@ -236,61 +1670,194 @@ func (e *instanceRepo) distributeTasks(tx *gorm.DB, instance *model.WorkflowInst
 			}
 		}
 
-		// 3. Tạo Task
-		task := model.WorkflowTask{
-			InstanceID: instance.ID,
-			StepID:     step.ID,
-			StepOrder:  step.StepOrder,
-			StepName:   step.StepName,
-			Status:     "PENDING",
-			AssignedTo: assign.AssignedIdentity,
-			IsGroup:    assign.AssignedType == "GROUP", // Cần đảm bảo enum đúng
+		// 3. Resolve người nhận theo loại rule (USER, GROUP, DEPARTMENT_HEAD...)
+		resolver, ok := e.resolvers[assign.AssignedType]
+		if !ok {
+			return nil, fmt.Errorf("step %d: unsupported assignment type %s", step.StepOrder, assign.AssignedType)
 		}
-		if err := tx.Create(&task).Error; err != nil {
-			return err
+		assignees, err := resolver.Resolve(tx, instance, &assign)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: resolve %s: %w", step.StepOrder, assign.AssignedType, err)
 		}
-		tasksCreated++
-	}
 
-	if tasksCreated == 0 {
-		return fmt.Errorf("configuration error: step %d has no valid assignment for factory %d dept %d", step.StepOrder, instance.FactoryID, instance.DepartmentID)
+		// 4. Tạo Task (bỏ trùng nếu 2 rule cùng ra 1 người/nhóm)
+		for _, a := range assignees {
+			key := fmt.Sprintf("%t|%s", a.IsGroup, a.ID)
+			if a.ID == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			tasks = append(tasks, model.WorkflowTask{
+				InstanceID: instance.ID,
+				StepID:     step.ID,
+				StepOrder:  step.StepOrder,
+				StepName:   step.StepName,
+				StepType:   stepTypeOrDefault(step.StepType),
+				Status:     model.TASK_STATUS_PENDING,
+				AssignedTo: a.ID,
+				IsGroup:    a.IsGroup,
+				DueDate:    dueDate,
+			})
+		}
 	}
 
-	return nil
+	return tasks, nil
+}
+
+// Tất cả task đều giao trực tiếp cho 1 trong các user chỉ định (không tính task nhóm)
+func assignedOnlyTo(tasks []model.WorkflowTask, userIDs ...string) bool {
+	for _, t := range tasks {
+		if t.IsGroup {
+			return false
+		}
+		matched := false
+		for _, id := range userIDs {
+			if id != "" && t.AssignedTo == id {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return len(tasks) > 0
 }
 
 // =============================================================================
 // 4. VIEW DATA (CÁI EM THIẾU)
 // =============================================================================
 
-// Lấy danh sách việc cần làm của User
+// Lấy danh sách việc cần làm của User (bao gồm việc được ủy quyền duyệt thay)
 func (e *instanceRepo) GetPendingTasks(ctx context.Context, userID string) ([]model.WorkflowTask, error) {
 	userGroups := e.getUserGroups(ctx, userID)
 
 	var tasks []model.WorkflowTask
 	err := e.db.WithContext(ctx).
 		Preload("Instance"). // Join để lấy thông tin đơn hàng (DocNum, ServiceCode)
-		Where("status = ?", "PENDING").
-		Where(
-			e.db.Where("assigned_to = ? AND is_group = ?", userID, false).
-				Or("assigned_to IN ? AND is_group = ?", userGroups, true),
-		).
+		Where("status IN ? AND step_type = ?", model.TASK_OPEN_STATUSES, model.STEP_TYPE_APPROVAL).
+		Where(e.assignedScope(userID, userGroups)).
+		Order("created_at DESC").
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Việc của người ủy quyền (chỉ các bước cho phép CanDelegate)
+	delegations, err := e.delegationRepo.GetActiveForDelegate(ctx, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint64]bool, len(tasks))
+	for _, t := range tasks {
+		seen[t.ID] = true
+	}
+	for _, d := range delegations {
+		var delegated []model.WorkflowTask
+		err := e.db.WithContext(ctx).
+			Preload("Instance").
+			Joins("JOIN workflow_steps ON workflow_steps.id = workflow_tasks.step_id").
+			Where("workflow_tasks.status IN ? AND workflow_tasks.step_type = ? AND workflow_steps.can_delegate = ?", model.TASK_OPEN_STATUSES, model.STEP_TYPE_APPROVAL, true).
+			Where(e.assignedScope(d.DelegatorID, e.getUserGroups(ctx, d.DelegatorID))).
+			Order("workflow_tasks.created_at DESC").
+			Find(&delegated).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range delegated {
+			if seen[t.ID] || t.Instance == nil || !delegationCovers(d, t.Instance.ServiceCode) {
+				continue
+			}
+			seen[t.ID] = true
+			t.DelegatedFrom = d.DelegatorID
+			tasks = append(tasks, t)
+		}
+	}
+
+	return tasks, nil
+}
+
+// CC/Đồng ký đang chờ User xác nhận (mục riêng trong Inbox)
+func (e *instanceRepo) GetInfoTasks(ctx context.Context, userID string) ([]model.WorkflowTask, error) {
+	var tasks []model.WorkflowTask
+	err := e.db.WithContext(ctx).
+		Preload("Instance").
+		Where("status IN ? AND step_type <> ?", model.TASK_OPEN_STATUSES, model.STEP_TYPE_APPROVAL).
+		Where(e.assignedScope(userID, e.getUserGroups(ctx, userID))).
 		Order("created_at DESC").
 		Find(&tasks).Error
+	return tasks, err
+}
 
+// Task User đã xử lý (kể cả duyệt thay), mới nhất trước
+func (e *instanceRepo) GetCompletedTasks(ctx context.Context, userID string, limit int) ([]model.WorkflowTask, error) {
+	var tasks []model.WorkflowTask
+	err := e.db.WithContext(ctx).
+		Preload("Instance").
+		Where("completed_by = ?", userID).
+		Order("completed_at DESC").
+		Limit(limit).
+		Find(&tasks).Error
 	return tasks, err
 }
 
 // Lấy lịch sử duyệt của 1 đơn
-func (e *instanceRepo) GetHistory(ctx context.Context, instanceID uint64) ([]model.WorkflowLog, error) {
+func (e *instanceRepo) GetHistory(ctx context.Context, instanceID uint64, viewerID string) ([]model.WorkflowLog, error) {
+	if err := e.checkVisible(ctx, instanceID, viewerID); err != nil {
+		return nil, err
+	}
 	var logs []model.WorkflowLog
 	err := e.db.WithContext(ctx).
 		Where("instance_id = ?", instanceID).
-		Order("step_order ASC").
+		Order("created_at ASC, id ASC"). // Theo thời gian: RETURN đi lại bước cũ vẫn đúng thứ tự
 		Find(&logs).Error
 	return logs, err
 }
 
+func (e *instanceRepo) GetCombinedHistory(ctx context.Context, instanceID uint64, viewerID string) ([]model.WorkflowInstance, []model.WorkflowLog, error) {
+	// 0. Phải xem được đơn đang mở mới thấy cả cây cha/con
+	if err := e.checkVisible(ctx, instanceID, viewerID); err != nil {
+		return nil, nil, err
+	}
+	db := e.db.WithContext(ctx)
+
+	// 1. Đi ngược lên đơn gốc
+	var root model.WorkflowInstance
+	if err := db.First(&root, instanceID).Error; err != nil {
+		return nil, nil, err
+	}
+	for depth := 0; root.ParentInstanceID != nil && depth < maxSubWorkflowDepth; depth++ {
+		var parent model.WorkflowInstance
+		if err := db.First(&parent, *root.ParentInstanceID).Error; err != nil {
+			return nil, nil, err
+		}
+		root = parent
+	}
+
+	// 2. Lấy toàn bộ đơn con theo từng tầng
+	instances := []model.WorkflowInstance{root}
+	ids := []uint64{root.ID}
+	level := []uint64{root.ID}
+	for depth := 0; len(level) > 0 && depth < maxSubWorkflowDepth; depth++ {
+		var children []model.WorkflowInstance
+		if err := db.Where("parent_instance_id IN ?", level).Order("id ASC").Find(&children).Error; err != nil {
+			return nil, nil, err
+		}
+		level = level[:0]
+		for _, c := range children {
+			instances = append(instances, c)
+			ids = append(ids, c.ID)
+			level = append(level, c.ID)
+		}
+	}
+
+	var logs []model.WorkflowLog
+	err := db.Where("instance_id IN ?", ids).
+		Order("created_at ASC, id ASC").
+		Find(&logs).Error
+	return instances, logs, err
+}
+
 // Helper lấy group (Wrapper lại repo cũ)
 func (e *instanceRepo) getUserGroups(ctx context.Context, userID string) []string {
 	groups, err := e.groupRepo.GetGroupsByUserID(ctx, userID)
@@ -299,3 +1866,109 @@ func (e *instanceRepo) getUserGroups(ctx context.Context, userID string) []strin
 	}
 	return groups
 }
+
+// =============================================================================
+// 5. SLA (QUÁ HẠN & LEO THANG)
+// =============================================================================
+
+// Task PENDING đã quá DueDate mà Scheduler chưa xử lý
+func (e *instanceRepo) GetOverdueTasks(ctx context.Context, now time.Time) ([]model.WorkflowTask, error) {
+	var tasks []model.WorkflowTask
+	err := e.db.WithContext(ctx).
+		Where("status IN ? AND step_type = ? AND due_date IS NOT NULL AND due_date < ? AND overdue_at IS NULL", model.TASK_OPEN_STATUSES, model.STEP_TYPE_APPROVAL, now).
+		Order("due_date ASC").
+		Find(&tasks).Error
+	return tasks, err
+}
+
+// Đánh dấu quá hạn và xử lý theo WorkflowStep.TimeoutAction
+func (e *instanceRepo) HandleOverdueTask(ctx context.Context, instanceID, taskID uint64, now time.Time) error {
+	return e.transaction(ctx, func(tx *gorm.DB) error {
+		// 1. Khóa Instance trước rồi mới đọc task (cùng thứ tự khóa với ProcessAction/ForwardTask/ClaimTask,
+		// tránh deadlock và 2 Scheduler chạy song song xử lý trùng)
+		var instance model.WorkflowInstance
+		if err := e.lockInstance(tx, &instance, instanceID); err != nil {
+			return err
+		}
+		var task model.WorkflowTask
+		if err := tx.Where("id = ? AND instance_id = ?", taskID, instanceID).First(&task).Error; err != nil {
+			return err
+		}
+		if !isOpenTask(&task) || task.OverdueAt != nil {
+			return nil // Đã được xử lý
+		}
+
+		var step model.WorkflowStep
+		if err := tx.First(&step, task.StepID).Error; err != nil {
+			return err
+		}
+
+		// 2. Đánh dấu quá hạn
+		if err := tx.Model(&task).Update("overdue_at", now).Error; err != nil {
+			return err
+		}
+		if instance.Status != model.STATUS_IN_PROGRESS || instance.CurrentStep != task.StepOrder {
+			return nil
+		}
+		if err := e.notifyTasks(tx, model.EVENT_TASK_OVERDUE, &instance, []model.WorkflowTask{task}); err != nil {
+			return err
+		}
+
+		// 3. Xử lý theo chính sách của bước
+		system := model.ClientInfo{DeviceID: model.SYSTEM_ACTOR}
+		switch step.TimeoutAction {
+		case model.TIMEOUT_AUTO_APPROVE:
+			return e.applyAction(tx, &instance, &task, model.SYSTEM_ACTOR, "SLA Scheduler", task.AssignedTo, model.ACTION_APPROVE, "Auto-approved: SLA timeout", "", system)
+		case model.TIMEOUT_AUTO_REJECT:
+			return e.applyAction(tx, &instance, &task, model.SYSTEM_ACTOR, "SLA Scheduler", task.AssignedTo, model.ACTION_REJECT, "Auto-rejected: SLA timeout", "", system)
+		case model.TIMEOUT_NONE:
+			return nil
+		default:
+			return e.escalate(tx, &instance, &task, system)
+		}
+	})
+}
+
+// Leo thang: Tạo thêm task cho Trưởng phòng của đơn (Department.ManagerID hoặc bảng managers)
+func (e *instanceRepo) escalate(tx *gorm.DB, instance *model.WorkflowInstance, task *model.WorkflowTask, client model.ClientInfo) error {
+	managerID, err := findDepartmentHead(tx, instance.DepartmentID)
+	if err != nil {
+		return err
+	}
+
+	log := e.newSignedLog(instance, task.StepOrder, task.StepName, model.ACTION_ESCALATE, model.SYSTEM_ACTOR, "SLA Scheduler", "Task overdue", client)
+	log.OnBehalfOfID = task.AssignedTo
+	if managerID == "" || (managerID == task.AssignedTo && !task.IsGroup) {
+		// Không có Trưởng phòng (hoặc chính là người đang giữ task) -> Chỉ ghi nhận quá hạn
+		log.Comment = "Task overdue: no department manager to escalate to"
+		return tx.Create(&log).Error
+	}
+
+	var count int64
+	if err := tx.Model(&model.WorkflowTask{}).
+		Where("instance_id = ? AND status IN ? AND assigned_to = ? AND is_group = ?", instance.ID, model.TASK_OPEN_STATUSES, managerID, false).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		escalation := model.WorkflowTask{
+			InstanceID:      instance.ID,
+			StepID:          task.StepID,
+			StepOrder:       task.StepOrder,
+			StepName:        task.StepName,
+			Status:          model.TASK_STATUS_PENDING,
+			AssignedTo:      managerID,
+			IsGroup:         false,
+			EscalatedFromID: &task.ID,
+		}
+		if err := tx.Create(&escalation).Error; err != nil {
+			return err
+		}
+		if err := e.notifyTasks(tx, model.EVENT_TASK_ASSIGNED, instance, []model.WorkflowTask{escalation}); err != nil {
+			return err
+		}
+	}
+
+	log.TargetID = managerID
+	return tx.Create(&log).Error
+}
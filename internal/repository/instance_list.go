@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Bộ lọc danh sách đơn (người tạo / người duyệt / kiểm soát viên)
+type InstanceFilter struct {
+	VisibleTo    string // UserID chỉ được xem đơn liên quan. "" = Xem tất cả (Admin, Auditor)
+	Status       string
+	CreatorID    string
+	ServiceCode  string
+	FactoryID    uint64
+	DepartmentID uint64
+	DocNum       string     // Chứa chuỗi (không phân biệt hoa thường)
+	From         *time.Time // Ngày tạo đơn >= From
+	To           *time.Time // Ngày tạo đơn < To
+
+	Cursor string // NextCursor của trang trước (ID đơn cuối trang)
+	Limit  int
+}
+
+// Danh sách đơn mới nhất trước, phân trang theo ID. Trả về (đơn, nextCursor, tổng số đơn khớp bộ lọc)
+func (e *instanceRepo) ListInstances(ctx context.Context, filter InstanceFilter) ([]model.WorkflowInstance, string, int64, error) {
+	query := e.db.WithContext(ctx).Model(&model.WorkflowInstance{})
+	if filter.VisibleTo != "" {
+		query = query.Where(e.visibleScope(ctx, filter.VisibleTo))
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.CreatorID != "" {
+		query = query.Where("creator_id = ?", filter.CreatorID)
+	}
+	if filter.ServiceCode != "" {
+		query = query.Where("service_code = ?", filter.ServiceCode)
+	}
+	if filter.FactoryID != 0 {
+		query = query.Where("factory_id = ?", filter.FactoryID)
+	}
+	if filter.DepartmentID != 0 {
+		query = query.Where("department_id = ?", filter.DepartmentID)
+	}
+	if filter.DocNum != "" {
+		query = query.Where("doc_num ILIKE ?", "%"+escapeLike(filter.DocNum)+"%")
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, "", 0, err
+	}
+
+	if filter.Cursor != "" {
+		lastID, err := strconv.ParseUint(filter.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", 0, errInvalidCursor
+		}
+		query = query.Where("id < ?", lastID)
+	}
+
+	var instances []model.WorkflowInstance
+	// Các bước chỉ cần StepOrder để tính % tiến độ
+	stepOrders := func(q *gorm.DB) *gorm.DB {
+		return q.Select("id", "workflow_definition_id", "step_order").Order("step_order ASC")
+	}
+	if err := query.Preload("Workflow.Steps", stepOrders).Order("id DESC").Limit(filter.Limit + 1).Find(&instances).Error; err != nil {
+		return nil, "", 0, err
+	}
+	nextCursor := ""
+	if len(instances) > filter.Limit {
+		instances = instances[:filter.Limit]
+		nextCursor = strconv.FormatUint(instances[len(instances)-1].ID, 10)
+	}
+	return instances, nextCursor, total, nil
+}
+
+// Chi tiết đơn kèm quy trình (các bước), task, log. viewerID != "": Chỉ trả về nếu User liên quan đến đơn
+func (e *instanceRepo) GetInstanceDetail(ctx context.Context, id uint64, viewerID string) (*model.WorkflowInstance, error) {
+	query := e.db.WithContext(ctx).
+		Preload("Workflow.Steps", func(q *gorm.DB) *gorm.DB { return q.Order("step_order ASC") }).
+		Preload("Tasks", func(q *gorm.DB) *gorm.DB { return q.Order("created_at ASC, id ASC") }).
+		Preload("Logs", func(q *gorm.DB) *gorm.DB { return q.Order("created_at ASC, id ASC") }).
+		Where("id = ?", id)
+	if viewerID != "" {
+		query = query.Where(e.visibleScope(ctx, viewerID))
+	}
+
+	var instance model.WorkflowInstance
+	if err := query.First(&instance).Error; err != nil {
+		return nil, err // Không có quyền xem cũng trả về NotFound (không lộ đơn tồn tại)
+	}
+	return &instance, nil
+}
+
+// Đơn tồn tại và viewerID được xem (viewerID = "": Chỉ kiểm tra tồn tại). Không có quyền -> ErrRecordNotFound
+func (e *instanceRepo) checkVisible(ctx context.Context, id uint64, viewerID string) error {
+	query := e.db.WithContext(ctx).Select("id").Where("id = ?", id)
+	if viewerID != "" {
+		query = query.Where(e.visibleScope(ctx, viewerID))
+	}
+	var instance model.WorkflowInstance
+	return query.First(&instance).Error
+}
+
+// Đơn User liên quan: Người tạo, được giao task (trực tiếp/nhóm), đã xử lý task, hoặc có thao tác trong log
+func (e *instanceRepo) visibleScope(ctx context.Context, userID string) *gorm.DB {
+	groups := e.getUserGroups(ctx, userID)
+	return e.db.Where("workflow_instances.creator_id = ?", userID).
+		Or("EXISTS (SELECT 1 FROM workflow_tasks t WHERE t.instance_id = workflow_instances.id AND "+
+			"((t.assigned_to = ? AND t.is_group = ?) OR (t.assigned_to IN ? AND t.is_group = ?) OR t.completed_by = ?))",
+			userID, false, groups, true, userID).
+		Or("EXISTS (SELECT 1 FROM workflow_logs l WHERE l.instance_id = workflow_instances.id AND (l.actor_id = ? OR l.on_behalf_of_id = ?))",
+			userID, userID)
+}
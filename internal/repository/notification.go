@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	notificationRepo struct {
+		db *gorm.DB
+	}
+	// Hàng đợi email (Outbox) và cài đặt nhận thông báo của User
+	NotificationRepo interface {
+		// Lấy các email đến hạn gửi và dời NextAttemptAt thêm lease (nhiều instance chạy song song không gửi trùng)
+		ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]model.Notification, error)
+		MarkSent(ctx context.Context, id uint64, attempts int) error
+		// final = true: Hết số lần thử -> FAILED, ngược lại chờ đến nextAttemptAt
+		MarkFailed(ctx context.Context, id uint64, attempts int, nextAttemptAt time.Time, lastErr string, final bool) error
+		MarkSkipped(ctx context.Context, id uint64, reason string) error
+
+		// Chưa có cài đặt -> Trả về mặc định (nhận tất cả)
+		GetPreference(ctx context.Context, userID string) (*model.NotificationPreference, error)
+		SavePreference(ctx context.Context, pref *model.NotificationPreference) error
+	}
+)
+
+func NewNotificationRepo(db *gorm.DB) NotificationRepo {
+	return &notificationRepo{db: db}
+}
+
+func (r *notificationRepo) ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]model.Notification, error) {
+	var items []model.Notification
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return claimDue(tx, &model.Notification{}, &items, model.NOTIFICATION_PENDING, now, limit, lease)
+	})
+	return items, err
+}
+
+func (r *notificationRepo) MarkSent(ctx context.Context, id uint64, attempts int) error {
+	return r.db.WithContext(ctx).Model(&model.Notification{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     model.NOTIFICATION_SENT,
+		"attempts":   attempts,
+		"sent_at":    time.Now(),
+		"last_error": "",
+	}).Error
+}
+
+func (r *notificationRepo) MarkFailed(ctx context.Context, id uint64, attempts int, nextAttemptAt time.Time, lastErr string, final bool) error {
+	status := model.NOTIFICATION_PENDING
+	if final {
+		status = model.NOTIFICATION_FAILED
+	}
+	return r.db.WithContext(ctx).Model(&model.Notification{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":          status,
+		"attempts":        attempts,
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastErr,
+	}).Error
+}
+
+func (r *notificationRepo) MarkSkipped(ctx context.Context, id uint64, reason string) error {
+	return r.db.WithContext(ctx).Model(&model.Notification{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     model.NOTIFICATION_SKIPPED,
+		"last_error": reason,
+	}).Error
+}
+
+func (r *notificationRepo) GetPreference(ctx context.Context, userID string) (*model.NotificationPreference, error) {
+	var pref model.NotificationPreference
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&pref).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &model.NotificationPreference{UserID: userID, EmailEnabled: true, MutedEvents: []string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pref, nil
+}
+
+// EmailEnabled không có default trong DB -> false được ghi đúng khi tạo mới
+func (r *notificationRepo) SavePreference(ctx context.Context, pref *model.NotificationPreference) error {
+	if pref.ID != 0 {
+		return r.db.WithContext(ctx).Save(pref).Error
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"language", "email_enabled", "muted_events", "updated_at"}),
+	}).Create(pref).Error
+}
+
+// =============================================================================
+// GHI THÔNG BÁO TỪ ENGINE (cùng Transaction với thao tác -> Rollback thì không gửi)
+// =============================================================================
+
+// Báo cho người nhận các task vừa tạo/chuyển/quá hạn: Sự kiện realtime theo task,
+// email cho từng người (task nhóm -> từng thành viên đang hoạt động)
+func (e *instanceRepo) notifyTasks(tx *gorm.DB, event string, instance *model.WorkflowInstance, tasks []model.WorkflowTask) error {
+	for i := range tasks {
+		e.emit(tx, newTaskEvent(event, instance, &tasks[i], ""))
+	}
+	if !e.notifications || len(tasks) == 0 {
+		return nil
+	}
+
+	var groupCodes []string
+	for _, t := range tasks {
+		if t.IsGroup {
+			groupCodes = append(groupCodes, t.AssignedTo)
+		}
+	}
+	members := map[string][]string{}
+	if len(groupCodes) > 0 {
+		var rows []struct {
+			GroupCode string
+			UserID    uint64
+		}
+		if err := tx.Table("user_group_members").
+			Select("user_groups.group_code, user_group_members.user_id").
+			Joins("JOIN user_groups ON user_groups.id = user_group_members.group_id").
+			Where("user_groups.group_code IN ? AND user_groups.is_active = ?", groupCodes, true).
+			Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			members[r.GroupCode] = append(members[r.GroupCode], strconv.FormatUint(r.UserID, 10))
+		}
+	}
+
+	var items []model.Notification
+	seen := map[string]bool{}
+	for i := range tasks {
+		task := &tasks[i]
+		recipients := []string{task.AssignedTo}
+		if task.IsGroup {
+			recipients = members[task.AssignedTo]
+		}
+		for _, userID := range recipients {
+			if userID == "" || userID == model.SYSTEM_ACTOR || seen[userID] {
+				continue
+			}
+			seen[userID] = true
+			data := notificationData(instance)
+			data.StepName = task.StepName
+			data.DueDate = task.DueDate
+			items = append(items, model.Notification{
+				Event:         event,
+				InstanceID:    instance.ID,
+				TaskID:        &task.ID,
+				RecipientID:   userID,
+				Data:          data,
+				Status:        model.NOTIFICATION_PENDING,
+				NextAttemptAt: time.Now(),
+			})
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return tx.Create(&items).Error
+}
+
+// Báo kết quả đơn (Duyệt/Từ chối/Trả về) cho người tạo
+func (e *instanceRepo) notifyCreator(tx *gorm.DB, event string, instance *model.WorkflowInstance, stepName, actorName, comment, reasonCode string) error {
+	ev := newInstanceEvent(event, instance)
+	ev.StepName = stepName
+	if err := e.emitLifecycle(tx, ev); err != nil {
+		return err
+	}
+	if !e.notifications || instance.CreatorID == "" || instance.CreatorID == model.SYSTEM_ACTOR {
+		return nil
+	}
+	data := notificationData(instance)
+	data.StepName = stepName
+	data.ActorName = actorName
+	data.Comment = comment
+	data.ReasonCode = reasonCode
+	item := model.Notification{
+		Event:         event,
+		InstanceID:    instance.ID,
+		RecipientID:   instance.CreatorID,
+		Data:          data,
+		Status:        model.NOTIFICATION_PENDING,
+		NextAttemptAt: time.Now(),
+	}
+	return tx.Create(&item).Error
+}
+
+// Đơn kết thúc: Lấy người duyệt/từ chối cuối cùng để báo người tạo.
+// Đơn con không gửi email (kết quả đi tiếp vào đơn cha)
+func (e *instanceRepo) notifyFinished(tx *gorm.DB, instance *model.WorkflowInstance) error {
+	event := model.EVENT_INSTANCE_APPROVED
+	if instance.Status == model.STATUS_REJECTED {
+		event = model.EVENT_INSTANCE_REJECTED
+	}
+	if !e.notifications || instance.ParentInstanceID != nil {
+		return e.emitLifecycle(tx, newInstanceEvent(event, instance))
+	}
+
+	var last model.WorkflowLog
+	err := tx.Where("instance_id = ? AND action IN ?", instance.ID, []string{model.ACTION_APPROVE, model.ACTION_REJECT}).
+		Order("id DESC").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return e.notifyCreator(tx, event, instance, last.StepName, last.ActorName, last.Comment, last.ReasonCode)
+}
+
+func notificationData(instance *model.WorkflowInstance) model.NotificationData {
+	return model.NotificationData{
+		DocNum:       instance.DocNum,
+		DocType:      instance.DocType,
+		ServiceCode:  instance.ServiceCode,
+		WorkflowID:   instance.WorkflowID,
+		DepartmentID: instance.DepartmentID,
+	}
+}
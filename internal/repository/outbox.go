@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Lấy tối đa limit dòng hàng đợi (email, webhook) đang pendingStatus đến hạn gửi vào dest và dời
+// next_attempt_at thêm lease. SKIP LOCKED: Nhiều instance chạy song song không lấy trùng
+func claimDue(tx *gorm.DB, table interface{}, dest interface{}, pendingStatus string, now time.Time, limit int, lease time.Duration) error {
+	var ids []uint64
+	if err := tx.Model(table).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", pendingStatus, now).
+		Order("next_attempt_at ASC, id ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Model(table).Where("id IN ?", ids).
+		Update("next_attempt_at", now.Add(lease)).Error; err != nil {
+		return err
+	}
+	return tx.Where("id IN ?", ids).Order("id ASC").Find(dest).Error
+}
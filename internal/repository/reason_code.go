@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	reasonCodeRepo struct {
+		db *gorm.DB
+	}
+	// Số lần Từ chối/Trả về theo lý do (báo cáo)
+	ReasonCount struct {
+		Action     string
+		ReasonCode string
+		Total      int64
+	}
+	ReasonCodeRepo interface {
+		Create(ctx context.Context, req *model.ReasonCode) error
+		GetByID(ctx context.Context, id uint64) (*model.ReasonCode, error)
+		Update(ctx context.Context, id uint64, req map[string]interface{}) error
+		Delete(ctx context.Context, id uint64) error
+		// serviceCode != "": Lý do của quy trình + lý do dùng chung. "" = tất cả
+		GetList(ctx context.Context, serviceCode string) ([]model.ReasonCode, error)
+		CountByReason(ctx context.Context, serviceCode string, from, to time.Time) ([]ReasonCount, error)
+	}
+)
+
+func NewReasonCodeRepo(db *gorm.DB) ReasonCodeRepo {
+	return &reasonCodeRepo{db: db}
+}
+
+func (r *reasonCodeRepo) Create(ctx context.Context, req *model.ReasonCode) error {
+	return r.db.WithContext(ctx).Create(req).Error
+}
+func (r *reasonCodeRepo) GetByID(ctx context.Context, id uint64) (*model.ReasonCode, error) {
+	var reason model.ReasonCode
+	if err := r.db.WithContext(ctx).First(&reason, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &reason, nil
+}
+func (r *reasonCodeRepo) Update(ctx context.Context, id uint64, req map[string]interface{}) error {
+	return r.db.WithContext(ctx).Model(&model.ReasonCode{}).Where("id = ?", id).Updates(req).Error
+}
+func (r *reasonCodeRepo) Delete(ctx context.Context, id uint64) error {
+	return r.db.WithContext(ctx).Delete(&model.ReasonCode{}, "id = ?", id).Error
+}
+func (r *reasonCodeRepo) GetList(ctx context.Context, serviceCode string) ([]model.ReasonCode, error) {
+	query := r.db.WithContext(ctx)
+	if serviceCode != "" {
+		query = query.Where("service_code IN ?", []string{serviceCode, ""})
+	}
+	var reasons []model.ReasonCode
+	err := query.Order("service_code ASC, sort_order ASC, code ASC").Find(&reasons).Error
+	return reasons, err
+}
+
+func (r *reasonCodeRepo) CountByReason(ctx context.Context, serviceCode string, from, to time.Time) ([]ReasonCount, error) {
+	query := r.db.WithContext(ctx).
+		Model(&model.WorkflowLog{}).
+		Select("workflow_logs.action, workflow_logs.reason_code, COUNT(*) AS total").
+		Joins("JOIN workflow_instances ON workflow_instances.id = workflow_logs.instance_id").
+		Where("workflow_logs.action IN ? AND workflow_logs.created_at >= ? AND workflow_logs.created_at < ?", model.REASON_ACTIONS, from, to)
+	if serviceCode != "" {
+		query = query.Where("workflow_instances.service_code = ?", serviceCode)
+	}
+	var rows []ReasonCount
+	err := query.Group("workflow_logs.action, workflow_logs.reason_code").
+		Order("total DESC").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// Lý do đang dùng được cho action trên quy trình serviceCode (gồm lý do dùng chung)
+func activeReasonCodes(tx *gorm.DB, serviceCode, action string) ([]model.ReasonCode, error) {
+	var reasons []model.ReasonCode
+	if err := tx.Where("service_code IN ? AND is_active = ?", []string{serviceCode, ""}, true).
+		Order("sort_order ASC, code ASC").
+		Find(&reasons).Error; err != nil {
+		return nil, err
+	}
+	res := make([]model.ReasonCode, 0, len(reasons))
+	for i := range reasons {
+		if reasons[i].AppliesTo(action) {
+			res = append(res, reasons[i])
+		}
+	}
+	return res, nil
+}
@@ -46,3 +46,25 @@ func (s *SignatureHelper) GenerateSignature(
 
 	return signatureHash, dataHash, timestamp
 }
+
+// Kiểm tra chữ ký của 1 log (log bị sửa sau khi ký -> false)
+func (s *SignatureHelper) VerifySignature(
+	signerID string,
+	docNum string,
+	action string,
+	stepOrder int,
+	timestamp int64,
+	dataHash string,
+	signatureHash string,
+) bool {
+	rawString := fmt.Sprintf("%s|%s|%s|%d|%d|%s",
+		signerID, docNum, action, stepOrder, timestamp, dataHash)
+
+	h := hmac.New(sha256.New, []byte(s.SecretKey.Secret))
+	h.Write([]byte(rawString))
+	expected, err := hex.DecodeString(signatureHash)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, h.Sum(nil))
+}
@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// Tính hạn xử lý (DueDate) của task từ WorkflowStep.TimeHours
+	// và thời gian xử lý thực tế (giờ làm việc) cho báo cáo
+	DueDateCalculator interface {
+		AddWorkingHours(ctx context.Context, factoryID uint64, start time.Time, hours int) (time.Time, error)
+		WorkingDuration(ctx context.Context, factoryID uint64, from, to time.Time) (time.Duration, error)
+	}
+	wallClockCalculator struct{}
+	calendarCalculator  struct {
+		calendarRepo CalendarRepo
+	}
+)
+
+// Tính theo giờ thực (24/7), dùng khi chưa cấu hình lịch làm việc
+func NewWallClockCalculator() DueDateCalculator {
+	return &wallClockCalculator{}
+}
+
+func (w *wallClockCalculator) AddWorkingHours(ctx context.Context, factoryID uint64, start time.Time, hours int) (time.Time, error) {
+	return start.Add(time.Duration(hours) * time.Hour), nil
+}
+
+func (w *wallClockCalculator) WorkingDuration(ctx context.Context, factoryID uint64, from, to time.Time) (time.Duration, error) {
+	if to.Before(from) {
+		return 0, nil
+	}
+	return to.Sub(from), nil
+}
+
+// Tính theo lịch làm việc của nhà máy (ngày làm, ca, ngày lễ).
+// Nhà máy chưa có lịch -> Fallback giờ thực
+func NewCalendarCalculator(calendarRepo CalendarRepo) DueDateCalculator {
+	return &calendarCalculator{calendarRepo: calendarRepo}
+}
+
+func (c *calendarCalculator) AddWorkingHours(ctx context.Context, factoryID uint64, start time.Time, hours int) (time.Time, error) {
+	cal, err := c.loadCalendar(ctx, factoryID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if cal == nil {
+		return NewWallClockCalculator().AddWorkingHours(ctx, factoryID, start, hours)
+	}
+	return cal.add(start, time.Duration(hours)*time.Hour)
+}
+
+func (c *calendarCalculator) WorkingDuration(ctx context.Context, factoryID uint64, from, to time.Time) (time.Duration, error) {
+	cal, err := c.loadCalendar(ctx, factoryID)
+	if err != nil {
+		return 0, err
+	}
+	if cal == nil {
+		return NewWallClockCalculator().WorkingDuration(ctx, factoryID, from, to)
+	}
+	return cal.between(from, to), nil
+}
+
+func (c *calendarCalculator) loadCalendar(ctx context.Context, factoryID uint64) (*businessCalendar, error) {
+	cal, err := c.calendarRepo.GetByFactoryID(ctx, factoryID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBusinessCalendar(cal)
+}
+
+// =============================================================================
+// BUSINESS CALENDAR (TÍNH TOÁN THUẦN, KHÔNG TRUY VẤN DB)
+// =============================================================================
+
+// Giới hạn số ngày duyệt qua (tránh lặp vô hạn khi lịch không có ngày làm việc)
+const maxCalendarScanDays = 366 * 3
+
+type shiftRange struct {
+	start, end int // Phút tính từ 00:00
+}
+
+type businessCalendar struct {
+	loc        *time.Location
+	workDays   map[time.Weekday]bool
+	shifts     []shiftRange
+	exceptions map[string]bool // YYYY-MM-DD -> có phải ngày làm việc
+}
+
+func newBusinessCalendar(cal *model.WorkingCalendar) (*businessCalendar, error) {
+	loc, err := time.LoadLocation(cal.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s: %w", cal.Timezone, err)
+	}
+	shifts, err := parseShifts(cal.Shifts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &businessCalendar{
+		loc:        loc,
+		workDays:   make(map[time.Weekday]bool, len(cal.WorkDays)),
+		shifts:     shifts,
+		exceptions: make(map[string]bool, len(cal.Holidays)),
+	}
+	for _, d := range cal.WorkDays {
+		b.workDays[time.Weekday(d)] = true
+	}
+	for _, h := range cal.Holidays {
+		b.exceptions[h.Date] = h.IsWorkingDay
+	}
+	return b, nil
+}
+
+// Kiểm tra ca làm việc hợp lệ (Service dùng khi tạo/sửa lịch)
+func ValidateShifts(shifts []model.WorkShift) error {
+	_, err := parseShifts(shifts)
+	return err
+}
+
+// Kiểm tra & sắp xếp ca làm việc ("HH:MM")
+func parseShifts(shifts []model.WorkShift) ([]shiftRange, error) {
+	res := make([]shiftRange, 0, len(shifts))
+	for _, s := range shifts {
+		start, err := parseClock(s.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(s.End)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, fmt.Errorf("shift %s-%s: end must be after start", s.Start, s.End)
+		}
+		res = append(res, shiftRange{start: start, end: end})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].start < res[j].start })
+	for i := 1; i < len(res); i++ {
+		if res[i].start < res[i-1].end {
+			return nil, errors.New("shifts must not overlap")
+		}
+	}
+	return res, nil
+}
+
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (b *businessCalendar) isWorkingDay(day time.Time) bool {
+	if working, ok := b.exceptions[day.Format("2006-01-02")]; ok {
+		return working
+	}
+	return b.workDays[day.Weekday()]
+}
+
+// Các khoảng làm việc trong ngày chứa "day" (theo Timezone của lịch)
+func (b *businessCalendar) dayRanges(day time.Time) [][2]time.Time {
+	if !b.isWorkingDay(day) {
+		return nil
+	}
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, b.loc)
+	ranges := make([][2]time.Time, 0, len(b.shifts))
+	for _, s := range b.shifts {
+		ranges = append(ranges, [2]time.Time{
+			midnight.Add(time.Duration(s.start) * time.Minute),
+			midnight.Add(time.Duration(s.end) * time.Minute),
+		})
+	}
+	return ranges
+}
+
+// Cộng d giờ làm việc vào start
+func (b *businessCalendar) add(start time.Time, d time.Duration) (time.Time, error) {
+	if d <= 0 {
+		return start, nil
+	}
+	cur := start.In(b.loc)
+	remaining := d
+	for i := 0; i < maxCalendarScanDays; i++ {
+		for _, r := range b.dayRanges(cur) {
+			if !cur.Before(r[1]) {
+				continue
+			}
+			from := cur
+			if from.Before(r[0]) {
+				from = r[0]
+			}
+			avail := r[1].Sub(from)
+			if remaining <= avail {
+				return from.Add(remaining), nil
+			}
+			remaining -= avail
+			cur = r[1]
+		}
+		// Sang 00:00 ngày hôm sau
+		cur = time.Date(cur.Year(), cur.Month(), cur.Day()+1, 0, 0, 0, 0, b.loc)
+	}
+	return time.Time{}, errors.New("calendar has no working time in range")
+}
+
+// Tổng giờ làm việc nằm trong [from, to]
+func (b *businessCalendar) between(from, to time.Time) time.Duration {
+	if !to.After(from) {
+		return 0
+	}
+	cur := from.In(b.loc)
+	end := to.In(b.loc)
+	var total time.Duration
+	for i := 0; i < maxCalendarScanDays*10 && cur.Before(end); i++ {
+		for _, r := range b.dayRanges(cur) {
+			s, e := r[0], r[1]
+			if s.Before(cur) {
+				s = cur
+			}
+			if e.After(end) {
+				e = end
+			}
+			if e.After(s) {
+				total += e.Sub(s)
+			}
+		}
+		cur = time.Date(cur.Year(), cur.Month(), cur.Day()+1, 0, 0, 0, 0, b.loc)
+	}
+	return total
+}
@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"CQS-KYC/internal/model"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	webhookRepo struct {
+		db *gorm.DB
+	}
+	// Lọc lịch sử gửi webhook (SubscriptionID = 0: Tất cả)
+	WebhookDeliveryFilter struct {
+		SubscriptionID uint64
+		InstanceID     uint64
+		Status         string
+		BeforeID       uint64 // Phân trang: Lấy các Delivery có ID < BeforeID
+		Limit          int
+	}
+	WebhookRepo interface {
+		CreateSubscription(ctx context.Context, sub *model.WebhookSubscription) error
+		GetSubscription(ctx context.Context, id uint64) (*model.WebhookSubscription, error)
+		UpdateSubscription(ctx context.Context, id uint64, req map[string]interface{}) error
+		DeleteSubscription(ctx context.Context, id uint64) error
+		ListSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error)
+
+		ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]model.WebhookDelivery, error)
+		GetDelivery(ctx context.Context, id uint64) (*model.WebhookDelivery, error)
+		// Gửi lại thủ công: Tạo Delivery mới cùng EventID/Payload, chờ gửi ngay
+		Redeliver(ctx context.Context, id uint64) (*model.WebhookDelivery, error)
+
+		// Lấy các Delivery đến hạn gửi (kèm Subscription) và dời NextAttemptAt thêm lease
+		ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+		// Lưu kết quả 1 lần gửi (Status, Attempts, NextAttemptAt, Response*, LastError, DurationMs, DeliveredAt)
+		SaveAttempt(ctx context.Context, d *model.WebhookDelivery) error
+	}
+)
+
+// Giữ tối đa bấy nhiêu byte Response của bên nhận (để xem lỗi)
+const webhookResponseLimit = 2048
+
+func NewWebhookRepo(db *gorm.DB) WebhookRepo {
+	return &webhookRepo{db: db}
+}
+
+func (r *webhookRepo) CreateSubscription(ctx context.Context, sub *model.WebhookSubscription) error {
+	return r.db.WithContext(ctx).Create(sub).Error
+}
+
+func (r *webhookRepo) GetSubscription(ctx context.Context, id uint64) (*model.WebhookSubscription, error) {
+	var sub model.WebhookSubscription
+	if err := r.db.WithContext(ctx).First(&sub, id).Error; err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (r *webhookRepo) UpdateSubscription(ctx context.Context, id uint64, req map[string]interface{}) error {
+	return r.db.WithContext(ctx).Model(&model.WebhookSubscription{ID: id}).Updates(req).Error
+}
+
+// Xóa Subscription kèm lịch sử gửi
+func (r *webhookRepo) DeleteSubscription(ctx context.Context, id uint64) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id = ?", id).Delete(&model.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.WebhookSubscription{}, id).Error
+	})
+}
+
+func (r *webhookRepo) ListSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	var subs []model.WebhookSubscription
+	err := r.db.WithContext(ctx).Order("id ASC").Find(&subs).Error
+	return subs, err
+}
+
+func (r *webhookRepo) ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]model.WebhookDelivery, error) {
+	query := r.db.WithContext(ctx).Model(&model.WebhookDelivery{})
+	if filter.SubscriptionID != 0 {
+		query = query.Where("subscription_id = ?", filter.SubscriptionID)
+	}
+	if filter.InstanceID != 0 {
+		query = query.Where("instance_id = ?", filter.InstanceID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	var deliveries []model.WebhookDelivery
+	err := query.Order("id DESC").Limit(filter.Limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *webhookRepo) GetDelivery(ctx context.Context, id uint64) (*model.WebhookDelivery, error) {
+	var d model.WebhookDelivery
+	if err := r.db.WithContext(ctx).First(&d, id).Error; err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *webhookRepo) Redeliver(ctx context.Context, id uint64) (*model.WebhookDelivery, error) {
+	orig, err := r.GetDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	d := model.WebhookDelivery{
+		SubscriptionID: orig.SubscriptionID,
+		EventID:        orig.EventID,
+		Event:          orig.Event,
+		InstanceID:     orig.InstanceID,
+		Payload:        orig.Payload,
+		Status:         model.WEBHOOK_PENDING,
+		NextAttemptAt:  time.Now(),
+		RedeliveryOf:   &orig.ID,
+	}
+	if err := r.db.WithContext(ctx).Create(&d).Error; err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *webhookRepo) ClaimDue(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	var items []model.WebhookDelivery
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := claimDue(tx, &model.WebhookDelivery{}, &items, model.WEBHOOK_PENDING, now, limit, lease); err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		subIDs := make([]uint64, 0, len(items))
+		for i := range items {
+			subIDs = append(subIDs, items[i].SubscriptionID)
+		}
+
+		// Lấy Subscription lúc gửi (URL/Secret mới nhất, đã tắt thì không gửi)
+		var subs []model.WebhookSubscription
+		if err := tx.Where("id IN ?", subIDs).Find(&subs).Error; err != nil {
+			return err
+		}
+		byID := make(map[uint64]*model.WebhookSubscription, len(subs))
+		for i := range subs {
+			byID[subs[i].ID] = &subs[i]
+		}
+		for i := range items {
+			items[i].Subscription = byID[items[i].SubscriptionID]
+		}
+		return nil
+	})
+	return items, err
+}
+
+func (r *webhookRepo) SaveAttempt(ctx context.Context, d *model.WebhookDelivery) error {
+	if len(d.ResponseBody) > webhookResponseLimit {
+		d.ResponseBody = d.ResponseBody[:webhookResponseLimit]
+	}
+	return r.db.WithContext(ctx).Model(&model.WebhookDelivery{ID: d.ID}).Updates(map[string]interface{}{
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"next_attempt_at": d.NextAttemptAt,
+		"response_status": d.ResponseStatus,
+		"response_body":   d.ResponseBody,
+		"last_error":      d.LastError,
+		"duration_ms":     d.DurationMs,
+		"delivered_at":    d.DeliveredAt,
+	}).Error
+}
+
+// =============================================================================
+// GHI WEBHOOK TỪ ENGINE (cùng Transaction với thao tác -> Rollback thì không gửi)
+// =============================================================================
+
+// Payload gửi cho bên nhận
+type webhookPayload struct {
+	ID         string              `json:"id"` // EventID
+	Event      string              `json:"event"`
+	OccurredAt time.Time           `json:"occurred_at"`
+	Data       model.WorkflowEvent `json:"data"`
+}
+
+// Sự kiện vòng đời đơn: Phát realtime + Ghi hàng đợi webhook cho các Subscription phù hợp
+func (e *instanceRepo) emitLifecycle(tx *gorm.DB, event model.WorkflowEvent) error {
+	e.emit(tx, event)
+	if !e.webhooks {
+		return nil
+	}
+	isWebhookEvent := false
+	for _, ev := range model.WEBHOOK_EVENTS {
+		if ev == event.Type {
+			isWebhookEvent = true
+			break
+		}
+	}
+	if !isWebhookEvent {
+		return nil
+	}
+
+	var subs []model.WebhookSubscription
+	if err := tx.Where("is_active = ?", true).Find(&subs).Error; err != nil {
+		return err
+	}
+	var deliveries []model.WebhookDelivery
+	var eventID, payload string
+	for i := range subs {
+		if !subs[i].Matches(event.Type, event.ServiceCode) {
+			continue
+		}
+		if eventID == "" {
+			id, err := newWebhookEventID()
+			if err != nil {
+				return err
+			}
+			body, err := json.Marshal(webhookPayload{ID: id, Event: event.Type, OccurredAt: event.OccurredAt, Data: event})
+			if err != nil {
+				return err
+			}
+			eventID, payload = id, string(body)
+		}
+		deliveries = append(deliveries, model.WebhookDelivery{
+			SubscriptionID: subs[i].ID,
+			EventID:        eventID,
+			Event:          event.Type,
+			InstanceID:     event.InstanceID,
+			Payload:        payload,
+			Status:         model.WEBHOOK_PENDING,
+			NextAttemptAt:  time.Now(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Create(&deliveries).Error
+}
+
+func newWebhookEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
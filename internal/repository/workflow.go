@@ -4,6 +4,7 @@ import (
 	"CQS-KYC/internal/model"
 	"context"
 	"fmt"
+	"regexp"
 
 	"gorm.io/gorm"
 )
@@ -20,6 +21,10 @@ type (
 		Delete(ctx context.Context, id uint64) error
 		GetAll(ctx context.Context) ([]model.WorkflowDefinition, error)
 		GetByCode(ctx context.Context, code string) (*model.WorkflowDefinition, error)
+
+		// Version: Liệt kê mọi version của 1 ServiceCode (cũ -> mới) và số đơn đang chạy mỗi version
+		ListVersions(ctx context.Context, serviceCode string) ([]model.WorkflowDefinition, error)
+		CountInProgress(ctx context.Context, workflowIDs []uint64) (map[uint64]int64, error)
 	}
 )
 
@@ -34,7 +39,7 @@ func (w *workflowRepo) Create(ctx context.Context, wf *model.WorkflowDefinition)
 }
 func (w *workflowRepo) FindByID(ctx context.Context, id uint64) (*model.WorkflowDefinition, error) {
 	var wf model.WorkflowDefinition
-	if err := w.db.WithContext(ctx).Preload("Steps.Assisments").First(&wf, "id = ?", id).Error; err != nil {
+	if err := w.db.WithContext(ctx).Preload("Steps.Assignments").First(&wf, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 	return &wf, nil
@@ -56,13 +61,20 @@ func (w *workflowRepo) Update(ctx context.Context, id uint64, req *model.Workflo
 		if err := tx.Where("id = ?", id).First(&oldWf).Error; err != nil {
 			return fmt.Errorf("workflow not found: %w", err)
 		}
+		if !oldWf.IsActive {
+			return fmt.Errorf("workflow %d is an archived version, update the active version instead", id)
+		}
+
+		// Lấy trước khi archive: Updates bằng map ghi đè luôn các field của oldWf
+		serviceCode, baseCode := oldWf.ServiceCode, baseServiceCode(&oldWf)
 
 		// 2. Archive quy trình cũ (Rename ServiceCode để nhả Unique Index)
 		// Ví dụ: PURI05 -> PURI05_v1_ARCHIVED_17000000
 		archivedCode := fmt.Sprintf("%s_v%d_ARCHIVED_%d", oldWf.ServiceCode, oldWf.Version, oldWf.ID)
 		if err := tx.Model(&oldWf).Updates(map[string]interface{}{
-			"is_active":    false,
-			"service_code": archivedCode,
+			"is_active":         false,
+			"service_code":      archivedCode,
+			"base_service_code": baseCode,
 		}).Error; err != nil {
 			return fmt.Errorf("failed to archive old workflow: %w", err)
 		}
@@ -72,8 +84,11 @@ func (w *workflowRepo) Update(ctx context.Context, id uint64, req *model.Workflo
 		newWf := *req
 		newWf.ID = 0 // Đảm bảo tạo mới
 		newWf.Version = oldWf.Version + 1
-		newWf.ServiceCode = oldWf.ServiceCode // Dùng lại code gốc PURI05
+		newWf.ServiceCode = serviceCode // Dùng lại code gốc PURI05
 		newWf.IsActive = true
+		newWf.BaseServiceCode = baseCode
+		newWf.PreviousVersionID = &oldWf.ID
+		// Đơn đang chạy vẫn giữ WorkflowID của version cũ (không bị ảnh hưởng)
 
 		// 4. Create Deep Insert
 		if err := tx.Create(&newWf).Error; err != nil {
@@ -91,7 +106,7 @@ func (w *workflowRepo) Delete(ctx context.Context, id uint64) error {
 }
 func (w *workflowRepo) GetAll(ctx context.Context) ([]model.WorkflowDefinition, error) {
 	var wfs []model.WorkflowDefinition
-	if err := w.db.WithContext(ctx).Preload("Steps.Assisments").Find(&wfs).Error; err != nil {
+	if err := w.db.WithContext(ctx).Preload("Steps.Assignments").Find(&wfs).Error; err != nil {
 		return nil, err
 	}
 	return wfs, nil
@@ -110,3 +125,50 @@ func (w *workflowRepo) GetByCode(ctx context.Context, code string) (*model.Workf
 	}
 	return &wf, nil
 }
+
+func (w *workflowRepo) ListVersions(ctx context.Context, serviceCode string) ([]model.WorkflowDefinition, error) {
+	var wfs []model.WorkflowDefinition
+	err := w.db.WithContext(ctx).
+		Preload("Steps.Assignments").
+		// Dữ liệu cũ chưa có BaseServiceCode -> Nhận diện theo tên archive "<code>_v<n>_ARCHIVED_<id>"
+		Where("base_service_code = ? OR (COALESCE(base_service_code, '') = '' AND (service_code = ? OR service_code LIKE ?))",
+			serviceCode, serviceCode, serviceCode+"_v%_ARCHIVED_%").
+		Order("version ASC, id ASC").
+		Find(&wfs).Error
+	return wfs, err
+}
+
+func (w *workflowRepo) CountInProgress(ctx context.Context, workflowIDs []uint64) (map[uint64]int64, error) {
+	var rows []struct {
+		WorkflowID uint64
+		Total      int64
+	}
+	err := w.db.WithContext(ctx).
+		Model(&model.WorkflowInstance{}).
+		Select("workflow_id, COUNT(*) AS total").
+		Where("workflow_id IN ? AND status = ?", workflowIDs, model.STATUS_IN_PROGRESS).
+		Group("workflow_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[uint64]int64, len(rows))
+	for _, r := range rows {
+		res[r.WorkflowID] = r.Total
+	}
+	return res, nil
+}
+
+// Tên archive: "<code>_v<n>_ARCHIVED_<id>"
+var archivedCodePattern = regexp.MustCompile(`^(.+)_v\d+_ARCHIVED_\d+$`)
+
+// ServiceCode gốc của chuỗi version (dữ liệu cũ chưa có BaseServiceCode -> Lấy từ tên archive)
+func baseServiceCode(wf *model.WorkflowDefinition) string {
+	if wf.BaseServiceCode != "" {
+		return wf.BaseServiceCode
+	}
+	if m := archivedCodePattern.FindStringSubmatch(wf.ServiceCode); m != nil {
+		return m[1]
+	}
+	return wf.ServiceCode
+}
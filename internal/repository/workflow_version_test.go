@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"CQS-KYC/config"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/testutil"
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// DB SQLite trong bộ nhớ với các bảng của Engine, 4 User (ID 1-4)
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testutil.NewDB(t,
+		&model.User{},
+		&model.UserGroup{},
+		&model.UserGroupMember{},
+		&model.WorkflowDelegation{},
+		&model.WorkflowDefinition{},
+		&model.WorkflowStep{},
+		&model.WorkflowInstance{},
+		&model.WorkflowTask{},
+		&model.WorkflowLog{},
+		&model.IdempotencyKey{},
+		&model.ReasonCode{},
+	)
+	// SQLite: Tên index phải duy nhất toàn DB, idx_workflow_step đã dùng cho workflow_steps
+	if err := db.Exec(`CREATE TABLE workflow_step_assignments (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		step_id integer NOT NULL,
+		department_ids json,
+		factory_id integer,
+		assigned_type text NOT NULL,
+		assigned_identity text NOT NULL,
+		priority integer DEFAULT 0,
+		is_active numeric DEFAULT true,
+		created_at integer,
+		updated_at integer
+	)`).Error; err != nil {
+		t.Fatalf("create assignments: %v", err)
+	}
+	for i := 1; i <= 4; i++ {
+		user := model.User{ID: uint64(i), UserCode: fmt.Sprintf("U%d", i), FullName: fmt.Sprintf("User %d", i), IsActive: true}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	return db
+}
+
+func newTestEngine(db *gorm.DB) InstanceRepo {
+	sig := NewSignatureHelper(&config.SignatureKeyConfig{Secret: "test-secret"})
+	return NewWorkflowEngine(db, NewGroupRepo(db), NewDelegationRepo(db), nil, *sig, nil, false, false, nil)
+}
+
+// Mỗi bước giao cho 1 User: {StepCode, UserID}
+func testWorkflow(serviceCode string, steps ...[2]string) *model.WorkflowDefinition {
+	wf := &model.WorkflowDefinition{ServiceCode: serviceCode, Operation: serviceCode, WorkflowName: serviceCode, Version: 1, IsActive: true}
+	for i, s := range steps {
+		wf.Steps = append(wf.Steps, model.WorkflowStep{
+			StepCode:     s[0],
+			StepName:     "Step " + s[0],
+			StepOrder:    i + 1,
+			StepType:     model.STEP_TYPE_APPROVAL,
+			RequiredRole: "APPROVER",
+			Assignments: []model.WorkflowStepAssignment{
+				{AssignedType: model.ASSIGN_USER, AssignedIdentity: s[1], IsActive: true},
+			},
+		})
+	}
+	return wf
+}
+
+func initiate(t *testing.T, db *gorm.DB, engine InstanceRepo, workflowID uint64, docNum string) *model.WorkflowInstance {
+	t.Helper()
+	var instance *model.WorkflowInstance
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		instance, err = engine.InitiateWorkflow(tx, workflowID, "PURI05", docNum, "PR", "1", 1, 1, []byte(`{}`), model.ClientInfo{})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("initiate: %v", err)
+	}
+	return instance
+}
+
+func openTasks(t *testing.T, db *gorm.DB, instanceID uint64) []model.WorkflowTask {
+	t.Helper()
+	var tasks []model.WorkflowTask
+	if err := db.Where("instance_id = ? AND status IN ?", instanceID, model.TASK_OPEN_STATUSES).Order("id").Find(&tasks).Error; err != nil {
+		t.Fatalf("load tasks: %v", err)
+	}
+	return tasks
+}
+
+func reload(t *testing.T, db *gorm.DB, id uint64) model.WorkflowInstance {
+	t.Helper()
+	var instance model.WorkflowInstance
+	if err := db.First(&instance, id).Error; err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	return instance
+}
+
+func TestUpdateKeepsInFlightInstancesOnTheirVersion(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	engine := newTestEngine(db)
+	wfRepo := NewWorkflowRepo(db)
+
+	v1 := testWorkflow("PURI05", [2]string{"S1", "2"}, [2]string{"S2", "3"})
+	if err := wfRepo.Create(ctx, v1); err != nil {
+		t.Fatalf("create workflow: %v", err)
+	}
+	instance := initiate(t, db, engine, v1.ID, "PR-001")
+
+	// Sửa quy trình: Bước 2 giao cho User 4 -> Tạo version 2
+	if err := wfRepo.Update(ctx, v1.ID, testWorkflow("PURI05", [2]string{"S1", "2"}, [2]string{"S2", "4"})); err != nil {
+		t.Fatalf("update workflow: %v", err)
+	}
+	v2, err := wfRepo.FindByServiceCode(ctx, "PURI05")
+	if err != nil {
+		t.Fatalf("find active version: %v", err)
+	}
+	if v2.ID == v1.ID || v2.Version != 2 || v2.PreviousVersionID == nil || *v2.PreviousVersionID != v1.ID {
+		t.Fatalf("unexpected active version: id=%d version=%d previous=%v", v2.ID, v2.Version, v2.PreviousVersionID)
+	}
+
+	got := reload(t, db, instance.ID)
+	if got.WorkflowID != v1.ID || got.WorkflowVersion != 1 {
+		t.Fatalf("in-flight instance moved to workflow %d v%d, want %d v1", got.WorkflowID, got.WorkflowVersion, v1.ID)
+	}
+
+	// Duyệt tiếp vẫn chạy theo bước của version 1 (giao User 3, không phải User 4)
+	if _, err := engine.ProcessAction(ctx, instance.ID, "2", "User 2", model.ACTION_APPROVE, "ok", "", "", model.ClientInfo{}); err != nil {
+		t.Fatalf("approve step 1: %v", err)
+	}
+	got = reload(t, db, instance.ID)
+	if got.WorkflowID != v1.ID || got.WorkflowVersion != 1 || got.CurrentStep != 2 {
+		t.Fatalf("after approve: workflow %d v%d step %d, want %d v1 step 2", got.WorkflowID, got.WorkflowVersion, got.CurrentStep, v1.ID)
+	}
+	tasks := openTasks(t, db, instance.ID)
+	if len(tasks) != 1 || tasks[0].AssignedTo != "3" || tasks[0].StepID != v1.Steps[1].ID {
+		t.Fatalf("step 2 task = %+v, want 1 task for user 3 on version 1 step", tasks)
+	}
+
+	// Đơn mới dùng version 2
+	fresh := initiate(t, db, engine, v2.ID, "PR-002")
+	if fresh.WorkflowID != v2.ID || fresh.WorkflowVersion != 2 {
+		t.Fatalf("new instance on workflow %d v%d, want %d v2", fresh.WorkflowID, fresh.WorkflowVersion, v2.ID)
+	}
+
+	// Version cũ đã archive: Không tạo đơn mới, không sửa được nữa
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := engine.InitiateWorkflow(tx, v1.ID, "PURI05", "PR-003", "PR", "1", 1, 1, []byte(`{}`), model.ClientInfo{})
+		return err
+	}); err == nil {
+		t.Fatal("initiate on archived version: expected error")
+	}
+	if err := wfRepo.Update(ctx, v1.ID, testWorkflow("PURI05", [2]string{"S1", "2"})); err == nil {
+		t.Fatal("update archived version: expected error")
+	}
+}
+
+func TestMigrateInstanceMapsSteps(t *testing.T) {
+	ctx := context.Background()
+
+	// v1: S1 -> S2. v2: S1 -> NEW -> S2 (S2 chuyển sang bước 3, giao User 4)
+	setup := func(t *testing.T) (*gorm.DB, InstanceRepo, *model.WorkflowDefinition, *model.WorkflowDefinition, *model.WorkflowInstance) {
+		db := newTestDB(t)
+		engine := newTestEngine(db)
+		wfRepo := NewWorkflowRepo(db)
+		v1 := testWorkflow("PURI05", [2]string{"S1", "2"}, [2]string{"S2", "3"})
+		if err := wfRepo.Create(ctx, v1); err != nil {
+			t.Fatalf("create workflow: %v", err)
+		}
+		instance := initiate(t, db, engine, v1.ID, "PR-001")
+		if _, err := engine.ProcessAction(ctx, instance.ID, "2", "User 2", model.ACTION_APPROVE, "ok", "", "", model.ClientInfo{}); err != nil {
+			t.Fatalf("approve step 1: %v", err)
+		}
+		if err := wfRepo.Update(ctx, v1.ID, testWorkflow("PURI05", [2]string{"S1", "2"}, [2]string{"NEW", "1"}, [2]string{"S2", "4"})); err != nil {
+			t.Fatalf("update workflow: %v", err)
+		}
+		v2, err := wfRepo.FindByServiceCode(ctx, "PURI05")
+		if err != nil {
+			t.Fatalf("find active version: %v", err)
+		}
+		return db, engine, v1, v2, instance
+	}
+
+	stepOrderOf := func(wf *model.WorkflowDefinition, stepID uint64) int {
+		for _, s := range wf.Steps {
+			if s.ID == stepID {
+				return s.StepOrder
+			}
+		}
+		return 0
+	}
+
+	cases := []struct {
+		name     string
+		mapping  map[int]int
+		wantStep int
+		wantUser string
+	}{
+		{"by step code", nil, 3, "4"},
+		{"explicit mapping", map[int]int{2: 2}, 2, "1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, engine, v1, v2, instance := setup(t)
+			oldTask := openTasks(t, db, instance.ID)[0]
+
+			if err := engine.MigrateInstance(ctx, instance.ID, v1.ID, v2.ID, tc.mapping, "9", "Admin", "migrate", model.ClientInfo{}); err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+
+			got := reload(t, db, instance.ID)
+			if got.WorkflowID != v2.ID || got.WorkflowVersion != 2 || got.CurrentStep != tc.wantStep || got.TotalSteps != 3 {
+				t.Fatalf("migrated instance: workflow %d v%d step %d/%d, want %d v2 step %d/3",
+					got.WorkflowID, got.WorkflowVersion, got.CurrentStep, got.TotalSteps, v2.ID, tc.wantStep)
+			}
+			tasks := openTasks(t, db, instance.ID)
+			if len(tasks) != 1 || tasks[0].AssignedTo != tc.wantUser || stepOrderOf(v2, tasks[0].StepID) != tc.wantStep {
+				t.Fatalf("open tasks = %+v, want 1 task for user %s on version 2 step %d", tasks, tc.wantUser, tc.wantStep)
+			}
+			var old model.WorkflowTask
+			if err := db.First(&old, oldTask.ID).Error; err != nil {
+				t.Fatalf("load old task: %v", err)
+			}
+			if old.Status != model.TASK_STATUS_CANCELLED {
+				t.Fatalf("old task status = %s, want %s", old.Status, model.TASK_STATUS_CANCELLED)
+			}
+			var log model.WorkflowLog
+			if err := db.Where("instance_id = ? AND action = ?", instance.ID, model.ACTION_MIGRATE).First(&log).Error; err != nil {
+				t.Fatalf("migrate log: %v", err)
+			}
+			if want := "v2:" + strconv.Itoa(tc.wantStep); log.TargetID != want || log.SignatureHash == "" {
+				t.Fatalf("migrate log target=%q signed=%t, want %q signed", log.TargetID, log.SignatureHash != "", want)
+			}
+		})
+	}
+
+	t.Run("unmapped step", func(t *testing.T) {
+		_, engine, v1, v2, instance := setup(t)
+		if err := engine.MigrateInstance(ctx, instance.ID, v1.ID, v2.ID, map[int]int{2: 9}, "9", "Admin", "", model.ClientInfo{}); err == nil {
+			t.Fatal("expected error for mapping to a missing step")
+		}
+	})
+
+	t.Run("wrong source version", func(t *testing.T) {
+		_, engine, _, v2, instance := setup(t)
+		if err := engine.MigrateInstance(ctx, instance.ID, v2.ID, v2.ID, nil, "9", "Admin", "", model.ClientInfo{}); err == nil {
+			t.Fatal("expected error when instance is not on the source version")
+		}
+	})
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type (
+	calendarService struct {
+		repo        repository.CalendarRepo
+		factoryRepo repository.FactoryRepo
+		calculator  repository.DueDateCalculator
+	}
+	CalendarService interface {
+		Create(ctx context.Context, req dto.CalendarCreate) error
+		GetByID(ctx context.Context, id uint64) (*dto.CalendarRes, error)
+		Update(ctx context.Context, id uint64, req dto.CalendarUpdate) error
+		Delete(ctx context.Context, id uint64) error
+		GetAll(ctx context.Context) ([]dto.CalendarRes, error)
+
+		AddHolidays(ctx context.Context, id uint64, req []dto.CalendarHolidayReq) error
+		DeleteHoliday(ctx context.Context, id, holidayID uint64) error
+		ImportICal(ctx context.Context, id uint64, data []byte) (*dto.CalendarImportRes, error)
+
+		Calculate(ctx context.Context, req dto.CalendarCalcReq) (*dto.CalendarCalcRes, error)
+	}
+)
+
+func NewCalendarService(repo repository.CalendarRepo, factoryRepo repository.FactoryRepo, calculator repository.DueDateCalculator) CalendarService {
+	return &calendarService{
+		repo:        repo,
+		factoryRepo: factoryRepo,
+		calculator:  calculator,
+	}
+}
+
+func (s *calendarService) Create(ctx context.Context, req dto.CalendarCreate) error {
+	// 1. Check Factory & trùng lịch
+	if _, err := s.factoryRepo.GetByID(ctx, req.FactoryID); err != nil {
+		return fmt.Errorf("factory %d not found", req.FactoryID)
+	}
+	// Lịch đã tắt vẫn giữ FactoryID (unique) -> Báo trùng, bật lại bằng Update is_active
+	existing, err := s.repo.FindAnyByFactoryID(ctx, req.FactoryID)
+	if err == nil {
+		if !existing.IsActive {
+			return fmt.Errorf("factory %d already has a calendar (id %d, inactive), reactivate it instead", req.FactoryID, existing.ID)
+		}
+		return fmt.Errorf("factory %d already has a calendar", req.FactoryID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	// 2. Giá trị mặc định: Thứ 2 - Thứ 6, 08:00-12:00 & 13:00-17:00
+	cal := model.WorkingCalendar{
+		FactoryID: req.FactoryID,
+		Name:      req.Name,
+		Timezone:  req.Timezone,
+		WorkDays:  req.WorkDays,
+		Shifts:    toModelShifts(req.Shifts),
+		IsActive:  true,
+	}
+	if cal.Timezone == "" {
+		cal.Timezone = "Asia/Ho_Chi_Minh"
+	}
+	if len(cal.WorkDays) == 0 {
+		cal.WorkDays = []int{1, 2, 3, 4, 5}
+	}
+	if len(cal.Shifts) == 0 {
+		cal.Shifts = []model.WorkShift{{Start: "08:00", End: "12:00"}, {Start: "13:00", End: "17:00"}}
+	}
+	if err := validateCalendar(cal.Timezone, cal.WorkDays, cal.Shifts); err != nil {
+		return err
+	}
+
+	if err := s.repo.Create(ctx, &cal); err != nil {
+		return fmt.Errorf("failed to create calendar %w", err)
+	}
+	return nil
+}
+
+func (s *calendarService) GetByID(ctx context.Context, id uint64) (*dto.CalendarRes, error) {
+	cal, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	res := toCalendarRes(*cal)
+	return &res, nil
+}
+
+func (s *calendarService) Update(ctx context.Context, id uint64, req dto.CalendarUpdate) error {
+	cal, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	updates := make(map[string]interface{})
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Timezone != nil {
+		cal.Timezone = *req.Timezone
+		updates["timezone"] = *req.Timezone
+	}
+	// Cột JSON: Update bằng map không qua serializer -> tự marshal
+	if req.WorkDays != nil {
+		cal.WorkDays = req.WorkDays
+		b, err := json.Marshal(req.WorkDays)
+		if err != nil {
+			return err
+		}
+		updates["work_days"] = datatypes.JSON(b)
+	}
+	if req.Shifts != nil {
+		cal.Shifts = toModelShifts(req.Shifts)
+		b, err := json.Marshal(cal.Shifts)
+		if err != nil {
+			return err
+		}
+		updates["shifts"] = datatypes.JSON(b)
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+	if len(updates) == 0 {
+		return errors.New("no fields to update")
+	}
+	if err := validateCalendar(cal.Timezone, cal.WorkDays, cal.Shifts); err != nil {
+		return err
+	}
+	if err := s.repo.Update(ctx, id, updates); err != nil {
+		return fmt.Errorf("failed to update calendar %w", err)
+	}
+	return nil
+}
+
+func (s *calendarService) Delete(ctx context.Context, id uint64) error {
+	return s.repo.Delete(ctx, id)
+}
+
+func (s *calendarService) GetAll(ctx context.Context) ([]dto.CalendarRes, error) {
+	cals, err := s.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.CalendarRes, 0, len(cals))
+	for _, c := range cals {
+		res = append(res, toCalendarRes(c))
+	}
+	return res, nil
+}
+
+func (s *calendarService) AddHolidays(ctx context.Context, id uint64, req []dto.CalendarHolidayReq) error {
+	if _, err := s.repo.GetByID(ctx, id); err != nil {
+		return err
+	}
+	holidays := make([]model.CalendarHoliday, 0, len(req))
+	for _, h := range req {
+		if _, err := time.Parse("2006-01-02", h.Date); err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", h.Date)
+		}
+		holidays = append(holidays, model.CalendarHoliday{
+			Date:         h.Date,
+			Name:         h.Name,
+			IsWorkingDay: h.IsWorkingDay,
+			Source:       model.HOLIDAY_SOURCE_MANUAL,
+		})
+	}
+	return s.repo.UpsertHolidays(ctx, id, holidays)
+}
+
+func (s *calendarService) DeleteHoliday(ctx context.Context, id, holidayID uint64) error {
+	return s.repo.DeleteHoliday(ctx, id, holidayID)
+}
+
+// Import ngày lễ từ file iCal (.ics) - mỗi VEVENT là 1 hoặc nhiều ngày nghỉ
+func (s *calendarService) ImportICal(ctx context.Context, id uint64, data []byte) (*dto.CalendarImportRes, error) {
+	if _, err := s.repo.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+	holidays, err := parseICalHolidays(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpsertHolidays(ctx, id, holidays); err != nil {
+		return nil, fmt.Errorf("failed to import holidays %w", err)
+	}
+	return &dto.CalendarImportRes{Imported: len(holidays)}, nil
+}
+
+func (s *calendarService) Calculate(ctx context.Context, req dto.CalendarCalcReq) (*dto.CalendarCalcRes, error) {
+	res := &dto.CalendarCalcRes{}
+	if req.End != nil {
+		d, err := s.calculator.WorkingDuration(ctx, req.FactoryID, req.Start, *req.End)
+		if err != nil {
+			return nil, err
+		}
+		hours := d.Hours()
+		res.WorkingHours = &hours
+		return res, nil
+	}
+
+	due, err := s.calculator.AddWorkingHours(ctx, req.FactoryID, req.Start, req.Hours)
+	if err != nil {
+		return nil, err
+	}
+	res.DueDate = &due
+	return res, nil
+}
+
+// =============================================================================
+// HELPERS
+// =============================================================================
+
+func validateCalendar(timezone string, workDays []int, shifts []model.WorkShift) error {
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("invalid timezone %s", timezone)
+	}
+	// Không có ngày làm việc -> Không tính được hạn xử lý cho cả nhà máy
+	if len(workDays) == 0 {
+		return errors.New("calendar must have at least one work day")
+	}
+	for _, d := range workDays {
+		if d < 0 || d > 6 {
+			return fmt.Errorf("invalid work day %d (0 = Sunday ... 6 = Saturday)", d)
+		}
+	}
+	if len(shifts) == 0 {
+		return errors.New("calendar must have at least one shift")
+	}
+	return repository.ValidateShifts(shifts)
+}
+
+func toModelShifts(shifts []dto.CalendarShift) []model.WorkShift {
+	res := make([]model.WorkShift, 0, len(shifts))
+	for _, s := range shifts {
+		res = append(res, model.WorkShift{Start: s.Start, End: s.End})
+	}
+	return res
+}
+
+func toCalendarRes(c model.WorkingCalendar) dto.CalendarRes {
+	res := dto.CalendarRes{
+		ID:        c.ID,
+		FactoryID: c.FactoryID,
+		Name:      c.Name,
+		Timezone:  c.Timezone,
+		WorkDays:  c.WorkDays,
+		Shifts:    make([]dto.CalendarShift, 0, len(c.Shifts)),
+		IsActive:  c.IsActive,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+	for _, s := range c.Shifts {
+		res.Shifts = append(res.Shifts, dto.CalendarShift{Start: s.Start, End: s.End})
+	}
+	for _, h := range c.Holidays {
+		res.Holidays = append(res.Holidays, dto.CalendarHolidayRes{
+			ID:           h.ID,
+			Date:         h.Date,
+			Name:         h.Name,
+			IsWorkingDay: h.IsWorkingDay,
+			Source:       h.Source,
+		})
+	}
+	return res
+}
+
+// Đọc VEVENT (DTSTART/DTEND/SUMMARY) từ file iCal.
+// Sự kiện cả ngày: DTEND là ngày kết thúc không bao gồm (theo RFC 5545).
+// Lưu ý: RRULE (lặp hằng năm) chưa hỗ trợ - file lễ quốc gia thường liệt kê từng năm
+func parseICalHolidays(data []byte) ([]model.CalendarHoliday, error) {
+	// Unfold: dòng bắt đầu bằng space/tab là phần tiếp của dòng trước
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\n ", "")
+	text = strings.ReplaceAll(text, "\n\t", "")
+
+	var (
+		holidays = make([]model.CalendarHoliday, 0)
+		seen     = make(map[string]bool)
+		inEvent  bool
+		start    string
+		end      string
+		summary  string
+	)
+	scanner := bufio.NewScanner(bytes.NewReader([]byte(text)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent, start, end, summary = true, "", "", ""
+		case line == "END:VEVENT":
+			inEvent = false
+			days, err := icalEventDays(start, end)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range days {
+				if seen[d] {
+					continue
+				}
+				seen[d] = true
+				holidays = append(holidays, model.CalendarHoliday{
+					Date:   d,
+					Name:   summary,
+					Source: model.HOLIDAY_SOURCE_ICAL,
+				})
+			}
+		case inEvent:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			// Bỏ tham số: DTSTART;VALUE=DATE -> DTSTART
+			name, _, _ = strings.Cut(name, ";")
+			switch strings.ToUpper(name) {
+			case "DTSTART":
+				start = value
+			case "DTEND":
+				end = value
+			case "SUMMARY":
+				summary = icalUnescape(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid ical file: %w", err)
+	}
+	if len(holidays) == 0 {
+		return nil, errors.New("no VEVENT found in ical file")
+	}
+	return holidays, nil
+}
+
+// Danh sách ngày (YYYY-MM-DD) của 1 sự kiện, tối đa 31 ngày
+func icalEventDays(start, end string) ([]string, error) {
+	if len(start) < 8 {
+		return nil, fmt.Errorf("invalid DTSTART %q", start)
+	}
+	first, err := time.Parse("20060102", start[:8])
+	if err != nil {
+		return nil, fmt.Errorf("invalid DTSTART %q", start)
+	}
+	last := first
+	if len(end) >= 8 {
+		endDate, err := time.Parse("20060102", end[:8])
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTEND %q", end)
+		}
+		// DTEND dạng ngày hoặc 00:00 là mốc không bao gồm
+		if !strings.Contains(end, "T") || strings.HasPrefix(end[8:], "T000000") {
+			endDate = endDate.AddDate(0, 0, -1)
+		}
+		if endDate.After(first) {
+			last = endDate
+		}
+	}
+
+	days := make([]string, 0)
+	for d := first; !d.After(last) && len(days) < 31; d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format("2006-01-02"))
+	}
+	return days, nil
+}
+
+func icalUnescape(v string) string {
+	r := strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`)
+	return strings.TrimSpace(r.Replace(v))
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gorm.io/datatypes"
+)
+
+type (
+	delegationService struct {
+		repo     repository.DelegationRepo
+		userRepo repository.UserRepo
+	}
+	DelegationService interface {
+		Create(ctx context.Context, userID string, req dto.DelegationCreate) error
+		GetByID(ctx context.Context, userID string, id uint64) (*dto.DelegationRes, error)
+		Update(ctx context.Context, userID string, id uint64, req dto.DelegationUpdate) error
+		Delete(ctx context.Context, userID string, id uint64) error
+		GetGiven(ctx context.Context, userID string) ([]dto.DelegationRes, error)
+		GetReceived(ctx context.Context, userID string) ([]dto.DelegationRes, error)
+	}
+)
+
+func NewDelegationService(repo repository.DelegationRepo, userRepo repository.UserRepo) DelegationService {
+	return &delegationService{
+		repo:     repo,
+		userRepo: userRepo,
+	}
+}
+
+func (d *delegationService) Create(ctx context.Context, userID string, req dto.DelegationCreate) error {
+	// Ủy quyền thay người khác: Chỉ Admin (VD: Người ủy quyền nghỉ đột xuất)
+	delegatorID := userID
+	if req.DelegatorID != "" && req.DelegatorID != userID {
+		if err := requireAdmin(ctx, d.userRepo, userID); err != nil {
+			return err
+		}
+		delegatorID = req.DelegatorID
+	}
+
+	// 1. Validate
+	if req.DelegateID == "" {
+		return errors.New("delegate_id is required")
+	}
+	if req.DelegateID == delegatorID {
+		return errors.New("cannot delegate to yourself")
+	}
+	if !req.EndDate.After(req.StartDate) {
+		return errors.New("end_date must be after start_date")
+	}
+	if err := d.checkUser(ctx, delegatorID); err != nil {
+		return err
+	}
+	if err := d.checkUser(ctx, req.DelegateID); err != nil {
+		return err
+	}
+
+	// 2. Map Data
+	delegation := model.WorkflowDelegation{
+		DelegatorID:  delegatorID,
+		DelegateID:   req.DelegateID,
+		StartDate:    req.StartDate,
+		EndDate:      req.EndDate,
+		ServiceCodes: req.ServiceCodes,
+		Reason:       req.Reason,
+		IsActive:     true,
+	}
+	if err := d.repo.Create(ctx, &delegation); err != nil {
+		return fmt.Errorf("failed to create delegation %w", err)
+	}
+	return nil
+}
+
+func (d *delegationService) GetByID(ctx context.Context, userID string, id uint64) (*dto.DelegationRes, error) {
+	delegation, err := d.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if delegation.DelegatorID != userID && delegation.DelegateID != userID {
+		if err := requireAdmin(ctx, d.userRepo, userID); err != nil {
+			return nil, errors.New("only the delegator, the delegate or an admin can view this delegation")
+		}
+	}
+	res := toDelegationRes(*delegation)
+	return &res, nil
+}
+
+func (d *delegationService) Update(ctx context.Context, userID string, id uint64, req dto.DelegationUpdate) error {
+	existing, err := d.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if existing.DelegatorID != userID {
+		if err := requireAdmin(ctx, d.userRepo, userID); err != nil {
+			return errors.New("only the delegator or an admin can change this delegation")
+		}
+	}
+
+	updates := make(map[string]interface{})
+	start, end := existing.StartDate, existing.EndDate
+	if req.DelegateID != nil {
+		if *req.DelegateID == existing.DelegatorID {
+			return errors.New("cannot delegate to yourself")
+		}
+		if err := d.checkUser(ctx, *req.DelegateID); err != nil {
+			return err
+		}
+		updates["delegate_id"] = *req.DelegateID
+	}
+	if req.StartDate != nil {
+		start = *req.StartDate
+		updates["start_date"] = start
+	}
+	if req.EndDate != nil {
+		end = *req.EndDate
+		updates["end_date"] = end
+	}
+	if !end.After(start) {
+		return errors.New("end_date must be after start_date")
+	}
+	if req.ServiceCodes != nil {
+		// Cột JSON: Update bằng map không qua serializer -> tự marshal
+		codes, err := json.Marshal(req.ServiceCodes)
+		if err != nil {
+			return err
+		}
+		updates["service_codes"] = datatypes.JSON(codes)
+	}
+	if req.Reason != nil {
+		updates["reason"] = *req.Reason
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+	if len(updates) == 0 {
+		return errors.New("no fields to update")
+	}
+	if err := d.repo.Update(ctx, id, updates); err != nil {
+		return fmt.Errorf("failed to update delegation %w", err)
+	}
+	return nil
+}
+
+func (d *delegationService) Delete(ctx context.Context, userID string, id uint64) error {
+	existing, err := d.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	// Người nhận được tự từ chối ủy quyền
+	if existing.DelegatorID != userID && existing.DelegateID != userID {
+		if err := requireAdmin(ctx, d.userRepo, userID); err != nil {
+			return errors.New("only the delegator, the delegate or an admin can revoke this delegation")
+		}
+	}
+	return d.repo.Delete(ctx, id)
+}
+
+// Các ủy quyền mình đã giao cho người khác
+func (d *delegationService) GetGiven(ctx context.Context, userID string) ([]dto.DelegationRes, error) {
+	list, err := d.repo.GetByDelegator(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.DelegationRes, 0, len(list))
+	for _, item := range list {
+		res = append(res, toDelegationRes(item))
+	}
+	return res, nil
+}
+
+// Các ủy quyền mình được nhận (duyệt thay người khác)
+func (d *delegationService) GetReceived(ctx context.Context, userID string) ([]dto.DelegationRes, error) {
+	list, err := d.repo.GetByDelegate(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.DelegationRes, 0, len(list))
+	for _, item := range list {
+		res = append(res, toDelegationRes(item))
+	}
+	return res, nil
+}
+
+// UserID trong Workflow là ID dạng chuỗi -> Check tồn tại
+func (d *delegationService) checkUser(ctx context.Context, userID string) error {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid user id %s", userID)
+	}
+	if _, err := d.userRepo.GetByID(ctx, id); err != nil {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	return nil
+}
+
+func toDelegationRes(d model.WorkflowDelegation) dto.DelegationRes {
+	return dto.DelegationRes{
+		ID:           d.ID,
+		DelegatorID:  d.DelegatorID,
+		DelegateID:   d.DelegateID,
+		StartDate:    d.StartDate,
+		EndDate:      d.EndDate,
+		ServiceCodes: d.ServiceCodes,
+		Reason:       d.Reason,
+		IsActive:     d.IsActive,
+		CreatedAt:    d.CreatedAt,
+		UpdatedAt:    d.UpdatedAt,
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"sync"
+)
+
+// Số sự kiện chờ gửi tối đa của 1 kết nối. Đầy (client chậm) -> Đóng kết nối, client kết nối lại và tải lại Inbox
+const eventSubscriptionBuffer = 64
+
+type (
+	eventHub struct {
+		mu     sync.RWMutex
+		subs   map[*eventSubscription]struct{}
+		closed bool
+	}
+	eventSubscription struct {
+		userID    string
+		assignees map[string]bool // userID + người ủy quyền cho userID
+		groups    map[string]bool
+		ch        chan model.WorkflowEvent
+	}
+	// Phân phối sự kiện của Engine đến các kết nối realtime (trong 1 process)
+	EventHub interface {
+		repository.EventPublisher
+		// Nhận sự kiện task giao cho assignees/groups và sự kiện đơn do userID tạo. Gọi hàm trả về để hủy
+		Subscribe(userID string, assignees, groups []string) (<-chan model.WorkflowEvent, func())
+		// Đóng mọi kết nối (Graceful Shutdown)
+		Close()
+	}
+)
+
+func NewEventHub() EventHub {
+	return &eventHub{subs: map[*eventSubscription]struct{}{}}
+}
+
+func (h *eventHub) Subscribe(userID string, assignees, groups []string) (<-chan model.WorkflowEvent, func()) {
+	sub := &eventSubscription{
+		userID:    userID,
+		assignees: map[string]bool{userID: true},
+		groups:    make(map[string]bool, len(groups)),
+		ch:        make(chan model.WorkflowEvent, eventSubscriptionBuffer),
+	}
+	for _, a := range assignees {
+		sub.assignees[a] = true
+	}
+	for _, g := range groups {
+		sub.groups[g] = true
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		close(sub.ch)
+	} else {
+		h.subs[sub] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	return sub.ch, func() { h.remove(sub) }
+}
+
+func (h *eventHub) Publish(events ...model.WorkflowEvent) {
+	var slow []*eventSubscription
+
+	h.mu.RLock()
+	for sub := range h.subs {
+	send:
+		for i := range events {
+			if !sub.matches(&events[i]) {
+				continue
+			}
+			select {
+			case sub.ch <- events[i]:
+			default:
+				slow = append(slow, sub)
+				break send
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		h.remove(sub)
+	}
+}
+
+func (h *eventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (h *eventHub) remove(sub *eventSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Sự kiện task -> Người/nhóm được giao, sự kiện đơn -> Người tạo
+func (s *eventSubscription) matches(e *model.WorkflowEvent) bool {
+	if !e.IsTaskEvent() {
+		return e.CreatorID == s.userID
+	}
+	if e.IsGroup {
+		return s.groups[e.AssignedTo]
+	}
+	return s.assignees[e.AssignedTo]
+}
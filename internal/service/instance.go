@@ -6,15 +6,34 @@ import (
 	"CQS-KYC/internal/repository"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// Số task đã xử lý tối đa trả về 1 lần
+const maxCompletedTasks = 200
+
+// Số đơn tối đa trong 1 lần duyệt hàng loạt
+const maxBulkActionItems = 100
+
+// Kích thước trang Inbox
+const (
+	defaultInboxLimit = 20
+	maxInboxLimit     = 100
+)
+
 type (
 	instanceService struct {
-		repo repository.InstanceRepo
-		db   *gorm.DB // Cần DB để mở Transaction
+		repo      repository.InstanceRepo
+		userRepo  repository.UserRepo
+		sigHelper *repository.SignatureHelper // Kiểm tra chữ ký log (Timeline)
+		bulkCodes map[string]bool             // ServiceCode được duyệt hàng loạt
+		db        *gorm.DB                    // Cần DB để mở Transaction
 	}
 	InstanceService interface {
 		InitiateWorkflow(
@@ -26,20 +45,44 @@ type (
 			factoryID uint64,
 			deptID uint64,
 			requestData []byte,
-			ip string,
-			device string,
+			client model.ClientInfo,
 		) (*model.WorkflowInstance, error)
-		Initiate(ctx context.Context, userID string, req dto.WorkflowInitiateReq) (*model.WorkflowInstance, error)
-		ProcessAction(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowActionReq) error
+		// Dùng kèm InitiateWorkflow(tx): Mở tx với ctx trả về, gọi done(err) sau Commit/Rollback để phát sự kiện
+		CollectEvents(ctx context.Context) (context.Context, func(err error))
+		Initiate(ctx context.Context, userID string, req dto.WorkflowInitiateReq, client model.ClientInfo) (*model.WorkflowInstance, error)
+		ProcessAction(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowActionReq, idempotencyKey string, client model.ClientInfo) (*dto.WorkflowActionRes, error)
+		BulkAction(ctx context.Context, userID, userName string, req dto.WorkflowBulkActionReq, idempotencyKey string, client model.ClientInfo) (*dto.WorkflowBulkActionRes, error)
+		Forward(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowForwardReq, client model.ClientInfo) error
+		Reassign(ctx context.Context, adminID, adminName string, req dto.WorkflowReassignReq, client model.ClientInfo) (*dto.WorkflowReassignRes, error)
+		Claim(ctx context.Context, instanceID uint64, userID, userName string, client model.ClientInfo) error
+		Release(ctx context.Context, instanceID uint64, userID, userName string, client model.ClientInfo) error
+		MigrateInstances(ctx context.Context, adminID, adminName string, req dto.WorkflowMigrateReq, client model.ClientInfo) (*dto.WorkflowMigrateRes, error)
+		SkipStep(ctx context.Context, instanceID uint64, adminID, adminName string, req dto.WorkflowSkipReq, client model.ClientInfo) error
+		Cancel(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowCancelReq, client model.ClientInfo) error
+		Acknowledge(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowAcknowledgeReq, client model.ClientInfo) (string, error)
 		GetPendingTasks(ctx context.Context, userID string) ([]dto.PendingTaskRes, error)
-		GetHistory(ctx context.Context, instanceID uint64) ([]dto.WorkflowLogRes, error)
+		GetInfoTasks(ctx context.Context, userID string) ([]dto.PendingTaskRes, error)
+		SearchTasks(ctx context.Context, userID string, req dto.TaskSearchReq) (*dto.TaskSearchRes, error)
+		ListInstances(ctx context.Context, userID string, req dto.InstanceListReq) (*dto.InstanceListRes, error)
+		GetInstance(ctx context.Context, instanceID uint64, userID string) (*dto.InstanceDetailRes, error)
+		GetTimeline(ctx context.Context, instanceID uint64, userID string) (*dto.InstanceTimelineRes, error)
+		GetCompletedTasks(ctx context.Context, userID string, limit int) ([]dto.CompletedTaskRes, error)
+		GetHistory(ctx context.Context, instanceID uint64, userID string) ([]dto.WorkflowLogRes, error)
+		GetCombinedHistory(ctx context.Context, instanceID uint64, userID string) (*dto.CombinedHistoryRes, error)
 	}
 )
 
-func NewInstanceService(repo repository.InstanceRepo, db *gorm.DB) InstanceService {
+func NewInstanceService(repo repository.InstanceRepo, userRepo repository.UserRepo, sigHelper *repository.SignatureHelper, bulkServiceCodes []string, db *gorm.DB) InstanceService {
+	bulkCodes := make(map[string]bool, len(bulkServiceCodes))
+	for _, code := range bulkServiceCodes {
+		bulkCodes[code] = true
+	}
 	return &instanceService{
-		repo: repo,
-		db:   db,
+		repo:      repo,
+		userRepo:  userRepo,
+		sigHelper: sigHelper,
+		bulkCodes: bulkCodes,
+		db:        db,
 	}
 }
 
@@ -52,30 +95,33 @@ func (s *instanceService) InitiateWorkflow(
 	factoryID uint64,
 	deptID uint64,
 	requestData []byte,
-	ip string,
-	device string,
+	client model.ClientInfo,
 ) (*model.WorkflowInstance, error) {
-	return s.repo.InitiateWorkflow(tx, workflowID, serviceCode, docNum, docType, creatorID, factoryID, deptID, requestData, ip, device)
+	return s.repo.InitiateWorkflow(tx, workflowID, serviceCode, docNum, docType, creatorID, factoryID, deptID, requestData, client)
+}
+
+func (s *instanceService) CollectEvents(ctx context.Context) (context.Context, func(err error)) {
+	return s.repo.CollectEvents(ctx)
 }
 
 // 1. Tạo đơn mới
-func (s *instanceService) Initiate(ctx context.Context, userID string, req dto.WorkflowInitiateReq) (*model.WorkflowInstance, error) {
+func (s *instanceService) Initiate(ctx context.Context, userID string, req dto.WorkflowInitiateReq, client model.ClientInfo) (*model.WorkflowInstance, error) {
 	// Ép kiểu request_data sang JSON bytes
 	reqDataBytes, err := json.Marshal(req.RequestData)
 	if err != nil {
 		return nil, fmt.Errorf("invalid request data json: %w", err)
 	}
 
-	// Mở Transaction (Vì hàm Repo yêu cầu tx)
-	tx := s.db.Begin()
+	// Mở Transaction (Vì hàm Repo yêu cầu tx), sự kiện của Engine phát sau khi Commit
+	ctx, done := s.repo.CollectEvents(ctx)
+	tx := s.db.WithContext(ctx).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
 		}
 	}()
 
-	// Gọi Repo
-	// Lưu ý: IP và Device tạm thời để trống hoặc lấy từ Context nếu Middleware có set
+	// Gọi Repo (IP/Device do Handler lấy từ Fiber request)
 	instance, err := s.repo.InitiateWorkflow(
 		tx,
 		req.WorkflowID,
@@ -86,8 +132,7 @@ func (s *instanceService) Initiate(ctx context.Context, userID string, req dto.W
 		req.FactoryID,
 		req.DeptID,
 		reqDataBytes,
-		"127.0.0.1", // IP Mock
-		"WebClient", // Device Mock
+		client,
 	)
 
 	if err != nil {
@@ -98,13 +143,209 @@ func (s *instanceService) Initiate(ctx context.Context, userID string, req dto.W
 	if err := tx.Commit().Error; err != nil {
 		return nil, err
 	}
+	done(nil)
 
 	return instance, nil
 }
 
 // 2. Xử lý Duyệt/Từ chối
-func (s *instanceService) ProcessAction(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowActionReq) error {
-	return s.repo.ProcessAction(ctx, instanceID, userID, userName, req.Action, req.Comment)
+func (s *instanceService) ProcessAction(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowActionReq, idempotencyKey string, client model.ClientInfo) (*dto.WorkflowActionRes, error) {
+	result, err := s.repo.ProcessAction(ctx, instanceID, userID, userName, req.Action, req.Comment, strings.TrimSpace(req.ReasonCode), idempotencyKey, client)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.WorkflowActionRes{
+		InstanceID:    result.InstanceID,
+		Action:        result.Action,
+		Status:        result.Status,
+		CurrentStep:   result.CurrentStep,
+		Version:       result.Version,
+		Replayed:      result.Replayed,
+		LogID:         result.LogID,
+		SignatureHash: result.Signature,
+	}, nil
+}
+
+// 2.8 Duyệt/Từ chối hàng loạt: Mỗi đơn xử lý riêng (Transaction + chữ ký riêng), lỗi đơn này không ảnh hưởng đơn khác.
+// Vẫn áp dụng mọi quy tắc như duyệt từng đơn (RequireComment, lý do, quyền xử lý...)
+func (s *instanceService) BulkAction(ctx context.Context, userID, userName string, req dto.WorkflowBulkActionReq, idempotencyKey string, client model.ClientInfo) (*dto.WorkflowBulkActionRes, error) {
+	if req.Action != model.ACTION_APPROVE && req.Action != model.ACTION_REJECT {
+		return nil, fmt.Errorf("bulk action must be %s or %s", model.ACTION_APPROVE, model.ACTION_REJECT)
+	}
+	if len(req.InstanceIDs) == 0 {
+		return nil, errors.New("instance_ids is required")
+	}
+	if len(req.InstanceIDs) > maxBulkActionItems {
+		return nil, fmt.Errorf("at most %d instances per bulk action", maxBulkActionItems)
+	}
+
+	serviceCodes, err := s.repo.GetServiceCodes(ctx, req.InstanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.WorkflowBulkActionRes{Results: make([]dto.WorkflowBulkActionItemRes, 0, len(req.InstanceIDs))}
+	seen := make(map[uint64]bool, len(req.InstanceIDs))
+	for _, id := range req.InstanceIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		item := dto.WorkflowBulkActionItemRes{InstanceID: id}
+		code, ok := serviceCodes[id]
+		switch {
+		case !ok:
+			err = errors.New("instance not found")
+		case !s.bulkCodes[code]:
+			err = fmt.Errorf("bulk action is not enabled for %s", code)
+		default:
+			// Key riêng cho từng đơn: Gửi lại cả lô không duyệt lại đơn đã xong
+			itemKey := ""
+			if idempotencyKey != "" {
+				itemKey = fmt.Sprintf("bulk:%s:%d", idempotencyKey, id)
+			}
+			var result *model.ActionResult
+			result, err = s.repo.ProcessAction(ctx, id, userID, userName, req.Action, req.Comment, strings.TrimSpace(req.ReasonCode), itemKey, client)
+			if err == nil {
+				item.Status = result.Status
+				item.CurrentStep = result.CurrentStep
+				item.LogID = result.LogID
+				item.SignatureHash = result.Signature
+				item.Replayed = result.Replayed
+			}
+		}
+
+		if err != nil {
+			item.Error = err.Error()
+			res.Failed++
+		} else {
+			item.Success = true
+			res.Succeeded++
+		}
+		res.Results = append(res.Results, item)
+	}
+	return res, nil
+}
+
+// 2.1 Chuyển task cho người/nhóm khác
+func (s *instanceService) Forward(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowForwardReq, client model.ClientInfo) error {
+	if (req.ToUserID == "") == (req.ToGroupCode == "") {
+		return errors.New("exactly one of to_user_id or to_group_code is required")
+	}
+	if req.ToGroupCode != "" {
+		return s.repo.ForwardTask(ctx, instanceID, userID, userName, req.ToGroupCode, true, req.Comment, client)
+	}
+	return s.repo.ForwardTask(ctx, instanceID, userID, userName, req.ToUserID, false, req.Comment, client)
+}
+
+// 2.2 Admin chuyển hàng loạt task (VD: nhân viên nghỉ việc)
+func (s *instanceService) Reassign(ctx context.Context, adminID, adminName string, req dto.WorkflowReassignReq, client model.ClientInfo) (*dto.WorkflowReassignRes, error) {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return nil, err
+	}
+	if req.FromUserID == "" || req.ToUserID == "" {
+		return nil, errors.New("from_user_id and to_user_id are required")
+	}
+	result, err := s.repo.ReassignTasks(ctx, req.FromUserID, req.ToUserID, adminID, adminName, req.ServiceCode, req.Comment, client)
+	if err != nil {
+		return nil, err
+	}
+	res := &dto.WorkflowReassignRes{
+		MovedTasks:       result.Moved,
+		ReleasedClaims:   result.ReleasedClaims,
+		SkippedInstances: result.SkippedInstances,
+	}
+	if res.SkippedInstances == nil {
+		res.SkippedInstances = []uint64{}
+	}
+	return res, nil
+}
+
+// 2.3 Nhận/Trả task nhóm
+func (s *instanceService) Claim(ctx context.Context, instanceID uint64, userID, userName string, client model.ClientInfo) error {
+	return s.repo.ClaimTask(ctx, instanceID, userID, userName, client)
+}
+
+func (s *instanceService) Release(ctx context.Context, instanceID uint64, userID, userName string, client model.ClientInfo) error {
+	return s.repo.ReleaseTask(ctx, instanceID, userID, userName, client)
+}
+
+// 2.4 Admin chuyển đơn đang chạy sang version quy trình mới (mỗi đơn 1 Transaction, lỗi đơn này không ảnh hưởng đơn khác)
+func (s *instanceService) MigrateInstances(ctx context.Context, adminID, adminName string, req dto.WorkflowMigrateReq, client model.ClientInfo) (*dto.WorkflowMigrateRes, error) {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return nil, err
+	}
+	if req.FromWorkflowID == 0 || req.ToWorkflowID == 0 {
+		return nil, errors.New("from_workflow_id and to_workflow_id are required")
+	}
+
+	mapping := make(map[int]int, len(req.StepMapping))
+	for k, v := range req.StepMapping {
+		from, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step_mapping key %q", k)
+		}
+		mapping[from] = v
+	}
+
+	ids := req.InstanceIDs
+	if len(ids) == 0 {
+		var err error
+		if ids, err = s.repo.GetInProgressInstanceIDs(ctx, req.FromWorkflowID); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &dto.WorkflowMigrateRes{Results: make([]dto.WorkflowMigrateItemRes, 0, len(ids))}
+	for _, id := range ids {
+		item := dto.WorkflowMigrateItemRes{InstanceID: id, Success: true}
+		if err := s.repo.MigrateInstance(ctx, id, req.FromWorkflowID, req.ToWorkflowID, mapping, adminID, adminName, req.Comment, client); err != nil {
+			item.Success = false
+			item.Error = err.Error()
+			res.Failed++
+		} else {
+			res.Migrated++
+		}
+		res.Results = append(res.Results, item)
+	}
+	return res, nil
+}
+
+// 2.5 Admin bỏ qua bước hiện tại
+func (s *instanceService) SkipStep(ctx context.Context, instanceID uint64, adminID, adminName string, req dto.WorkflowSkipReq, client model.ClientInfo) error {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return err
+	}
+	return s.repo.SkipStep(ctx, instanceID, adminID, adminName, req.Comment, client)
+}
+
+// 2.6 Hủy đơn: Người tạo hủy đơn của mình, Admin hủy được mọi đơn
+func (s *instanceService) Cancel(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowCancelReq, client model.ClientInfo) error {
+	asAdmin := s.requireAdmin(ctx, userID) == nil
+	return s.repo.CancelInstance(ctx, instanceID, userID, userName, req.Comment, asAdmin, client)
+}
+
+// 2.7 Xác nhận CC / Đồng ký
+func (s *instanceService) Acknowledge(ctx context.Context, instanceID uint64, userID, userName string, req dto.WorkflowAcknowledgeReq, client model.ClientInfo) (string, error) {
+	return s.repo.AcknowledgeTask(ctx, instanceID, userID, userName, req.Comment, client)
+}
+
+// Chỉ User có Role admin được gọi các API quản trị
+func (s *instanceService) requireAdmin(ctx context.Context, userID string) error {
+	return requireAdmin(ctx, s.userRepo, userID)
+}
+
+func requireAdmin(ctx context.Context, userRepo repository.UserRepo, userID string) error {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return errors.New("admin permission required")
+	}
+	user, err := userRepo.GetByID(ctx, id)
+	if err != nil || user.Role != model.ROLE_ADMIN {
+		return errors.New("admin permission required")
+	}
+	return nil
 }
 
 // 3. Lấy danh sách việc cần làm (Mapping Model -> DTO)
@@ -113,16 +354,146 @@ func (s *instanceService) GetPendingTasks(ctx context.Context, userID string) ([
 	if err != nil {
 		return nil, err
 	}
+	return toPendingTaskRes(tasks), nil
+}
+
+// 3.2 Mục CC: Thông báo / Đồng ký chưa xác nhận (không chặn đơn)
+func (s *instanceService) GetInfoTasks(ctx context.Context, userID string) ([]dto.PendingTaskRes, error) {
+	tasks, err := s.repo.GetInfoTasks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return toPendingTaskRes(tasks), nil
+}
+
+// 3.3 Tìm kiếm Inbox: Lọc + sắp xếp + phân trang cursor, kèm số task theo từng quy trình
+func (s *instanceService) SearchTasks(ctx context.Context, userID string, req dto.TaskSearchReq) (*dto.TaskSearchRes, error) {
+	filter, err := toTaskFilter(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, nextCursor, err := s.repo.SearchPendingTasks(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.repo.CountPendingTasks(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.TaskSearchRes{
+		Items:      toPendingTaskRes(tasks),
+		NextCursor: nextCursor,
+		Counts:     make([]dto.TaskCountRes, 0, len(counts)),
+	}
+	if res.Items == nil {
+		res.Items = []dto.PendingTaskRes{}
+	}
+	for _, c := range counts {
+		res.Counts = append(res.Counts, dto.TaskCountRes{ServiceCode: c.ServiceCode, Total: c.Total, Overdue: c.Overdue})
+		if filter.ServiceCode == "" || filter.ServiceCode == c.ServiceCode {
+			res.Total += c.Total
+		}
+	}
+	return res, nil
+}
+
+func toTaskFilter(req dto.TaskSearchReq) (repository.TaskFilter, error) {
+	filter := repository.TaskFilter{
+		DocNum:      strings.TrimSpace(req.DocNum),
+		ServiceCode: req.ServiceCode,
+		DocType:     req.DocType,
+		CreatorID:   req.CreatorID,
+		FactoryID:   req.FactoryID,
+		Cursor:      req.Cursor,
+		Limit:       pageLimit(req.Limit),
+	}
+
+	from, to, err := parseDateRange(req.From, req.To)
+	if err != nil {
+		return filter, err
+	}
+	filter.From, filter.To = from, to
+	if req.Overdue != "" {
+		overdue, err := strconv.ParseBool(req.Overdue)
+		if err != nil {
+			return filter, fmt.Errorf("invalid overdue %q, expected true/false", req.Overdue)
+		}
+		filter.Overdue = &overdue
+	}
+
+	// Mặc định: Ngày nhận mới nhất trước, Hạn xử lý gần nhất trước
+	switch strings.ToLower(req.Sort) {
+	case "", repository.INBOX_SORT_RECEIVED:
+		filter.SortBy = repository.INBOX_SORT_RECEIVED
+		filter.SortDesc = true
+	case repository.INBOX_SORT_DUE:
+		filter.SortBy = repository.INBOX_SORT_DUE
+	default:
+		return filter, fmt.Errorf("invalid sort %q, allowed: %s, %s", req.Sort, repository.INBOX_SORT_RECEIVED, repository.INBOX_SORT_DUE)
+	}
+	switch strings.ToLower(req.Order) {
+	case "":
+	case "asc":
+		filter.SortDesc = false
+	case "desc":
+		filter.SortDesc = true
+	default:
+		return filter, fmt.Errorf("invalid order %q, allowed: asc, desc", req.Order)
+	}
+	return filter, nil
+}
+
+// Khoảng ngày YYYY-MM-DD -> [from, to+1 ngày). Trống = không giới hạn
+func parseDateRange(fromStr, toStr string) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+	if fromStr != "" {
+		t, err := time.ParseInLocation(time.DateOnly, fromStr, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", fromStr)
+		}
+		from = &t
+	}
+	if toStr != "" {
+		t, err := time.ParseInLocation(time.DateOnly, toStr, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", toStr)
+		}
+		t = t.AddDate(0, 0, 1) // Tính trọn ngày "to"
+		to = &t
+	}
+	return from, to, nil
+}
 
+// Giới hạn kích thước trang (mặc định defaultInboxLimit, tối đa maxInboxLimit)
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultInboxLimit
+	}
+	if limit > maxInboxLimit {
+		return maxInboxLimit
+	}
+	return limit
+}
+
+func toPendingTaskRes(tasks []model.WorkflowTask) []dto.PendingTaskRes {
 	// Map data cho đẹp
+	now := time.Now()
 	var res []dto.PendingTaskRes
 	for _, t := range tasks {
 		item := dto.PendingTaskRes{
-			TaskID:     t.ID,
-			InstanceID: t.InstanceID,
-			StepName:   t.StepName,
-			Status:     t.Status,
-			ReceivedAt: t.CreatedAt,
+			TaskID:        t.ID,
+			InstanceID:    t.InstanceID,
+			StepName:      t.StepName,
+			StepType:      t.StepType,
+			Status:        t.Status,
+			ReceivedAt:    t.CreatedAt,
+			DelegatedFrom: t.DelegatedFrom,
+			ClaimedBy:     t.ClaimedBy,
+			ClaimedAt:     t.ClaimedAt,
+			DueDate:       t.DueDate,
+			Overdue:       t.DueDate != nil && t.DueDate.Before(now),
 		}
 		// Lấy thông tin từ bảng cha (Instance) nhờ Preload
 		if t.Instance != nil {
@@ -133,25 +504,118 @@ func (s *instanceService) GetPendingTasks(ctx context.Context, userID string) ([
 		}
 		res = append(res, item)
 	}
+	return res
+}
+
+// 3.1 Lấy danh sách việc đã làm
+func (s *instanceService) GetCompletedTasks(ctx context.Context, userID string, limit int) ([]dto.CompletedTaskRes, error) {
+	if limit <= 0 || limit > maxCompletedTasks {
+		limit = maxCompletedTasks
+	}
+	tasks, err := s.repo.GetCompletedTasks(ctx, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.CompletedTaskRes, 0, len(tasks))
+	for _, t := range tasks {
+		item := dto.CompletedTaskRes{
+			TaskID:      t.ID,
+			InstanceID:  t.InstanceID,
+			StepName:    t.StepName,
+			Status:      t.Status,
+			Outcome:     t.Outcome,
+			AssignedTo:  t.AssignedTo,
+			IsGroup:     t.IsGroup,
+			ReceivedAt:  t.CreatedAt,
+			CompletedAt: t.CompletedAt,
+		}
+		if t.CompletedAt != nil {
+			item.DurationSeconds = int64(t.CompletedAt.Sub(t.CreatedAt).Seconds())
+		}
+		if t.Instance != nil {
+			item.DocNum = t.Instance.DocNum
+			item.DocType = t.Instance.DocType
+			item.ServiceCode = t.Instance.ServiceCode
+		}
+		res = append(res, item)
+	}
 	return res, nil
 }
 
-// 4. Lấy lịch sử
-func (s *instanceService) GetHistory(ctx context.Context, instanceID uint64) ([]dto.WorkflowLogRes, error) {
-	logs, err := s.repo.GetHistory(ctx, instanceID)
+// 4. Lấy lịch sử (có IP/thiết bị ký -> Chỉ người liên quan đến đơn, cùng phạm vi với chi tiết/timeline)
+func (s *instanceService) GetHistory(ctx context.Context, instanceID uint64, userID string) ([]dto.WorkflowLogRes, error) {
+	logs, err := s.repo.GetHistory(ctx, instanceID, s.visibleTo(ctx, userID))
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("instance not found")
+		}
 		return nil, err
 	}
 
 	var res []dto.WorkflowLogRes
 	for _, l := range logs {
-		res = append(res, dto.WorkflowLogRes{
-			StepName:  l.StepName,
-			Action:    l.Action,
-			ActorName: l.ActorName,
-			Comment:   l.Comment,
-			Time:      l.CreatedAt,
+		res = append(res, toWorkflowLogRes(&l))
+	}
+	return res, nil
+}
+
+func toWorkflowLogRes(l *model.WorkflowLog) dto.WorkflowLogRes {
+	return dto.WorkflowLogRes{
+		ID:               l.ID,
+		StepOrder:        l.StepOrder,
+		StepName:         l.StepName,
+		Action:           l.Action,
+		ActorID:          l.ActorID,
+		ActorName:        l.ActorName,
+		OnBehalfOfID:     l.OnBehalfOfID,
+		TargetID:         l.TargetID,
+		Comment:          l.Comment,
+		ReasonCode:       l.ReasonCode,
+		Time:             l.CreatedAt,
+		SignatureHash:    l.SignatureHash,
+		DataSnapshotHash: l.DataSnapshotHash,
+		SignedTimestamp:  l.SignedTimestamp,
+		IPAddress:        l.IPAddress,
+		DeviceInfo:       l.DeviceInfo,
+		DeviceID:         l.DeviceID,
+	}
+}
+
+// 5. Lịch sử gộp đơn cha/con (Sub-workflow)
+func (s *instanceService) GetCombinedHistory(ctx context.Context, instanceID uint64, userID string) (*dto.CombinedHistoryRes, error) {
+	instances, logs, err := s.repo.GetCombinedHistory(ctx, instanceID, s.visibleTo(ctx, userID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, err
+	}
+
+	res := &dto.CombinedHistoryRes{
+		Instances: make([]dto.LinkedInstanceRes, 0, len(instances)),
+		Logs:      make([]dto.WorkflowLogRes, 0, len(logs)),
+	}
+	if len(instances) > 0 {
+		res.RootInstanceID = instances[0].ID
+	}
+	for _, i := range instances {
+		res.Instances = append(res.Instances, dto.LinkedInstanceRes{
+			ID:               i.ID,
+			ParentInstanceID: i.ParentInstanceID,
+			ParentStepOrder:  i.ParentStepOrder,
+			ServiceCode:      i.ServiceCode,
+			DocNum:           i.DocNum,
+			Status:           i.Status,
+			CurrentStep:      i.CurrentStep,
+			StartedAt:        i.StartedAt,
+			CompletedAt:      i.CompletedAt,
 		})
 	}
+	for _, l := range logs {
+		item := toWorkflowLogRes(&l)
+		item.InstanceID = l.InstanceID
+		res.Logs = append(res.Logs, item)
+	}
 	return res, nil
 }
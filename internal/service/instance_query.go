@@ -0,0 +1,342 @@
+package service
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 6. Danh sách đơn: Admin/Auditor xem tất cả, User chỉ thấy đơn mình tạo hoặc tham gia duyệt
+func (s *instanceService) ListInstances(ctx context.Context, userID string, req dto.InstanceListReq) (*dto.InstanceListRes, error) {
+	from, to, err := parseDateRange(req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	filter := repository.InstanceFilter{
+		VisibleTo:    s.visibleTo(ctx, userID),
+		Status:       req.Status,
+		CreatorID:    req.CreatorID,
+		ServiceCode:  req.ServiceCode,
+		FactoryID:    req.FactoryID,
+		DepartmentID: req.DepartmentID,
+		DocNum:       req.DocNum,
+		From:         from,
+		To:           to,
+		Cursor:       req.Cursor,
+		Limit:        pageLimit(req.Limit),
+	}
+
+	instances, nextCursor, total, err := s.repo.ListInstances(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	res := &dto.InstanceListRes{
+		Items:      make([]dto.InstanceSummaryRes, 0, len(instances)),
+		NextCursor: nextCursor,
+		Total:      total,
+	}
+	for i := range instances {
+		res.Items = append(res.Items, toInstanceSummaryRes(&instances[i]))
+	}
+	return res, nil
+}
+
+// 6.1 Chi tiết đơn: Các bước, người đang xử lý, dữ liệu đơn, lịch sử
+func (s *instanceService) GetInstance(ctx context.Context, instanceID uint64, userID string) (*dto.InstanceDetailRes, error) {
+	instance, err := s.repo.GetInstanceDetail(ctx, instanceID, s.visibleTo(ctx, userID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, err
+	}
+
+	res := &dto.InstanceDetailRes{
+		InstanceSummaryRes: toInstanceSummaryRes(instance),
+		ParentInstanceID:   instance.ParentInstanceID,
+		RequestData:        json.RawMessage(instance.RequestData),
+		Steps:              make([]dto.InstanceStepRes, 0),
+		CurrentAssignees:   make([]dto.CurrentAssigneeRes, 0),
+		Logs:               make([]dto.WorkflowLogRes, 0, len(instance.Logs)),
+	}
+	if len(res.RequestData) == 0 {
+		res.RequestData = json.RawMessage("null")
+	}
+
+	// Bước bị bỏ qua: Log SKIP cuối cùng của bước (RETURN có thể đi lại bước đó)
+	lastAction := make(map[int]string)
+	for _, l := range instance.Logs {
+		lastAction[l.StepOrder] = l.Action
+		res.Logs = append(res.Logs, toWorkflowLogRes(&l))
+	}
+	if instance.Workflow != nil {
+		for _, step := range instance.Workflow.Steps {
+			res.Steps = append(res.Steps, dto.InstanceStepRes{
+				StepOrder: step.StepOrder,
+				StepCode:  step.StepCode,
+				StepName:  step.StepName,
+				StepType:  stepTypeOrDefault(step.StepType),
+				State:     stepState(instance, step.StepOrder, lastAction[step.StepOrder]),
+			})
+		}
+	}
+
+	res.CurrentAssignees = s.pendingAssignees(ctx, instance)
+	return res, nil
+}
+
+// 7. Timeline: Log + Task theo thời gian, thời gian ở từng bước, chữ ký, các bước chưa đến
+func (s *instanceService) GetTimeline(ctx context.Context, instanceID uint64, userID string) (*dto.InstanceTimelineRes, error) {
+	instance, err := s.repo.GetInstanceDetail(ctx, instanceID, s.visibleTo(ctx, userID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("instance not found")
+		}
+		return nil, err
+	}
+
+	res := &dto.InstanceTimelineRes{
+		InstanceID:       instance.ID,
+		DocNum:           instance.DocNum,
+		Status:           instance.Status,
+		CurrentStep:      instance.CurrentStep,
+		Events:           make([]dto.TimelineEventRes, 0, len(instance.Logs)+len(instance.Tasks)),
+		Steps:            make([]dto.TimelineStepRes, 0),
+		PendingAssignees: s.pendingAssignees(ctx, instance),
+	}
+
+	// 1. Sự kiện: Log trước Task để cùng thời điểm thì thao tác đứng trước task nó sinh ra
+	lastAction := make(map[int]string)
+	for i := range instance.Logs {
+		l := &instance.Logs[i]
+		lastAction[l.StepOrder] = l.Action
+		item := toWorkflowLogRes(l)
+		item.SignatureStatus = s.signatureStatus(instance, l)
+		res.Events = append(res.Events, dto.TimelineEventRes{
+			Type:      dto.TIMELINE_LOG,
+			Time:      l.CreatedAt,
+			StepOrder: l.StepOrder,
+			StepName:  l.StepName,
+			Log:       &item,
+		})
+	}
+	for i := range instance.Tasks {
+		t := &instance.Tasks[i]
+		task := &dto.TimelineTaskRes{
+			TaskID:      t.ID,
+			AssignedTo:  t.AssignedTo,
+			IsGroup:     t.IsGroup,
+			Status:      t.Status,
+			Outcome:     t.Outcome,
+			CompletedBy: t.CompletedBy,
+			DueDate:     t.DueDate,
+		}
+		res.Events = append(res.Events, dto.TimelineEventRes{
+			Type:      dto.TIMELINE_TASK_ASSIGNED,
+			Time:      t.CreatedAt,
+			StepOrder: t.StepOrder,
+			StepName:  t.StepName,
+			Task:      task,
+		})
+		// Task DONE đã có Log thao tác, chỉ ghi thêm task tự đóng
+		if t.CompletedAt != nil && t.Status != model.TASK_STATUS_DONE {
+			res.Events = append(res.Events, dto.TimelineEventRes{
+				Type:      dto.TIMELINE_TASK_CLOSED,
+				Time:      *t.CompletedAt,
+				StepOrder: t.StepOrder,
+				StepName:  t.StepName,
+				Task:      task,
+			})
+		}
+	}
+	sort.SliceStable(res.Events, func(i, j int) bool { return res.Events[i].Time.Before(res.Events[j].Time) })
+
+	// 2. Các bước (kể cả bước chưa đến) và thời gian đơn nằm ở mỗi bước
+	if instance.Workflow != nil {
+		visits := stepVisits(instance)
+		now := time.Now()
+		for _, step := range instance.Workflow.Steps {
+			item := dto.TimelineStepRes{
+				StepOrder: step.StepOrder,
+				StepCode:  step.StepCode,
+				StepName:  step.StepName,
+				StepType:  stepTypeOrDefault(step.StepType),
+				State:     stepState(instance, step.StepOrder, lastAction[step.StepOrder]),
+			}
+			stepVisits := visits[step.StepOrder]
+			item.Visits = len(stepVisits)
+			for i, v := range stepVisits {
+				if i == 0 {
+					entered := v.start
+					item.EnteredAt = &entered
+				}
+				end := now
+				if v.end != nil {
+					end = *v.end
+				}
+				item.DurationSeconds += int64(end.Sub(v.start).Seconds())
+			}
+			if n := len(stepVisits); n > 0 && stepVisits[n-1].end != nil {
+				left := *stepVisits[n-1].end
+				item.LeftAt = &left
+			}
+			res.Steps = append(res.Steps, item)
+		}
+	}
+	return res, nil
+}
+
+// Task đang mở ở bước hiện tại (tên người nhận nếu giao trực tiếp)
+func (s *instanceService) pendingAssignees(ctx context.Context, instance *model.WorkflowInstance) []dto.CurrentAssigneeRes {
+	res := make([]dto.CurrentAssigneeRes, 0)
+	for _, t := range instance.Tasks {
+		if t.StepOrder != instance.CurrentStep || (t.Status != model.TASK_STATUS_PENDING && t.Status != model.TASK_STATUS_CLAIMED) {
+			continue
+		}
+		assignee := dto.CurrentAssigneeRes{
+			TaskID:     t.ID,
+			AssignedTo: t.AssignedTo,
+			IsGroup:    t.IsGroup,
+			Status:     t.Status,
+			ClaimedBy:  t.ClaimedBy,
+			DueDate:    t.DueDate,
+		}
+		if !t.IsGroup {
+			if uid, err := strconv.ParseUint(t.AssignedTo, 10, 64); err == nil {
+				if u, err := s.userRepo.GetByID(ctx, uid); err == nil {
+					assignee.Name = u.FullName
+				}
+			}
+		}
+		res = append(res, assignee)
+	}
+	return res
+}
+
+func (s *instanceService) signatureStatus(instance *model.WorkflowInstance, l *model.WorkflowLog) string {
+	if l.SignatureHash == "" {
+		return dto.SIGNATURE_UNSIGNED
+	}
+	if s.sigHelper != nil && s.sigHelper.VerifySignature(l.ActorID, instance.DocNum, l.Action, l.StepOrder, l.SignedTimestamp, l.DataSnapshotHash, l.SignatureHash) {
+		return dto.SIGNATURE_VALID
+	}
+	return dto.SIGNATURE_INVALID
+}
+
+// 1 lượt đơn nằm ở 1 bước. end = nil: Đang ở bước này
+type stepVisit struct {
+	start time.Time
+	end   *time.Time
+}
+
+// Lượt vào từng bước: Task của bước tạo chồng lên nhau (Chuyển, Leo thang...) tính chung 1 lượt,
+// task tạo sau khi lượt trước đã đóng (RETURN đi lại) là lượt mới. Bước Sub-workflow tính theo log SUBFLOW_START/END
+func stepVisits(instance *model.WorkflowInstance) map[int][]stepVisit {
+	res := make(map[int][]stepVisit)
+	add := func(stepOrder int, start time.Time, end *time.Time) {
+		visits := res[stepOrder]
+		if n := len(visits); n > 0 && (visits[n-1].end == nil || !start.After(*visits[n-1].end)) {
+			last := &visits[n-1]
+			if last.end != nil && (end == nil || end.After(*last.end)) {
+				last.end = end
+			}
+			return
+		}
+		res[stepOrder] = append(visits, stepVisit{start: start, end: end})
+	}
+
+	for _, t := range instance.Tasks { // Đã sắp theo created_at
+		add(t.StepOrder, t.CreatedAt, t.CompletedAt)
+	}
+	for _, l := range instance.Logs {
+		switch l.Action {
+		case model.ACTION_SUBFLOW_START:
+			add(l.StepOrder, l.CreatedAt, nil)
+		case model.ACTION_SUBFLOW_END:
+			if visits := res[l.StepOrder]; len(visits) > 0 && visits[len(visits)-1].end == nil {
+				end := l.CreatedAt
+				visits[len(visits)-1].end = &end
+			}
+		}
+	}
+	return res
+}
+
+// "" = Xem tất cả đơn (Admin, Auditor), ngược lại chỉ đơn liên quan đến userID
+func (s *instanceService) visibleTo(ctx context.Context, userID string) string {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return userID
+	}
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err == nil && (user.Role == model.ROLE_ADMIN || user.Role == model.ROLE_AUDITOR) {
+		return ""
+	}
+	return userID
+}
+
+func toInstanceSummaryRes(instance *model.WorkflowInstance) dto.InstanceSummaryRes {
+	res := dto.InstanceSummaryRes{
+		ID:              instance.ID,
+		DocNum:          instance.DocNum,
+		DocType:         instance.DocType,
+		ServiceCode:     instance.ServiceCode,
+		WorkflowVersion: instance.WorkflowVersion,
+		Status:          instance.Status,
+		CurrentStep:     instance.CurrentStep,
+		TotalSteps:      instance.TotalSteps,
+		Progress:        instanceProgress(instance),
+		CreatorID:       instance.CreatorID,
+		FactoryID:       instance.FactoryID,
+		DepartmentID:    instance.DepartmentID,
+		StartedAt:       instance.StartedAt,
+		CompletedAt:     instance.CompletedAt,
+	}
+	if instance.Workflow != nil {
+		res.WorkflowName = instance.Workflow.WorkflowName
+	}
+	return res
+}
+
+// % tiến độ: Số bước đã qua / Tổng số bước. Đơn đã duyệt = 100.
+// CurrentStep là StepOrder (có thể cách quãng 10/20/30) -> Đếm số bước có StepOrder < CurrentStep
+func instanceProgress(instance *model.WorkflowInstance) int {
+	if instance.Status == model.STATUS_APPROVED {
+		return 100
+	}
+	if instance.Workflow == nil || len(instance.Workflow.Steps) == 0 {
+		return 0
+	}
+	done := 0
+	for _, step := range instance.Workflow.Steps {
+		if step.StepOrder < instance.CurrentStep {
+			done++
+		}
+	}
+	return done * 100 / len(instance.Workflow.Steps)
+}
+
+// Trạng thái 1 bước theo vị trí so với bước hiện tại của đơn
+func stepState(instance *model.WorkflowInstance, stepOrder int, lastAction string) string {
+	switch {
+	case lastAction == model.ACTION_SKIP && stepOrder != instance.CurrentStep:
+		return dto.STEP_STATE_SKIPPED
+	case instance.Status == model.STATUS_APPROVED || stepOrder < instance.CurrentStep:
+		return dto.STEP_STATE_DONE
+	case stepOrder == instance.CurrentStep && instance.Status == model.STATUS_IN_PROGRESS:
+		return dto.STEP_STATE_CURRENT
+	case instance.Status == model.STATUS_IN_PROGRESS:
+		return dto.STEP_STATE_UPCOMING
+	case stepOrder == instance.CurrentStep:
+		return instance.Status // Bước đơn bị Từ chối / Hủy
+	}
+	return dto.STEP_STATE_NOT_REACHED
+}
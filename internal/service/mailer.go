@@ -0,0 +1,78 @@
+package service
+
+import (
+	"CQS-KYC/config"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type (
+	smtpMailer struct {
+		cfg config.SMTPConfig
+	}
+	// Gửi 1 email dạng text (UTF-8)
+	Mailer interface {
+		Send(to, subject, body string) error
+	}
+)
+
+func NewSMTPMailer(cfg config.SMTPConfig) Mailer {
+	return &smtpMailer{cfg: cfg}
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	if m.cfg.Host == "" || m.cfg.From == "" {
+		return errors.New("smtp host and from address are not configured")
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient email %q: %w", to, err)
+	}
+
+	from := mail.Address{Name: m.cfg.FromName, Address: m.cfg.From}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", rcpt.String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	// Base64 xuống dòng mỗi 76 ký tự (RFC 2045)
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		msg.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	msg.WriteString(encoded + "\r\n")
+
+	// Không có Username (VD: MailHog/Mailpit khi dev) -> Gửi không đăng nhập
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	port := m.cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := fmt.Sprintf("%s:%d", m.cfg.Host, port)
+	return smtp.SendMail(addr, auth, m.cfg.From, []string{rcpt.Address}, msg.Bytes())
+}
+
+// Email lấy từ dữ liệu User, chặn xuống dòng (chèn header)
+func validEmail(email string) bool {
+	email = strings.TrimSpace(email)
+	if email == "" || strings.ContainsAny(email, "\r\n") {
+		return false
+	}
+	_, err := mail.ParseAddress(email)
+	return err == nil
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"CQS-KYC/config"
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Thử lại sau 1, 2, 4... phút, tối đa 1 giờ
+	notificationRetryBase = time.Minute
+	notificationRetryMax  = time.Hour
+)
+
+type (
+	notificationService struct {
+		repo        repository.NotificationRepo
+		userRepo    repository.UserRepo
+		deptRepo    repository.DepartmentRepo
+		mailer      Mailer
+		interval    time.Duration
+		batchSize   int
+		maxAttempts int
+		defaultLang string
+		appURL      string
+	}
+	// Gửi email trong hàng đợi (workflow_notifications) do Engine ghi, và cài đặt nhận thông báo của User
+	NotificationService interface {
+		Start(ctx context.Context)
+		RunOnce(ctx context.Context) (int, error)
+
+		GetPreference(ctx context.Context, userID string) (*dto.NotificationPreferenceRes, error)
+		UpdatePreference(ctx context.Context, userID string, req dto.NotificationPreferenceReq) (*dto.NotificationPreferenceRes, error)
+		// Gửi ngay 1 email mẫu đến User (không qua hàng đợi) để kiểm tra SMTP/template
+		SendTest(ctx context.Context, userID string, req dto.NotificationTestReq) (*dto.NotificationTestRes, error)
+	}
+)
+
+func NewNotificationService(repo repository.NotificationRepo, userRepo repository.UserRepo, deptRepo repository.DepartmentRepo, mailer Mailer, cfg *config.Config) NotificationService {
+	return &notificationService{
+		repo:        repo,
+		userRepo:    userRepo,
+		deptRepo:    deptRepo,
+		mailer:      mailer,
+		interval:    cfg.GetNotificationInterval(),
+		batchSize:   cfg.GetNotificationBatchSize(),
+		maxAttempts: cfg.GetNotificationMaxAttempts(),
+		defaultLang: cfg.GetNotificationLanguage(),
+		appURL:      strings.TrimRight(cfg.Notification.AppURL, "/"),
+	}
+}
+
+// Chạy cho đến khi ctx bị hủy (Graceful Shutdown)
+func (s *notificationService) Start(ctx context.Context) {
+	runOutbox(ctx, s.interval, "MAIL", "email", s.RunOnce)
+}
+
+// Gửi 1 lượt, trả về số email đã gửi thành công
+func (s *notificationService) RunOnce(ctx context.Context) (int, error) {
+	items, err := s.repo.ClaimDue(ctx, time.Now(), s.batchSize, outboxLease)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i := range items {
+		if ctx.Err() != nil {
+			break // Đang tắt: Email còn lại hết lease sẽ được gửi lại
+		}
+		if s.deliver(ctx, &items[i]) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// Gửi 1 email và cập nhật trạng thái. Lỗi SMTP -> Thử lại với backoff, hết lượt -> FAILED
+func (s *notificationService) deliver(ctx context.Context, n *model.Notification) bool {
+	user, err := s.recipient(ctx, n.RecipientID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.skip(ctx, n, "recipient not found")
+		return false
+	}
+	if err == nil && !validEmail(user.Email) {
+		s.skip(ctx, n, "recipient has no valid email")
+		return false
+	}
+
+	var pref *model.NotificationPreference
+	if err == nil {
+		pref, err = s.repo.GetPreference(ctx, n.RecipientID)
+	}
+	if err == nil && !pref.Wants(n.Event) {
+		s.skip(ctx, n, "disabled by user preference")
+		return false
+	}
+
+	if err == nil {
+		var subject, body string
+		subject, body, err = s.render(ctx, n.Event, s.language(pref), user, n)
+		if err == nil {
+			err = s.mailer.Send(user.Email, subject, body)
+		}
+	}
+
+	attempts := n.Attempts + 1
+	if err == nil {
+		if err := s.repo.MarkSent(ctx, n.ID, attempts); err != nil {
+			fmt.Printf("[MAIL] notification %d: %v\n", n.ID, err)
+		}
+		return true
+	}
+
+	final := attempts >= s.maxAttempts
+	if err := s.repo.MarkFailed(ctx, n.ID, attempts, time.Now().Add(retryBackoff(attempts, notificationRetryBase, notificationRetryMax)), err.Error(), final); err != nil {
+		fmt.Printf("[MAIL] notification %d: %v\n", n.ID, err)
+	}
+	fmt.Printf("[MAIL] notification %d to %s (attempt %d): %v\n", n.ID, n.RecipientID, attempts, err)
+	return false
+}
+
+func (s *notificationService) skip(ctx context.Context, n *model.Notification, reason string) {
+	if err := s.repo.MarkSkipped(ctx, n.ID, reason); err != nil {
+		fmt.Printf("[MAIL] notification %d: %v\n", n.ID, err)
+	}
+}
+
+func (s *notificationService) recipient(ctx context.Context, userID string) (*model.User, error) {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound // RecipientID không phải User (VD: SYSTEM)
+	}
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (s *notificationService) language(pref *model.NotificationPreference) string {
+	if pref != nil && pref.Language != "" {
+		return pref.Language
+	}
+	return s.defaultLang
+}
+
+func (s *notificationService) render(ctx context.Context, event, lang string, user *model.User, n *model.Notification) (string, string, error) {
+	view := notificationView{
+		RecipientName: user.FullName,
+		DocNum:        n.Data.DocNum,
+		DocType:       n.Data.DocType,
+		ServiceCode:   n.Data.ServiceCode,
+		StepName:      n.Data.StepName,
+		ActorName:     n.Data.ActorName,
+		Comment:       n.Data.Comment,
+		ReasonCode:    n.Data.ReasonCode,
+	}
+	if view.RecipientName == "" {
+		view.RecipientName = user.UserCode
+	}
+	if view.DocType == "" {
+		view.DocType = n.Data.ServiceCode
+	}
+	if n.Data.DueDate != nil {
+		view.DueDate = n.Data.DueDate.Format("2006-01-02 15:04")
+	}
+	if s.appURL != "" && n.InstanceID != 0 {
+		view.Link = fmt.Sprintf("%s/instances/%d", s.appURL, n.InstanceID)
+	}
+	if n.Data.DepartmentID != 0 {
+		// Phòng ban đã xóa -> Để trống, không chặn gửi
+		if dept, err := s.deptRepo.GetByID(ctx, n.Data.DepartmentID); err == nil {
+			view.Department = departmentName(dept, lang)
+		}
+	}
+	return renderNotification(lang, event, view)
+}
+
+func (s *notificationService) GetPreference(ctx context.Context, userID string) (*dto.NotificationPreferenceRes, error) {
+	pref, err := s.repo.GetPreference(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return toNotificationPreferenceRes(pref), nil
+}
+
+func (s *notificationService) UpdatePreference(ctx context.Context, userID string, req dto.NotificationPreferenceReq) (*dto.NotificationPreferenceRes, error) {
+	pref, err := s.repo.GetPreference(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Language != nil {
+		lang := strings.ToLower(strings.TrimSpace(*req.Language))
+		if lang != "" && lang != model.LANG_VI && lang != model.LANG_EN && lang != model.LANG_TW {
+			return nil, fmt.Errorf("invalid language %s, expected one of: %s, %s, %s", *req.Language, model.LANG_VI, model.LANG_EN, model.LANG_TW)
+		}
+		pref.Language = lang
+	}
+	if req.EmailEnabled != nil {
+		pref.EmailEnabled = *req.EmailEnabled
+	}
+	if req.MutedEvents != nil {
+		muted := make([]string, 0, len(req.MutedEvents))
+		seen := map[string]bool{}
+		for _, e := range req.MutedEvents {
+			e = strings.ToUpper(strings.TrimSpace(e))
+			if !isNotificationEvent(e) {
+				return nil, fmt.Errorf("invalid event %s, expected one of: %s", e, strings.Join(model.NOTIFICATION_EVENTS, ", "))
+			}
+			if !seen[e] {
+				seen[e] = true
+				muted = append(muted, e)
+			}
+		}
+		pref.MutedEvents = muted
+	}
+	if pref.MutedEvents == nil {
+		pref.MutedEvents = []string{}
+	}
+
+	pref.UserID = userID
+	if err := s.repo.SavePreference(ctx, pref); err != nil {
+		return nil, err
+	}
+	return toNotificationPreferenceRes(pref), nil
+}
+
+func (s *notificationService) SendTest(ctx context.Context, userID string, req dto.NotificationTestReq) (*dto.NotificationTestRes, error) {
+	event := strings.ToUpper(strings.TrimSpace(req.Event))
+	if event == "" {
+		event = model.EVENT_TASK_ASSIGNED
+	}
+	if !isNotificationEvent(event) {
+		return nil, fmt.Errorf("invalid event %s, expected one of: %s", event, strings.Join(model.NOTIFICATION_EVENTS, ", "))
+	}
+
+	user, err := s.recipient(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user %s not found", userID)
+	}
+	if !validEmail(user.Email) {
+		return nil, fmt.Errorf("user %s has no valid email", userID)
+	}
+	pref, err := s.repo.GetPreference(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	due := time.Now().Add(24 * time.Hour)
+	sample := &model.Notification{
+		Event:       event,
+		RecipientID: userID,
+		Data: model.NotificationData{
+			DocNum:       "TEST-0001",
+			DocType:      "TEST",
+			ServiceCode:  "TEST",
+			DepartmentID: user.DepartmentID,
+			StepName:     "Test step",
+			ActorName:    user.FullName,
+			Comment:      "This is a test email",
+			DueDate:      &due,
+		},
+	}
+	subject, body, err := s.render(ctx, event, s.language(pref), user, sample)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mailer.Send(user.Email, subject, body); err != nil {
+		return nil, fmt.Errorf("failed to send test email: %w", err)
+	}
+	return &dto.NotificationTestRes{To: user.Email, Subject: subject}, nil
+}
+
+func isNotificationEvent(event string) bool {
+	for _, e := range model.NOTIFICATION_EVENTS {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func toNotificationPreferenceRes(pref *model.NotificationPreference) *dto.NotificationPreferenceRes {
+	muted := pref.MutedEvents
+	if muted == nil {
+		muted = []string{}
+	}
+	return &dto.NotificationPreferenceRes{
+		UserID:       pref.UserID,
+		Language:     pref.Language,
+		EmailEnabled: pref.EmailEnabled,
+		MutedEvents:  muted,
+		Events:       model.NOTIFICATION_EVENTS,
+		UpdatedAt:    pref.UpdatedAt,
+	}
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"CQS-KYC/internal/model"
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// Dữ liệu đưa vào template email
+type notificationView struct {
+	RecipientName string
+	DocNum        string
+	DocType       string
+	ServiceCode   string
+	Department    string // Tên phòng ban theo ngôn ngữ người nhận
+	StepName      string
+	ActorName     string
+	Comment       string
+	ReasonCode    string
+	DueDate       string
+	Link          string // Trống nếu chưa cấu hình notification.app_url
+}
+
+type notificationTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// Phần chung cuối email
+const (
+	footerVI = `{{if .Link}}
+Xem chi tiết: {{.Link}}{{end}}
+
+--
+Email tự động từ hệ thống EFNET Workflow, vui lòng không trả lời.`
+	footerEN = `{{if .Link}}
+View details: {{.Link}}{{end}}
+
+--
+This is an automated message from EFNET Workflow, please do not reply.`
+	footerTW = `{{if .Link}}
+查看詳情：{{.Link}}{{end}}
+
+--
+此郵件由 EFNET Workflow 系統自動發送，請勿回覆。`
+)
+
+// Ngôn ngữ -> Sự kiện -> {Tiêu đề, Nội dung}
+var notificationTemplateText = map[string]map[string][2]string{
+	model.LANG_VI: {
+		model.EVENT_TASK_ASSIGNED: {
+			`[EFNET] Đơn {{.DocNum}} chờ bạn xử lý`,
+			`Chào {{.RecipientName}},
+
+Bạn có đơn mới cần xử lý:
+- Số đơn: {{.DocNum}} ({{.DocType}}){{if .Department}}
+- Phòng ban: {{.Department}}{{end}}
+- Bước: {{.StepName}}{{if .DueDate}}
+- Hạn xử lý: {{.DueDate}}{{end}}
+` + footerVI,
+		},
+		model.EVENT_TASK_OVERDUE: {
+			`[EFNET] Đơn {{.DocNum}} đã quá hạn xử lý`,
+			`Chào {{.RecipientName}},
+
+Đơn sau đã quá hạn xử lý:
+- Số đơn: {{.DocNum}} ({{.DocType}}){{if .Department}}
+- Phòng ban: {{.Department}}{{end}}
+- Bước: {{.StepName}}{{if .DueDate}}
+- Hạn xử lý: {{.DueDate}}{{end}}
+` + footerVI,
+		},
+		model.EVENT_INSTANCE_APPROVED: {
+			`[EFNET] Đơn {{.DocNum}} đã được duyệt`,
+			`Chào {{.RecipientName}},
+
+Đơn {{.DocNum}} ({{.DocType}}) của bạn đã được duyệt hoàn tất.{{if .ActorName}}
+- Người duyệt cuối: {{.ActorName}}{{end}}{{if .Comment}}
+- Ý kiến: {{.Comment}}{{end}}
+` + footerVI,
+		},
+		model.EVENT_INSTANCE_REJECTED: {
+			`[EFNET] Đơn {{.DocNum}} bị từ chối`,
+			`Chào {{.RecipientName}},
+
+Đơn {{.DocNum}} ({{.DocType}}) của bạn đã bị từ chối.{{if .StepName}}
+- Bước: {{.StepName}}{{end}}{{if .ActorName}}
+- Người từ chối: {{.ActorName}}{{end}}{{if .ReasonCode}}
+- Mã lý do: {{.ReasonCode}}{{end}}{{if .Comment}}
+- Ý kiến: {{.Comment}}{{end}}
+` + footerVI,
+		},
+		model.EVENT_INSTANCE_RETURNED: {
+			`[EFNET] Đơn {{.DocNum}} bị trả về`,
+			`Chào {{.RecipientName}},
+
+Đơn {{.DocNum}} ({{.DocType}}) của bạn đã bị trả về.
+- Bước: {{.StepName}}
+- Người trả về: {{.ActorName}}{{if .ReasonCode}}
+- Mã lý do: {{.ReasonCode}}{{end}}{{if .Comment}}
+- Ý kiến: {{.Comment}}{{end}}
+` + footerVI,
+		},
+	},
+	model.LANG_EN: {
+		model.EVENT_TASK_ASSIGNED: {
+			`[EFNET] Request {{.DocNum}} is waiting for you`,
+			`Hello {{.RecipientName}},
+
+A new request needs your action:
+- Document: {{.DocNum}} ({{.DocType}}){{if .Department}}
+- Department: {{.Department}}{{end}}
+- Step: {{.StepName}}{{if .DueDate}}
+- Due: {{.DueDate}}{{end}}
+` + footerEN,
+		},
+		model.EVENT_TASK_OVERDUE: {
+			`[EFNET] Request {{.DocNum}} is overdue`,
+			`Hello {{.RecipientName}},
+
+The following request is overdue:
+- Document: {{.DocNum}} ({{.DocType}}){{if .Department}}
+- Department: {{.Department}}{{end}}
+- Step: {{.StepName}}{{if .DueDate}}
+- Due: {{.DueDate}}{{end}}
+` + footerEN,
+		},
+		model.EVENT_INSTANCE_APPROVED: {
+			`[EFNET] Request {{.DocNum}} has been approved`,
+			`Hello {{.RecipientName}},
+
+Your request {{.DocNum}} ({{.DocType}}) has been fully approved.{{if .ActorName}}
+- Final approver: {{.ActorName}}{{end}}{{if .Comment}}
+- Comment: {{.Comment}}{{end}}
+` + footerEN,
+		},
+		model.EVENT_INSTANCE_REJECTED: {
+			`[EFNET] Request {{.DocNum}} has been rejected`,
+			`Hello {{.RecipientName}},
+
+Your request {{.DocNum}} ({{.DocType}}) has been rejected.{{if .StepName}}
+- Step: {{.StepName}}{{end}}{{if .ActorName}}
+- Rejected by: {{.ActorName}}{{end}}{{if .ReasonCode}}
+- Reason code: {{.ReasonCode}}{{end}}{{if .Comment}}
+- Comment: {{.Comment}}{{end}}
+` + footerEN,
+		},
+		model.EVENT_INSTANCE_RETURNED: {
+			`[EFNET] Request {{.DocNum}} has been returned`,
+			`Hello {{.RecipientName}},
+
+Your request {{.DocNum}} ({{.DocType}}) has been returned.
+- Step: {{.StepName}}
+- Returned by: {{.ActorName}}{{if .ReasonCode}}
+- Reason code: {{.ReasonCode}}{{end}}{{if .Comment}}
+- Comment: {{.Comment}}{{end}}
+` + footerEN,
+		},
+	},
+	model.LANG_TW: {
+		model.EVENT_TASK_ASSIGNED: {
+			`[EFNET] 單據 {{.DocNum}} 待您處理`,
+			`{{.RecipientName}} 您好，
+
+您有新的單據需要處理：
+- 單號：{{.DocNum}}（{{.DocType}}）{{if .Department}}
+- 部門：{{.Department}}{{end}}
+- 步驟：{{.StepName}}{{if .DueDate}}
+- 期限：{{.DueDate}}{{end}}
+` + footerTW,
+		},
+		model.EVENT_TASK_OVERDUE: {
+			`[EFNET] 單據 {{.DocNum}} 已逾期`,
+			`{{.RecipientName}} 您好，
+
+以下單據已超過處理期限：
+- 單號：{{.DocNum}}（{{.DocType}}）{{if .Department}}
+- 部門：{{.Department}}{{end}}
+- 步驟：{{.StepName}}{{if .DueDate}}
+- 期限：{{.DueDate}}{{end}}
+` + footerTW,
+		},
+		model.EVENT_INSTANCE_APPROVED: {
+			`[EFNET] 單據 {{.DocNum}} 已核准`,
+			`{{.RecipientName}} 您好，
+
+您的單據 {{.DocNum}}（{{.DocType}}）已完成簽核。{{if .ActorName}}
+- 最後核准人：{{.ActorName}}{{end}}{{if .Comment}}
+- 意見：{{.Comment}}{{end}}
+` + footerTW,
+		},
+		model.EVENT_INSTANCE_REJECTED: {
+			`[EFNET] 單據 {{.DocNum}} 已被駁回`,
+			`{{.RecipientName}} 您好，
+
+您的單據 {{.DocNum}}（{{.DocType}}）已被駁回。{{if .StepName}}
+- 步驟：{{.StepName}}{{end}}{{if .ActorName}}
+- 駁回人：{{.ActorName}}{{end}}{{if .ReasonCode}}
+- 原因代碼：{{.ReasonCode}}{{end}}{{if .Comment}}
+- 意見：{{.Comment}}{{end}}
+` + footerTW,
+		},
+		model.EVENT_INSTANCE_RETURNED: {
+			`[EFNET] 單據 {{.DocNum}} 已被退回`,
+			`{{.RecipientName}} 您好，
+
+您的單據 {{.DocNum}}（{{.DocType}}）已被退回。
+- 步驟：{{.StepName}}
+- 退回人：{{.ActorName}}{{if .ReasonCode}}
+- 原因代碼：{{.ReasonCode}}{{end}}{{if .Comment}}
+- 意見：{{.Comment}}{{end}}
+` + footerTW,
+		},
+	},
+}
+
+// Parse 1 lần lúc khởi động, template sai -> panic ngay (lỗi lập trình)
+var notificationTemplates = func() map[string]map[string]notificationTemplate {
+	out := make(map[string]map[string]notificationTemplate, len(notificationTemplateText))
+	for lang, events := range notificationTemplateText {
+		out[lang] = make(map[string]notificationTemplate, len(events))
+		for event, text := range events {
+			name := lang + "." + event
+			out[lang][event] = notificationTemplate{
+				subject: template.Must(template.New(name + ".subject").Parse(text[0])),
+				body:    template.Must(template.New(name + ".body").Parse(text[1])),
+			}
+		}
+	}
+	return out
+}()
+
+func renderNotification(lang, event string, view notificationView) (string, string, error) {
+	tpl, ok := notificationTemplates[lang][event]
+	if !ok {
+		tpl, ok = notificationTemplates[model.LANG_VI][event]
+	}
+	if !ok {
+		return "", "", fmt.Errorf("no email template for event %s", event)
+	}
+	var subject, body bytes.Buffer
+	if err := tpl.subject.Execute(&subject, view); err != nil {
+		return "", "", err
+	}
+	if err := tpl.body.Execute(&body, view); err != nil {
+		return "", "", err
+	}
+	return subject.String(), body.String(), nil
+}
+
+// Tên phòng ban theo ngôn ngữ (thiếu bản dịch -> Dùng tên tiếng Việt)
+func departmentName(dept *model.Department, lang string) string {
+	if dept == nil {
+		return ""
+	}
+	name := dept.NameVN
+	switch lang {
+	case model.LANG_EN:
+		if dept.NameEN != "" {
+			name = dept.NameEN
+		}
+	case model.LANG_TW:
+		if dept.NameTW != "" {
+			name = dept.NameTW
+		}
+	}
+	if name == "" {
+		name = dept.Code
+	}
+	return name
+}
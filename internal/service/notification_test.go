@@ -0,0 +1,406 @@
+package service
+
+import (
+	"CQS-KYC/config"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"CQS-KYC/internal/testutil"
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Mailer giả: Ghi lại email đã gửi, lỗi failures lần đầu
+type fakeMailer struct {
+	failures int
+	sent     []fakeMail
+}
+
+type fakeMail struct {
+	to, subject, body string
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("smtp unavailable")
+	}
+	m.sent = append(m.sent, fakeMail{to: to, subject: subject, body: body})
+	return nil
+}
+
+// DB SQLite trong bộ nhớ: User 1 (có email), phòng ban 1 có tên 3 ngôn ngữ
+func newNotificationTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testutil.NewDB(t, &model.User{}, &model.Department{}, &model.Notification{}, &model.NotificationPreference{})
+	if err := db.Create(&model.Department{ID: 1, Code: "QA", NameVN: "Phòng Chất lượng", NameEN: "Quality", NameTW: "品質部"}).Error; err != nil {
+		t.Fatalf("create department: %v", err)
+	}
+	if err := db.Create(&model.User{ID: 1, UserCode: "U1", FullName: "User 1", Email: "u1@example.com", DepartmentID: 1, IsActive: true}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return db
+}
+
+func newTestNotificationService(db *gorm.DB, mailer Mailer, maxAttempts int) *notificationService {
+	cfg := &config.Config{}
+	cfg.Notification.MaxAttempts = maxAttempts
+	cfg.Notification.AppURL = "https://efnet.example.com/"
+	return NewNotificationService(repository.NewNotificationRepo(db), repository.NewUserRepo(db), repository.NewDepartmentRepo(db), mailer, cfg).(*notificationService)
+}
+
+func queueNotification(t *testing.T, db *gorm.DB, event, recipientID string) *model.Notification {
+	t.Helper()
+	n := &model.Notification{
+		Event:         event,
+		InstanceID:    7,
+		RecipientID:   recipientID,
+		Data:          model.NotificationData{DocNum: "DOC-001", ServiceCode: "PURI05", DepartmentID: 1, StepName: "Manager"},
+		Status:        model.NOTIFICATION_PENDING,
+		NextAttemptAt: time.Now().Add(-time.Second),
+	}
+	if err := db.Create(n).Error; err != nil {
+		t.Fatalf("queue notification: %v", err)
+	}
+	return n
+}
+
+func reloadNotification(t *testing.T, db *gorm.DB, id uint64) *model.Notification {
+	t.Helper()
+	var n model.Notification
+	if err := db.First(&n, id).Error; err != nil {
+		t.Fatalf("reload notification: %v", err)
+	}
+	return &n
+}
+
+func TestRunOnceSendsQueuedEmail(t *testing.T) {
+	db := newNotificationTestDB(t)
+	mailer := &fakeMailer{}
+	s := newTestNotificationService(db, mailer, 3)
+	n := queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "1")
+
+	sent, err := s.RunOnce(context.Background())
+	if err != nil || sent != 1 {
+		t.Fatalf("RunOnce = %d, %v; want 1, nil", sent, err)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].to != "u1@example.com" {
+		t.Fatalf("sent = %+v", mailer.sent)
+	}
+	if !strings.Contains(mailer.sent[0].body, "https://efnet.example.com/instances/7") {
+		t.Errorf("body has no instance link:\n%s", mailer.sent[0].body)
+	}
+	got := reloadNotification(t, db, n.ID)
+	if got.Status != model.NOTIFICATION_SENT || got.Attempts != 1 || got.SentAt == nil {
+		t.Errorf("status = %s, attempts = %d, sent_at = %v", got.Status, got.Attempts, got.SentAt)
+	}
+
+	// Đã gửi -> Lượt sau không gửi lại
+	if sent, _ := s.RunOnce(context.Background()); sent != 0 || len(mailer.sent) != 1 {
+		t.Errorf("second RunOnce sent again: %d", sent)
+	}
+}
+
+func TestRunOnceRetriesWithBackoffThenFails(t *testing.T) {
+	db := newNotificationTestDB(t)
+	mailer := &fakeMailer{failures: 10}
+	s := newTestNotificationService(db, mailer, 3)
+	n := queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "1")
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		before := time.Now()
+		if sent, err := s.RunOnce(context.Background()); err != nil || sent != 0 {
+			t.Fatalf("attempt %d: RunOnce = %d, %v", attempt, sent, err)
+		}
+		got := reloadNotification(t, db, n.ID)
+		if got.Attempts != attempt || got.LastError == "" {
+			t.Fatalf("attempt %d: attempts = %d, last_error = %q", attempt, got.Attempts, got.LastError)
+		}
+		if attempt == 3 {
+			if got.Status != model.NOTIFICATION_FAILED {
+				t.Fatalf("status after max attempts = %s, want FAILED", got.Status)
+			}
+			break
+		}
+		if got.Status != model.NOTIFICATION_PENDING {
+			t.Fatalf("attempt %d: status = %s, want PENDING", attempt, got.Status)
+		}
+		// Chờ backoff: Chưa đến hạn thì lượt sau không lấy
+		if wait := got.NextAttemptAt.Sub(before); wait < retryBackoff(attempt, notificationRetryBase, notificationRetryMax) {
+			t.Fatalf("attempt %d: next attempt in %v, want >= %v", attempt, wait, retryBackoff(attempt, notificationRetryBase, notificationRetryMax))
+		}
+		if sent, _ := s.RunOnce(context.Background()); sent != 0 || reloadNotification(t, db, n.ID).Attempts != attempt {
+			t.Fatalf("attempt %d: retried before backoff", attempt)
+		}
+		if err := db.Model(&model.Notification{}).Where("id = ?", n.ID).Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// FAILED -> Không gửi nữa
+	if err := db.Model(&model.Notification{}).Where("id = ?", n.ID).Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := s.RunOnce(context.Background()); sent != 0 || reloadNotification(t, db, n.ID).Attempts != 3 {
+		t.Errorf("FAILED notification was retried")
+	}
+}
+
+func TestRunOnceRecoversAfterTransientFailure(t *testing.T) {
+	db := newNotificationTestDB(t)
+	mailer := &fakeMailer{failures: 1}
+	s := newTestNotificationService(db, mailer, 3)
+	n := queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "1")
+
+	if sent, _ := s.RunOnce(context.Background()); sent != 0 {
+		t.Fatalf("first RunOnce sent %d, want 0", sent)
+	}
+	if err := db.Model(&model.Notification{}).Where("id = ?", n.ID).Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := s.RunOnce(context.Background()); sent != 1 {
+		t.Fatalf("retry sent %d, want 1", sent)
+	}
+	got := reloadNotification(t, db, n.ID)
+	if got.Status != model.NOTIFICATION_SENT || got.Attempts != 2 || got.LastError != "" {
+		t.Errorf("status = %s, attempts = %d, last_error = %q", got.Status, got.Attempts, got.LastError)
+	}
+}
+
+func TestRunOnceSkipsByPreference(t *testing.T) {
+	cases := []struct {
+		name string
+		pref model.NotificationPreference
+	}{
+		{"email disabled", model.NotificationPreference{UserID: "1", EmailEnabled: false, MutedEvents: []string{}}},
+		{"event muted", model.NotificationPreference{UserID: "1", EmailEnabled: true, MutedEvents: []string{model.EVENT_TASK_ASSIGNED}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newNotificationTestDB(t)
+			mailer := &fakeMailer{}
+			s := newTestNotificationService(db, mailer, 3)
+			pref := tc.pref
+			if err := s.repo.SavePreference(context.Background(), &pref); err != nil {
+				t.Fatal(err)
+			}
+			n := queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "1")
+
+			if sent, err := s.RunOnce(context.Background()); err != nil || sent != 0 {
+				t.Fatalf("RunOnce = %d, %v; want 0, nil", sent, err)
+			}
+			if len(mailer.sent) != 0 {
+				t.Fatalf("email sent despite preference: %+v", mailer.sent)
+			}
+			got := reloadNotification(t, db, n.ID)
+			if got.Status != model.NOTIFICATION_SKIPPED || got.LastError != "disabled by user preference" {
+				t.Errorf("status = %s, last_error = %q", got.Status, got.LastError)
+			}
+		})
+	}
+
+	// Tắt 1 sự kiện không chặn sự kiện khác
+	db := newNotificationTestDB(t)
+	mailer := &fakeMailer{}
+	s := newTestNotificationService(db, mailer, 3)
+	if err := s.repo.SavePreference(context.Background(), &model.NotificationPreference{UserID: "1", EmailEnabled: true, MutedEvents: []string{model.EVENT_TASK_OVERDUE}}); err != nil {
+		t.Fatal(err)
+	}
+	queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "1")
+	if sent, _ := s.RunOnce(context.Background()); sent != 1 {
+		t.Errorf("unmuted event was not sent")
+	}
+}
+
+func TestRunOnceSkipsUnknownRecipient(t *testing.T) {
+	db := newNotificationTestDB(t)
+	if err := db.Create(&model.User{ID: 2, UserCode: "U2", FullName: "User 2", IsActive: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	mailer := &fakeMailer{}
+	s := newTestNotificationService(db, mailer, 3)
+	system := queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "SYSTEM")
+	noEmail := queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "2")
+
+	if sent, _ := s.RunOnce(context.Background()); sent != 0 || len(mailer.sent) != 0 {
+		t.Fatalf("sent = %d", sent)
+	}
+	if got := reloadNotification(t, db, system.ID); got.Status != model.NOTIFICATION_SKIPPED {
+		t.Errorf("SYSTEM recipient: status = %s", got.Status)
+	}
+	if got := reloadNotification(t, db, noEmail.ID); got.Status != model.NOTIFICATION_SKIPPED {
+		t.Errorf("recipient without email: status = %s", got.Status)
+	}
+}
+
+func TestRunOnceRendersRecipientLanguage(t *testing.T) {
+	cases := []struct {
+		lang, subject, department, footer string
+	}{
+		{model.LANG_VI, "[EFNET] Đơn DOC-001 chờ bạn xử lý", "Phòng ban: Phòng Chất lượng", "Xem chi tiết:"},
+		{model.LANG_EN, "[EFNET] Request DOC-001 is waiting for you", "Department: Quality", "View details:"},
+		{model.LANG_TW, "DOC-001", "品質部", "查看詳情："},
+	}
+	for _, tc := range cases {
+		t.Run(tc.lang, func(t *testing.T) {
+			db := newNotificationTestDB(t)
+			mailer := &fakeMailer{}
+			s := newTestNotificationService(db, mailer, 3)
+			if err := s.repo.SavePreference(context.Background(), &model.NotificationPreference{UserID: "1", Language: tc.lang, EmailEnabled: true, MutedEvents: []string{}}); err != nil {
+				t.Fatal(err)
+			}
+			queueNotification(t, db, model.EVENT_TASK_ASSIGNED, "1")
+
+			if sent, err := s.RunOnce(context.Background()); err != nil || sent != 1 {
+				t.Fatalf("RunOnce = %d, %v", sent, err)
+			}
+			m := mailer.sent[0]
+			if !strings.Contains(m.subject, tc.subject) {
+				t.Errorf("subject = %q, want %q", m.subject, tc.subject)
+			}
+			if !strings.Contains(m.body, tc.department) || !strings.Contains(m.body, tc.footer) {
+				t.Errorf("body missing %q / %q:\n%s", tc.department, tc.footer, m.body)
+			}
+		})
+	}
+
+	// Mọi sự kiện đều có template cho cả 3 ngôn ngữ
+	for _, lang := range []string{model.LANG_VI, model.LANG_EN, model.LANG_TW} {
+		for _, event := range model.NOTIFICATION_EVENTS {
+			if _, ok := notificationTemplates[lang][event]; !ok {
+				t.Errorf("missing %s template for %s", lang, event)
+			}
+		}
+	}
+}
+
+// SMTP server tối thiểu trong tiến trình: Nhận 1 email rồi đóng
+type smtpCapture struct {
+	from, rcpt string
+	data       string
+}
+
+func startSMTPServer(t *testing.T) (string, int, <-chan smtpCapture) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	out := make(chan smtpCapture, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		tp := textproto.NewConn(conn)
+
+		var got smtpCapture
+		tp.PrintfLine("220 localhost ESMTP test")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				tp.PrintfLine("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				got.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+				tp.PrintfLine("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				got.rcpt = strings.Trim(line[len("RCPT TO:"):], "<> ")
+				tp.PrintfLine("250 OK")
+			case cmd == "DATA":
+				tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := io.ReadAll(tp.DotReader())
+				if err != nil {
+					return
+				}
+				got.data = string(data)
+				tp.PrintfLine("250 OK")
+			case cmd == "QUIT":
+				tp.PrintfLine("221 Bye")
+				out <- got
+				return
+			default:
+				tp.PrintfLine("502 Command not implemented")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, out
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	host, port, received := startSMTPServer(t)
+	mailer := NewSMTPMailer(config.SMTPConfig{Host: host, Port: port, From: "workflow@example.com", FromName: "EFNET Workflow"})
+
+	subject := "[EFNET] Đơn DOC-001 chờ bạn xử lý"
+	body := strings.Repeat("Nội dung tiếng Việt 中文內容 ", 10)
+	if err := mailer.Send("u1@example.com", subject, body); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var got smtpCapture
+	select {
+	case got = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("smtp server received nothing")
+	}
+	if got.from != "workflow@example.com" || got.rcpt != "u1@example.com" {
+		t.Errorf("envelope from = %q, rcpt = %q", got.from, got.rcpt)
+	}
+
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(got.data)))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	var dec mime.WordDecoder
+	if s, err := dec.DecodeHeader(msg.Header.Get("Subject")); err != nil || s != subject {
+		t.Errorf("subject = %q, %v; want %q", s, err, subject)
+	}
+	if ct := msg.Header.Get("Content-Type"); ct != "text/plain; charset=UTF-8" {
+		t.Errorf("content-type = %q", ct)
+	}
+	raw, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DotReader đã đổi CRLF -> LF
+	for _, line := range strings.Split(strings.TrimRight(string(raw), "\n"), "\n") {
+		if len(line) > 76 {
+			t.Errorf("base64 line longer than 76 chars: %d", len(line))
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\n", ""))
+	if err != nil || string(decoded) != body {
+		t.Errorf("body = %q, %v; want %q", decoded, err, body)
+	}
+}
+
+func TestSMTPMailerRejectsBadInput(t *testing.T) {
+	if err := NewSMTPMailer(config.SMTPConfig{}).Send("u1@example.com", "s", "b"); err == nil {
+		t.Error("send without smtp host succeeded")
+	}
+	mailer := NewSMTPMailer(config.SMTPConfig{Host: "127.0.0.1", Port: 1, From: "workflow@example.com"})
+	if err := mailer.Send("u1@example.com\r\nBcc: x@example.com", "s", "b"); err == nil {
+		t.Error("recipient with header injection accepted")
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Thời gian giữ bản ghi hàng đợi (email, webhook) đang gửi, quá thời gian này (instance bị tắt giữa chừng) thì lượt sau gửi lại
+const outboxLease = 5 * time.Minute
+
+// Chạy worker gửi hàng đợi ngay rồi lặp theo interval cho đến khi ctx bị hủy (Graceful Shutdown)
+func runOutbox(ctx context.Context, interval time.Duration, tag, unit string, runOnce func(ctx context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := runOnce(ctx); err != nil {
+			fmt.Printf("[%s] send failed: %v\n", tag, err)
+		} else if n > 0 {
+			fmt.Printf("[%s] sent %d %s(s)\n", tag, n, unit)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Thời gian chờ trước lần gửi lại thứ attempts+1: base, 2*base, 4*base... tối đa max
+func retryBackoff(attempts int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Vé chỉ dùng để mở kết nối, hết hạn nhanh (kết nối đã mở không bị ngắt khi vé hết hạn)
+const eventTicketTTL = time.Minute
+
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+type (
+	realtimeService struct {
+		hub            EventHub
+		groupRepo      repository.GroupRepo
+		delegationRepo repository.DelegationRepo
+		secret         []byte
+	}
+	// Đăng ký nhận sự kiện Inbox realtime (Server-Sent Events)
+	RealtimeService interface {
+		IssueTicket(ctx context.Context, userID, streamPath string) (*dto.EventTicketRes, error)
+		// Kiểm tra vé và đăng ký nhận sự kiện của User (task của User, nhóm, người ủy quyền và đơn User tạo)
+		Subscribe(ctx context.Context, ticket string) (<-chan model.WorkflowEvent, func(), error)
+	}
+)
+
+func NewRealtimeService(hub EventHub, groupRepo repository.GroupRepo, delegationRepo repository.DelegationRepo, secret string) RealtimeService {
+	return &realtimeService{
+		hub:            hub,
+		groupRepo:      groupRepo,
+		delegationRepo: delegationRepo,
+		secret:         []byte(secret),
+	}
+}
+
+func (s *realtimeService) IssueTicket(ctx context.Context, userID, streamPath string) (*dto.EventTicketRes, error) {
+	if userID == "" {
+		return nil, errors.New("user id is required")
+	}
+	expiresAt := time.Now().Add(eventTicketTTL)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d", userID, expiresAt.Unix())))
+	ticket := payload + "." + s.sign(payload)
+	return &dto.EventTicketRes{
+		Ticket:    ticket,
+		ExpiresAt: expiresAt,
+		StreamURL: streamPath + "?ticket=" + url.QueryEscape(ticket),
+	}, nil
+}
+
+func (s *realtimeService) Subscribe(ctx context.Context, ticket string) (<-chan model.WorkflowEvent, func(), error) {
+	userID, err := s.verifyTicket(ticket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups, err := s.groupRepo.GetGroupsByUserID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Task của người ủy quyền cũng hiện trong Inbox của người duyệt thay
+	delegations, err := s.delegationRepo.GetActiveForDelegate(ctx, userID, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	var assignees []string
+	for _, d := range delegations {
+		assignees = append(assignees, d.DelegatorID)
+		delegatorGroups, err := s.groupRepo.GetGroupsByUserID(ctx, d.DelegatorID)
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, delegatorGroups...)
+	}
+
+	ch, cancel := s.hub.Subscribe(userID, assignees, groups)
+	return ch, cancel, nil
+}
+
+func (s *realtimeService) verifyTicket(ticket string) (string, error) {
+	payload, sig, ok := strings.Cut(ticket, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return "", ErrInvalidTicket
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidTicket
+	}
+	userID, exp, ok := strings.Cut(string(raw), "|")
+	if !ok || userID == "" {
+		return "", ErrInvalidTicket
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expUnix {
+		return "", ErrInvalidTicket
+	}
+	return userID, nil
+}
+
+func (s *realtimeService) sign(payload string) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte("event-ticket|" + payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
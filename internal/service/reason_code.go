@@ -0,0 +1,200 @@
+package service
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	reasonCodeService struct {
+		repo repository.ReasonCodeRepo
+	}
+	ReasonCodeService interface {
+		Create(ctx context.Context, req dto.ReasonCodeCreate) error
+		GetByID(ctx context.Context, id uint64) (*dto.ReasonCodeResponse, error)
+		Update(ctx context.Context, id uint64, req dto.ReasonCodeUpdate) error
+		Delete(ctx context.Context, id uint64) error
+		GetList(ctx context.Context, serviceCode string) ([]*dto.ReasonCodeResponse, error)
+		Report(ctx context.Context, serviceCode string, from, to time.Time) (*dto.ReasonReportRes, error)
+	}
+)
+
+func NewReasonCodeService(repo repository.ReasonCodeRepo) ReasonCodeService {
+	return &reasonCodeService{repo: repo}
+}
+
+func (s *reasonCodeService) Create(ctx context.Context, req dto.ReasonCodeCreate) error {
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if code == "" {
+		return errors.New("code is required")
+	}
+	if strings.TrimSpace(req.Label) == "" {
+		return errors.New("label is required")
+	}
+	actions, err := normalizeReasonActions(req.Actions)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.repo.GetList(ctx, req.ServiceCode)
+	if err != nil {
+		return err
+	}
+	for _, r := range existing {
+		if r.ServiceCode == req.ServiceCode && r.Code == code {
+			return fmt.Errorf("reason code %s already exists", code)
+		}
+	}
+
+	reason := &model.ReasonCode{
+		ServiceCode: req.ServiceCode,
+		Code:        code,
+		Label:       req.Label,
+		Actions:     actions,
+		SortOrder:   req.SortOrder,
+		IsActive:    true,
+	}
+	return s.repo.Create(ctx, reason)
+}
+
+func (s *reasonCodeService) GetByID(ctx context.Context, id uint64) (*dto.ReasonCodeResponse, error) {
+	reason, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toReasonCodeResponse(reason), nil
+}
+
+func (s *reasonCodeService) Update(ctx context.Context, id uint64, req dto.ReasonCodeUpdate) error {
+	updateData := make(map[string]interface{})
+	if req.Label != nil {
+		if strings.TrimSpace(*req.Label) == "" {
+			return errors.New("label cannot be empty")
+		}
+		updateData["label"] = *req.Label
+	}
+	if req.Actions != nil {
+		actions, err := normalizeReasonActions(req.Actions)
+		if err != nil {
+			return err
+		}
+		// Updates(map) không qua serializer -> Lưu sẵn JSON
+		raw, err := json.Marshal(actions)
+		if err != nil {
+			return err
+		}
+		updateData["actions"] = string(raw)
+	}
+	if req.SortOrder != nil {
+		updateData["sort_order"] = *req.SortOrder
+	}
+	if req.IsActive != nil {
+		updateData["is_active"] = *req.IsActive
+	}
+	if len(updateData) == 0 {
+		return fmt.Errorf("no data to update")
+	}
+	if _, err := s.repo.GetByID(ctx, id); err != nil {
+		return err
+	}
+	return s.repo.Update(ctx, id, updateData)
+}
+
+// Xóa lý do không ảnh hưởng lịch sử (WorkflowLog lưu Code). Muốn ngừng dùng nên tắt IsActive.
+func (s *reasonCodeService) Delete(ctx context.Context, id uint64) error {
+	return s.repo.Delete(ctx, id)
+}
+
+func (s *reasonCodeService) GetList(ctx context.Context, serviceCode string) ([]*dto.ReasonCodeResponse, error) {
+	reasons, err := s.repo.GetList(ctx, serviceCode)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*dto.ReasonCodeResponse, 0, len(reasons))
+	for i := range reasons {
+		res = append(res, toReasonCodeResponse(&reasons[i]))
+	}
+	return res, nil
+}
+
+// Báo cáo Từ chối/Trả về theo lý do trong khoảng [from, to)
+func (s *reasonCodeService) Report(ctx context.Context, serviceCode string, from, to time.Time) (*dto.ReasonReportRes, error) {
+	if !from.Before(to) {
+		return nil, errors.New("from must be before to")
+	}
+	rows, err := s.repo.CountByReason(ctx, serviceCode, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Label: Ưu tiên lý do riêng của quy trình, sau đó lý do dùng chung
+	reasons, err := s.repo.GetList(ctx, serviceCode)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(reasons))
+	for _, r := range reasons {
+		if _, ok := labels[r.Code]; !ok || r.ServiceCode != "" {
+			labels[r.Code] = r.Label
+		}
+	}
+
+	res := &dto.ReasonReportRes{
+		ServiceCode: serviceCode,
+		From:        from,
+		To:          to,
+		Items:       make([]dto.ReasonReportRow, 0, len(rows)),
+	}
+	for _, row := range rows {
+		res.Items = append(res.Items, dto.ReasonReportRow{
+			Action:     row.Action,
+			ReasonCode: row.ReasonCode,
+			Label:      labels[row.ReasonCode],
+			Total:      row.Total,
+		})
+	}
+	return res, nil
+}
+
+func toReasonCodeResponse(r *model.ReasonCode) *dto.ReasonCodeResponse {
+	return &dto.ReasonCodeResponse{
+		ID:          r.ID,
+		ServiceCode: r.ServiceCode,
+		Code:        r.Code,
+		Label:       r.Label,
+		Actions:     r.Actions,
+		SortOrder:   r.SortOrder,
+		IsActive:    r.IsActive,
+	}
+}
+
+// Actions chỉ nhận REJECT/RETURN, bỏ trùng
+func normalizeReasonActions(in []string) ([]string, error) {
+	res := make([]string, 0, len(in))
+	seen := make(map[string]bool, len(in))
+	for _, a := range in {
+		a = strings.ToUpper(strings.TrimSpace(a))
+		valid := false
+		for _, allowed := range model.REASON_ACTIONS {
+			if a == allowed {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid action %q, allowed: %s", a, strings.Join(model.REASON_ACTIONS, ", "))
+		}
+		if !seen[a] {
+			seen[a] = true
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"CQS-KYC/internal/repository"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Thời gian giữ Idempotency-Key để trả lại kết quả cho request gửi lại
+const idempotencyKeyRetention = 24 * time.Hour
+
+type (
+	slaService struct {
+		repo         repository.InstanceRepo
+		overdue      bool // sla.enabled: Xử lý task quá hạn
+		interval     time.Duration
+		claimTimeout time.Duration // 0 = Không tự trả task đã nhận
+	}
+	// Scheduler chạy nền: phát hiện task quá hạn -> leo thang / tự duyệt / tự từ chối (sla.enabled),
+	// trả lại nhóm các task nhận quá lâu chưa xử lý, dọn Idempotency-Key hết hạn (luôn chạy)
+	SLAService interface {
+		Start(ctx context.Context)
+		RunOnce(ctx context.Context) (int, error)
+	}
+)
+
+func NewSLAService(repo repository.InstanceRepo, overdue bool, interval, claimTimeout time.Duration) SLAService {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &slaService{
+		repo:         repo,
+		overdue:      overdue,
+		interval:     interval,
+		claimTimeout: claimTimeout,
+	}
+}
+
+// Chạy cho đến khi ctx bị hủy (Graceful Shutdown)
+func (s *slaService) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.RunOnce(ctx); err != nil {
+			fmt.Printf("[SLA] scan failed: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("[SLA] handled %d overdue task(s)\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Quét 1 lần, trả về số task quá hạn đã xử lý
+func (s *slaService) RunOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	if s.claimTimeout > 0 {
+		if n, err := s.repo.ReleaseExpiredClaims(ctx, now.Add(-s.claimTimeout)); err != nil {
+			fmt.Printf("[SLA] release claims failed: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("[SLA] released %d expired claim(s)\n", n)
+		}
+	}
+
+	if _, err := s.repo.PurgeIdempotencyKeys(ctx, now.Add(-idempotencyKeyRetention)); err != nil {
+		fmt.Printf("[SLA] purge idempotency keys failed: %v\n", err)
+	}
+
+	if !s.overdue {
+		return 0, nil
+	}
+	tasks, err := s.repo.GetOverdueTasks(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	handled := 0
+	for _, t := range tasks {
+		// Lỗi 1 task không làm dừng cả lượt quét
+		if err := s.repo.HandleOverdueTask(ctx, t.InstanceID, t.ID, now); err != nil {
+			fmt.Printf("[SLA] task %d: %v\n", t.ID, err)
+			continue
+		}
+		handled++
+	}
+	return handled, nil
+}
@@ -63,7 +63,7 @@ type ExtractedData struct {
 // =============================================================================
 // 1. MAIN FLOW: NHẬN REQUEST -> BÓC TÁCH -> CHẠY ENGINE -> TRẢ LỜI ERP
 // =============================================================================
-func (s *ERPService) ProcessSOAPRequest(xmlBody []byte) error {
+func (s *ERPService) ProcessSOAPRequest(xmlBody []byte, client model.ClientInfo) error {
 	// 1. Bóc tách dữ liệu
 	data, err := s.processAndExtract(xmlBody)
 	if err != nil {
@@ -74,7 +74,7 @@ func (s *ERPService) ProcessSOAPRequest(xmlBody []byte) error {
 	fmt.Printf("[RECEIVED] %s | Type: %s | Num: %s | User: %s\n", data.CompanyId, data.DocType, data.DocNum, data.UserID)
 
 	// 2. Kích hoạt Workflow Engine
-	if err := s.routeAndInitiateWorkflow(data); err != nil {
+	if err := s.routeAndInitiateWorkflow(data, client); err != nil {
 		fmt.Printf("[WORKFLOW FAIL] %v\n", err)
 		// Có thể return err để ERP biết lỗi, hoặc return nil và log lại tùy nghiệp vụ
 		return err
@@ -95,14 +95,15 @@ func (s *ERPService) ProcessSOAPRequest(xmlBody []byte) error {
 // =============================================================================
 // 2. CORE LOGIC: ROUTING & INITIATION
 // =============================================================================
-func (s *ERPService) routeAndInitiateWorkflow(data *ExtractedData) error {
-	ctx := context.Background()
+func (s *ERPService) routeAndInitiateWorkflow(data *ExtractedData, client model.ClientInfo) error {
+	// Sự kiện của Engine (tạo đơn, giao task) chỉ phát khi Transaction commit
+	ctx, done := s.workflowEngine.CollectEvents(context.Background())
 	jsonBytes, err := json.Marshal(data.RawData)
 	if err != nil {
 		return fmt.Errorf("marshal json failed: %w", err)
 	}
 
-	return s.db.DB().Transaction(func(tx *gorm.DB) error {
+	err = s.db.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// ---------------------------------------------------------
 		// BƯỚC 1: KIỂM TRA & KHÓA BẢN GHI (BLOCKING DUPLICATE)
 		// ---------------------------------------------------------
@@ -188,8 +189,7 @@ func (s *ERPService) routeAndInitiateWorkflow(data *ExtractedData) error {
 			factoryID,
 			deptID,
 			jsonBytes,
-			"ERP_SOAP",
-			"ERP_SYSTEM",
+			client,
 		)
 		if err != nil {
 			return fmt.Errorf("engine initiate failed: %w", err)
@@ -217,6 +217,8 @@ func (s *ERPService) routeAndInitiateWorkflow(data *ExtractedData) error {
 
 		return nil
 	})
+	done(err)
+	return err
 }
 
 // =============================================================================
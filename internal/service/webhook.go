@@ -0,0 +1,585 @@
+package service
+
+import (
+	"CQS-KYC/config"
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"CQS-KYC/internal/repository"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// Thử lại sau 30s, 1m, 2m... tối đa 6 giờ
+	webhookRetryBase = 30 * time.Second
+	webhookRetryMax  = 6 * time.Hour
+	// Khóa ký tối thiểu khi người dùng tự đặt
+	minWebhookSecretLength = 16
+)
+
+// Header gửi kèm mỗi webhook. Bên nhận kiểm tra chữ ký:
+// HMAC-SHA256(secret, "<X-Webhook-Timestamp>.<body>") == X-Webhook-Signature (bỏ tiền tố "sha256=")
+const (
+	HeaderWebhookID        = "X-Webhook-Id" // EventID, giống nhau khi gửi lại -> Chống trùng
+	HeaderWebhookDelivery  = "X-Webhook-Delivery"
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+type (
+	webhookService struct {
+		repo        repository.WebhookRepo
+		userRepo    repository.UserRepo
+		client      *http.Client
+		interval    time.Duration
+		batchSize   int
+		maxAttempts int
+	}
+	// Quản lý Webhook Subscription, lịch sử gửi và gửi các Delivery trong hàng đợi do Engine ghi.
+	// Các API quản lý chỉ dành cho Admin (userID = người gọi)
+	WebhookService interface {
+		Start(ctx context.Context)
+		RunOnce(ctx context.Context) (int, error)
+
+		Create(ctx context.Context, userID string, req dto.WebhookCreateReq) (*dto.WebhookRes, error)
+		GetByID(ctx context.Context, userID string, id uint64) (*dto.WebhookRes, error)
+		GetList(ctx context.Context, userID string) ([]*dto.WebhookRes, error)
+		Update(ctx context.Context, userID string, id uint64, req dto.WebhookUpdateReq) (*dto.WebhookRes, error)
+		Delete(ctx context.Context, userID string, id uint64) error
+
+		ListDeliveries(ctx context.Context, userID string, subscriptionID uint64, req dto.WebhookDeliveryListReq) (*dto.WebhookDeliveryListRes, error)
+		GetDelivery(ctx context.Context, userID string, id uint64) (*dto.WebhookDeliveryRes, error)
+		Redeliver(ctx context.Context, userID string, id uint64) (*dto.WebhookDeliveryRes, error)
+	}
+)
+
+func NewWebhookService(repo repository.WebhookRepo, userRepo repository.UserRepo, cfg *config.Config) WebhookService {
+	return &webhookService{
+		repo:        repo,
+		userRepo:    userRepo,
+		client:      newWebhookClient(cfg.GetWebhookTimeout()),
+		interval:    cfg.GetWebhookInterval(),
+		batchSize:   cfg.GetWebhookBatchSize(),
+		maxAttempts: cfg.GetWebhookMaxAttempts(),
+	}
+}
+
+// Chạy cho đến khi ctx bị hủy (Graceful Shutdown)
+func (s *webhookService) Start(ctx context.Context) {
+	runOutbox(ctx, s.interval, "WEBHOOK", "webhook", s.RunOnce)
+}
+
+// Gửi 1 lượt, trả về số webhook gửi thành công
+func (s *webhookService) RunOnce(ctx context.Context) (int, error) {
+	items, err := s.repo.ClaimDue(ctx, time.Now(), s.batchSize, outboxLease)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for i := range items {
+		if ctx.Err() != nil {
+			break // Đang tắt: Delivery còn lại hết lease sẽ được gửi lại
+		}
+		d := &items[i]
+		s.deliver(ctx, d)
+		if err := s.repo.SaveAttempt(ctx, d); err != nil {
+			fmt.Printf("[WEBHOOK] delivery %d: %v\n", d.ID, err)
+			continue
+		}
+		if d.Status == model.WEBHOOK_SUCCESS {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// Gửi 1 Delivery và ghi kết quả vào d. 2xx -> SUCCESS, còn lại thử lại với backoff, hết lượt -> FAILED
+func (s *webhookService) deliver(ctx context.Context, d *model.WebhookDelivery) {
+	sub := d.Subscription
+	if sub == nil || !sub.IsActive {
+		d.Status = model.WEBHOOK_CANCELLED
+		d.LastError = "subscription is disabled or deleted"
+		return
+	}
+
+	d.Attempts++
+	d.ResponseStatus = 0
+	d.ResponseBody = ""
+	d.LastError = ""
+
+	start := time.Now()
+	status, body, err := s.post(ctx, sub, d)
+	d.DurationMs = time.Since(start).Milliseconds()
+	d.ResponseStatus = status
+	d.ResponseBody = body
+
+	if err == nil && status >= 200 && status < 300 {
+		now := time.Now()
+		d.Status = model.WEBHOOK_SUCCESS
+		d.DeliveredAt = &now
+		return
+	}
+	if err != nil {
+		d.LastError = err.Error()
+	} else {
+		d.LastError = fmt.Sprintf("unexpected response status %d", status)
+	}
+	if d.Attempts >= s.maxAttempts {
+		d.Status = model.WEBHOOK_FAILED
+		return
+	}
+	d.Status = model.WEBHOOK_PENDING
+	d.NextAttemptAt = time.Now().Add(retryBackoff(d.Attempts, webhookRetryBase, webhookRetryMax))
+}
+
+func (s *webhookService) post(ctx context.Context, sub *model.WebhookSubscription, d *model.WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "EFNET-Workflow-Webhook/1.0")
+	req.Header.Set(HeaderWebhookID, d.EventID)
+	req.Header.Set(HeaderWebhookDelivery, strconv.FormatUint(d.ID, 10))
+	req.Header.Set(HeaderWebhookEvent, d.Event)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, "sha256="+signWebhook(sub.Secret, timestamp, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, string(body), nil
+}
+
+func signWebhook(secret, timestamp, payload string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// =============================================================================
+// SUBSCRIPTION
+// =============================================================================
+
+func (s *webhookService) Create(ctx context.Context, userID string, req dto.WebhookCreateReq) (*dto.WebhookRes, error) {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if err := validateWebhookURL(ctx, req.URL); err != nil {
+		return nil, err
+	}
+	events, err := normalizeWebhookEvents(req.Events)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := webhookSecret(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &model.WebhookSubscription{
+		Name:         name,
+		URL:          strings.TrimSpace(req.URL),
+		Secret:       secret,
+		Events:       events,
+		ServiceCodes: normalizeServiceCodes(req.ServiceCodes),
+		IsActive:     req.IsActive == nil || *req.IsActive,
+		CreatedBy:    userID,
+	}
+	if err := s.repo.CreateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	res := toWebhookRes(sub)
+	res.Secret = secret // Chỉ trả về 1 lần
+	return res, nil
+}
+
+func (s *webhookService) GetByID(ctx context.Context, userID string, id uint64) (*dto.WebhookRes, error) {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return nil, err
+	}
+	sub, err := s.repo.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toWebhookRes(sub), nil
+}
+
+func (s *webhookService) GetList(ctx context.Context, userID string) ([]*dto.WebhookRes, error) {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return nil, err
+	}
+	subs, err := s.repo.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*dto.WebhookRes, 0, len(subs))
+	for i := range subs {
+		res = append(res, toWebhookRes(&subs[i]))
+	}
+	return res, nil
+}
+
+func (s *webhookService) Update(ctx context.Context, userID string, id uint64, req dto.WebhookUpdateReq) (*dto.WebhookRes, error) {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.GetSubscription(ctx, id); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return nil, errors.New("name is required")
+		}
+		updates["name"] = name
+	}
+	if req.URL != nil {
+		if err := validateWebhookURL(ctx, *req.URL); err != nil {
+			return nil, err
+		}
+		updates["url"] = strings.TrimSpace(*req.URL)
+	}
+	if req.Events != nil {
+		events, err := normalizeWebhookEvents(req.Events)
+		if err != nil {
+			return nil, err
+		}
+		// Cột JSON: Updates bằng map không qua serializer
+		data, _ := json.Marshal(events)
+		updates["events"] = string(data)
+	}
+	if req.ServiceCodes != nil {
+		data, _ := json.Marshal(normalizeServiceCodes(req.ServiceCodes))
+		updates["service_codes"] = string(data)
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+	var secret string
+	if req.Secret != nil {
+		var err error
+		if secret, err = webhookSecret(*req.Secret); err != nil {
+			return nil, err
+		}
+		updates["secret"] = secret
+	}
+
+	if len(updates) > 0 {
+		if err := s.repo.UpdateSubscription(ctx, id, updates); err != nil {
+			return nil, err
+		}
+	}
+	sub, err := s.repo.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	res := toWebhookRes(sub)
+	res.Secret = secret // Chỉ có khi vừa đổi khóa
+	return res, nil
+}
+
+func (s *webhookService) Delete(ctx context.Context, userID string, id uint64) error {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return err
+	}
+	if _, err := s.repo.GetSubscription(ctx, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteSubscription(ctx, id)
+}
+
+// =============================================================================
+// DELIVERY
+// =============================================================================
+
+func (s *webhookService) ListDeliveries(ctx context.Context, userID string, subscriptionID uint64, req dto.WebhookDeliveryListReq) (*dto.WebhookDeliveryListRes, error) {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	status := strings.ToUpper(strings.TrimSpace(req.Status))
+	switch status {
+	case "", model.WEBHOOK_PENDING, model.WEBHOOK_SUCCESS, model.WEBHOOK_FAILED, model.WEBHOOK_CANCELLED:
+	default:
+		return nil, fmt.Errorf("invalid status %s", req.Status)
+	}
+	var beforeID uint64
+	if req.Cursor != "" {
+		id, err := strconv.ParseUint(req.Cursor, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		beforeID = id
+	}
+
+	limit := pageLimit(req.Limit)
+	deliveries, err := s.repo.ListDeliveries(ctx, repository.WebhookDeliveryFilter{
+		SubscriptionID: subscriptionID,
+		InstanceID:     req.InstanceID,
+		Status:         status,
+		BeforeID:       beforeID,
+		Limit:          limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.WebhookDeliveryListRes{Items: make([]dto.WebhookDeliveryRes, 0, len(deliveries))}
+	for i := range deliveries {
+		res.Items = append(res.Items, toWebhookDeliveryRes(&deliveries[i], false))
+	}
+	if len(deliveries) == limit {
+		res.NextCursor = strconv.FormatUint(deliveries[len(deliveries)-1].ID, 10)
+	}
+	return res, nil
+}
+
+func (s *webhookService) GetDelivery(ctx context.Context, userID string, id uint64) (*dto.WebhookDeliveryRes, error) {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return nil, err
+	}
+	d, err := s.repo.GetDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	res := toWebhookDeliveryRes(d, true)
+	return &res, nil
+}
+
+// Gửi lại thủ công (kể cả Delivery đã thành công, VD: bên nhận bị mất dữ liệu)
+func (s *webhookService) Redeliver(ctx context.Context, userID string, id uint64) (*dto.WebhookDeliveryRes, error) {
+	if err := requireAdmin(ctx, s.userRepo, userID); err != nil {
+		return nil, err
+	}
+	orig, err := s.repo.GetDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := s.repo.GetSubscription(ctx, orig.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if !sub.IsActive {
+		return nil, fmt.Errorf("webhook %d is disabled, enable it before redelivering", sub.ID)
+	}
+
+	d, err := s.repo.Redeliver(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	res := toWebhookDeliveryRes(d, false)
+	return &res, nil
+}
+
+// =============================================================================
+// HELPERS
+// =============================================================================
+
+// URL phải là http(s) và trỏ ra mạng ngoài (chặn SSRF vào mạng nội bộ / metadata của máy chủ)
+func validateWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid url %q, expected http(s)://host/path", raw)
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("url host %s is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedWebhookIP(ip) {
+			return fmt.Errorf("url host %s is not allowed (private, loopback or link-local address)", host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve url host %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if blockedWebhookIP(addr.IP) {
+			return fmt.Errorf("url host %s resolves to a disallowed address %s", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// 100.64.0.0/10: Carrier-grade NAT (không nằm trong IsPrivate)
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Địa chỉ không được gửi webhook tới: Loopback, mạng nội bộ (RFC1918, fc00::/7), link-local (gồm 169.254.169.254), ...
+func blockedWebhookIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if ip4[0] == 0 || sharedAddressSpace.Contains(ip4) {
+			return true
+		}
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// Kiểm tra lại địa chỉ lúc kết nối (DNS có thể đổi sau khi lưu - DNS rebinding), không dùng proxy, không theo redirect
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || blockedWebhookIP(ip) {
+				return fmt.Errorf("webhook target %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // 3xx -> Coi như gửi lỗi
+		},
+	}
+}
+
+// Chuẩn hóa + kiểm tra danh sách sự kiện (trống = Tất cả)
+func normalizeWebhookEvents(events []string) ([]string, error) {
+	out := make([]string, 0, len(events))
+	seen := map[string]bool{}
+	for _, e := range events {
+		e = strings.ToUpper(strings.TrimSpace(e))
+		valid := false
+		for _, w := range model.WEBHOOK_EVENTS {
+			if w == e {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid event %s, expected one of: %s", e, strings.Join(model.WEBHOOK_EVENTS, ", "))
+		}
+		if !seen[e] {
+			seen[e] = true
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func normalizeServiceCodes(codes []string) []string {
+	out := make([]string, 0, len(codes))
+	for _, c := range codes {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Trống -> Sinh khóa ngẫu nhiên 32 byte
+func webhookSecret(secret string) (string, error) {
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	}
+	if len(secret) < minWebhookSecretLength {
+		return "", fmt.Errorf("secret must be at least %d characters", minWebhookSecretLength)
+	}
+	return secret, nil
+}
+
+func toWebhookRes(sub *model.WebhookSubscription) *dto.WebhookRes {
+	hint := ""
+	if len(sub.Secret) > 4 {
+		hint = "****" + sub.Secret[len(sub.Secret)-4:]
+	}
+	events := sub.Events
+	if events == nil {
+		events = []string{}
+	}
+	codes := sub.ServiceCodes
+	if codes == nil {
+		codes = []string{}
+	}
+	return &dto.WebhookRes{
+		ID:           sub.ID,
+		Name:         sub.Name,
+		URL:          sub.URL,
+		Events:       events,
+		ServiceCodes: codes,
+		IsActive:     sub.IsActive,
+		SecretHint:   hint,
+		CreatedBy:    sub.CreatedBy,
+		CreatedAt:    sub.CreatedAt,
+		UpdatedAt:    sub.UpdatedAt,
+	}
+}
+
+func toWebhookDeliveryRes(d *model.WebhookDelivery, withPayload bool) dto.WebhookDeliveryRes {
+	res := dto.WebhookDeliveryRes{
+		ID:             d.ID,
+		SubscriptionID: d.SubscriptionID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		InstanceID:     d.InstanceID,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		ResponseBody:   d.ResponseBody,
+		LastError:      d.LastError,
+		DurationMs:     d.DurationMs,
+		DeliveredAt:    d.DeliveredAt,
+		RedeliveryOf:   d.RedeliveryOf,
+		CreatedAt:      d.CreatedAt,
+	}
+	if d.Status == model.WEBHOOK_PENDING {
+		next := d.NextAttemptAt
+		res.NextAttemptAt = &next
+	}
+	if withPayload {
+		res.Payload = json.RawMessage(d.Payload)
+	}
+	return res
+}
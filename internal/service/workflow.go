@@ -5,12 +5,22 @@ import (
 	"CQS-KYC/internal/model"
 	"CQS-KYC/internal/repository"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type (
 	workflowService struct {
-		repo repository.WorkflowRepo
+		repo           repository.WorkflowRepo
+		instanceRepo   repository.InstanceRepo // Simulate (chạy thử engine, không ghi DB)
+		userRepo       repository.UserRepo
+		groupRepo      repository.GroupRepo
+		departmentRepo repository.DepartmentRepo
+		factoryRepo    repository.FactoryRepo
 	}
 	WorkflowService interface {
 		Create(ctx context.Context, req dto.WorkflowDefinitionCreate) error
@@ -19,12 +29,31 @@ type (
 		Delete(ctx context.Context, id uint64) error
 		GetByCode(ctx context.Context, code string) (*model.WorkflowDefinition, error)
 		GetAll(ctx context.Context) ([]dto.WorkflowDefinitionRes, error)
+		DryRun(ctx context.Context, id uint64, req dto.WorkflowDryRunReq) (*dto.WorkflowDryRunRes, error)
+		ListVersions(ctx context.Context, serviceCode string) ([]dto.WorkflowVersionRes, error)
+		Simulate(ctx context.Context, id uint64, req dto.WorkflowSimulateReq) ([]dto.WorkflowSimulateStepRes, error)
+
+		// Bundle: Chuyển cấu hình quy trình giữa các công ty (tham chiếu theo Code)
+		Export(ctx context.Context, id uint64, format string) ([]byte, string, error)
+		Import(ctx context.Context, data []byte, format string) (*dto.WorkflowImportRes, error)
 	}
 )
 
-func NewWorkflowService(repo repository.WorkflowRepo) WorkflowService {
+func NewWorkflowService(
+	repo repository.WorkflowRepo,
+	instanceRepo repository.InstanceRepo,
+	userRepo repository.UserRepo,
+	groupRepo repository.GroupRepo,
+	departmentRepo repository.DepartmentRepo,
+	factoryRepo repository.FactoryRepo,
+) WorkflowService {
 	return &workflowService{
-		repo: repo,
+		repo:           repo,
+		instanceRepo:   instanceRepo,
+		userRepo:       userRepo,
+		groupRepo:      groupRepo,
+		departmentRepo: departmentRepo,
+		factoryRepo:    factoryRepo,
 	}
 }
 
@@ -37,26 +66,33 @@ func (w *workflowService) Create(ctx context.Context, req dto.WorkflowDefinition
 
 	// Map DTO -> Model
 	wf := model.WorkflowDefinition{
-		ServiceCode:  req.ServiceCode,
-		Operation:    req.Operation,
-		WorkflowName: req.WorkflowName,
-		Description:  req.Description,
-		IsActive:     true, // Mặc định true khi tạo mới
-		Version:      1,    // Version đầu tiên
-		Steps:        make([]model.WorkflowStep, 0),
+		ServiceCode:     req.ServiceCode,
+		Operation:       req.Operation,
+		WorkflowName:    req.WorkflowName,
+		Description:     req.Description,
+		IsActive:        true, // Mặc định true khi tạo mới
+		Version:         1,    // Version đầu tiên
+		BaseServiceCode: req.ServiceCode,
+		Steps:           make([]model.WorkflowStep, 0),
 	}
 
 	for _, step := range req.Steps {
 		wfStep := model.WorkflowStep{
-			StepCode:       step.StepCode,
-			StepName:       step.StepName,
-			StepOrder:      step.StepOrder,
-			RequiredRole:   step.RequiredRole,
-			Canskip:        step.Canskip,
-			CanDelegate:    step.CanDelegate,
-			RequireComment: step.RequireComment,
-			TimeHours:      step.TimeHours,
-			Assignments:    make([]model.WorkflowStepAssignment, 0), // Sửa Assignments
+			StepCode:          step.StepCode,
+			StepName:          step.StepName,
+			StepOrder:         step.StepOrder,
+			StepType:          stepTypeOrDefault(step.StepType),
+			RequiredRole:      step.RequiredRole,
+			Canskip:           step.Canskip,
+			CanDelegate:       step.CanDelegate,
+			RequireComment:    step.RequireComment,
+			TimeHours:         step.TimeHours,
+			TimeoutAction:     timeoutActionOrDefault(step.TimeoutAction),
+			Condition:         toModelCondition(step.Condition),
+			CompletionPolicy:  completionPolicyOrDefault(step.CompletionPolicy),
+			RequiredApprovals: step.RequiredApprovals,
+			SubWorkflowCode:   step.SubWorkflowCode,
+			Assignments:       make([]model.WorkflowStepAssignment, 0), // Sửa Assignments
 		}
 
 		for _, assign := range step.Assisments { // DTO của em vẫn tên là Assisments (nếu chưa sửa DTO)
@@ -72,6 +108,10 @@ func (w *workflowService) Create(ctx context.Context, req dto.WorkflowDefinition
 		wf.Steps = append(wf.Steps, wfStep)
 	}
 
+	if err := w.validateDefinition(ctx, wf.ServiceCode, wf.Steps); err != nil {
+		return err
+	}
+
 	// Gọi Repo Create đơn giản
 	return w.repo.Create(ctx, &wf)
 }
@@ -86,6 +126,7 @@ func (w *workflowService) GetByID(ctx context.Context, id uint64) (*dto.Workflow
 		Operation:    wf.Operation,
 		WorkflowName: wf.WorkflowName,
 		Description:  wf.Description,
+		Version:      wf.Version,
 		IsActive:     wf.IsActive,
 		Steps:        make([]dto.WorkflowStepRes, 0),
 	}
@@ -96,11 +137,17 @@ func (w *workflowService) GetByID(ctx context.Context, id uint64) (*dto.Workflow
 			StepCode:             step.StepCode,
 			StepName:             step.StepName,
 			StepOrder:            step.StepOrder,
+			StepType:             stepTypeOrDefault(step.StepType),
 			RequiredRole:         step.RequiredRole,
 			Canskip:              step.Canskip,
 			CanDelegate:          step.CanDelegate,
 			RequireComment:       step.RequireComment,
 			TimeHours:            step.TimeHours,
+			TimeoutAction:        step.TimeoutAction,
+			Condition:            toDTOCondition(step.Condition),
+			CompletionPolicy:     step.CompletionPolicy,
+			RequiredApprovals:    step.RequiredApprovals,
+			SubWorkflowCode:      step.SubWorkflowCode,
 			Assisments:           make([]dto.WorkflowStepAssignmentRes, 0),
 		}
 		for _, assign := range step.Assignments {
@@ -129,15 +176,21 @@ func (w *workflowService) Update(ctx context.Context, id uint64, req dto.Workflo
 	}
 	for _, step := range req.Steps {
 		wfStep := model.WorkflowStep{
-			StepCode:       step.StepCode,
-			StepName:       step.StepName,
-			StepOrder:      step.StepOrder,
-			RequiredRole:   step.RequiredRole,
-			Canskip:        step.Canskip,
-			CanDelegate:    step.CanDelegate,
-			RequireComment: step.RequireComment,
-			TimeHours:      step.TimeHours,
-			Assignments:    make([]model.WorkflowStepAssignment, 0),
+			StepCode:          step.StepCode,
+			StepName:          step.StepName,
+			StepOrder:         step.StepOrder,
+			StepType:          stepTypeOrDefault(step.StepType),
+			RequiredRole:      step.RequiredRole,
+			Canskip:           step.Canskip,
+			CanDelegate:       step.CanDelegate,
+			RequireComment:    step.RequireComment,
+			TimeHours:         step.TimeHours,
+			TimeoutAction:     timeoutActionOrDefault(step.TimeoutAction),
+			Condition:         toModelCondition(step.Condition),
+			CompletionPolicy:  completionPolicyOrDefault(step.CompletionPolicy),
+			RequiredApprovals: step.RequiredApprovals,
+			SubWorkflowCode:   step.SubWorkflowCode,
+			Assignments:       make([]model.WorkflowStepAssignment, 0),
 		}
 		for _, assign := range step.Assisments {
 			wfAssign := model.WorkflowStepAssignment{
@@ -151,6 +204,13 @@ func (w *workflowService) Update(ctx context.Context, id uint64, req dto.Workflo
 		}
 		wf.Steps = append(wf.Steps, wfStep)
 	}
+	current, err := w.repo.FindByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get workflow definition by id %w", err)
+	}
+	if err := w.validateDefinition(ctx, baseCode(current), wf.Steps); err != nil {
+		return err
+	}
 	return w.repo.Update(ctx, id, wf)
 }
 func (w *workflowService) Delete(ctx context.Context, id uint64) error {
@@ -169,6 +229,7 @@ func (w *workflowService) GetAll(ctx context.Context) ([]dto.WorkflowDefinitionR
 			Operation:    item.Operation,
 			WorkflowName: item.WorkflowName,
 			Description:  item.Description,
+			Version:      item.Version,
 			IsActive:     item.IsActive,
 			Steps:        make([]dto.WorkflowStepRes, 0),
 		}
@@ -179,11 +240,17 @@ func (w *workflowService) GetAll(ctx context.Context) ([]dto.WorkflowDefinitionR
 				StepCode:             step.StepCode,
 				StepName:             step.StepName,
 				StepOrder:            step.StepOrder,
+				StepType:             stepTypeOrDefault(step.StepType),
 				RequiredRole:         step.RequiredRole,
 				Canskip:              step.Canskip,
 				CanDelegate:          step.CanDelegate,
 				RequireComment:       step.RequireComment,
 				TimeHours:            step.TimeHours,
+				TimeoutAction:        step.TimeoutAction,
+				Condition:            toDTOCondition(step.Condition),
+				CompletionPolicy:     step.CompletionPolicy,
+				RequiredApprovals:    step.RequiredApprovals,
+				SubWorkflowCode:      step.SubWorkflowCode,
 				Assisments:           make([]dto.WorkflowStepAssignmentRes, 0),
 			}
 			for _, assign := range step.Assignments {
@@ -205,6 +272,84 @@ func (w *workflowService) GetAll(ctx context.Context) ([]dto.WorkflowDefinitionR
 	return res, nil
 }
 
+// Chạy thử điều kiện của từng bước với RequestData mẫu (không tạo đơn)
+func (w *workflowService) DryRun(ctx context.Context, id uint64, req dto.WorkflowDryRunReq) (*dto.WorkflowDryRunRes, error) {
+	wf, err := w.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow definition by id %w", err)
+	}
+	data, err := json.Marshal(req.RequestData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request data: %w", err)
+	}
+
+	res := &dto.WorkflowDryRunRes{Steps: make([]dto.WorkflowDryRunStepRes, 0, len(wf.Steps))}
+	if req.Condition != nil {
+		matched, err := repository.EvaluateCondition(toModelCondition(req.Condition), data)
+		if err != nil {
+			return nil, err
+		}
+		res.ConditionMatched = &matched
+	}
+
+	steps := wf.Steps
+	sort.Slice(steps, func(i, j int) bool { return steps[i].StepOrder < steps[j].StepOrder })
+	for _, step := range steps {
+		stepRes := dto.WorkflowDryRunStepRes{
+			StepOrder: step.StepOrder,
+			StepCode:  step.StepCode,
+			StepName:  step.StepName,
+		}
+		applies, err := repository.EvaluateCondition(step.Condition, data)
+		if err != nil {
+			stepRes.Error = err.Error()
+		}
+		stepRes.Applies = applies
+		res.Steps = append(res.Steps, stepRes)
+	}
+	return res, nil
+}
+
+// Tất cả version của 1 ServiceCode, kèm số đơn đang chạy và thay đổi so với version trước
+func (w *workflowService) ListVersions(ctx context.Context, serviceCode string) ([]dto.WorkflowVersionRes, error) {
+	versions, err := w.repo.ListVersions(ctx, serviceCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workflow versions %w", err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("service code %s not found", serviceCode)
+	}
+
+	ids := make([]uint64, 0, len(versions))
+	for _, v := range versions {
+		ids = append(ids, v.ID)
+	}
+	counts, err := w.repo.CountInProgress(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.WorkflowVersionRes, 0, len(versions))
+	for i := range versions {
+		v := &versions[i]
+		item := dto.WorkflowVersionRes{
+			ID:           v.ID,
+			ServiceCode:  v.ServiceCode,
+			Version:      v.Version,
+			WorkflowName: v.WorkflowName,
+			IsActive:     v.IsActive,
+			CreatedAt:    v.CreatedAt,
+			StepCount:    len(v.Steps),
+			InProgress:   counts[v.ID],
+		}
+		if i > 0 {
+			item.Diff = diffWorkflowVersions(&versions[i-1], v)
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
 func (w *workflowService) GetByCode(ctx context.Context, code string) (*model.WorkflowDefinition, error) {
 	wf, err := w.repo.GetByCode(ctx, code)
 	if err != nil {
@@ -212,3 +357,440 @@ func (w *workflowService) GetByCode(ctx context.Context, code string) (*model.Wo
 	}
 	return wf, nil
 }
+
+// Chạy thử engine: Người tạo + RequestData mẫu -> Các bước và người duyệt thực tế
+func (w *workflowService) Simulate(ctx context.Context, id uint64, req dto.WorkflowSimulateReq) ([]dto.WorkflowSimulateStepRes, error) {
+	if req.CreatorID == 0 {
+		return nil, errors.New("creator_id is required")
+	}
+	creator, err := w.userRepo.GetByID(ctx, req.CreatorID)
+	if err != nil {
+		return nil, fmt.Errorf("creator %d not found: %w", req.CreatorID, err)
+	}
+	// Mặc định lấy Nhà máy/Phòng ban của người tạo (giống luồng ERP)
+	factoryID, deptID := creator.FactoryID, creator.DepartmentID
+	if req.FactoryID != 0 {
+		factoryID = req.FactoryID
+	}
+	if req.DepartmentID != 0 {
+		deptID = req.DepartmentID
+	}
+	data, err := json.Marshal(req.RequestData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request data: %w", err)
+	}
+
+	steps, err := w.instanceRepo.Simulate(ctx, id, strconv.FormatUint(creator.ID, 10), factoryID, deptID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.WorkflowSimulateStepRes, 0, len(steps))
+	for _, st := range steps {
+		item := dto.WorkflowSimulateStepRes{
+			StepOrder:         st.Step.StepOrder,
+			StepCode:          st.Step.StepCode,
+			StepName:          st.Step.StepName,
+			Result:            dto.SIMULATE_ACTIVE,
+			SkipReason:        st.SkipReason,
+			Error:             st.Error,
+			CompletionPolicy:  st.Step.CompletionPolicy,
+			RequiredApprovals: st.Step.RequiredApprovals,
+			StepType:          stepTypeOrDefault(st.Step.StepType),
+			SubWorkflowCode:   st.Step.SubWorkflowCode,
+			Approvers:         make([]dto.WorkflowSimulateApproverRes, 0, len(st.Tasks)),
+		}
+		switch {
+		case st.Error != "":
+			item.Result = dto.SIMULATE_ERROR
+		case st.SkipReason != "":
+			item.Result = dto.SIMULATE_SKIPPED
+		case st.Step.SubWorkflowCode != "":
+			item.Result = dto.SIMULATE_SUB_WORKFLOW
+		}
+		for _, t := range st.Tasks {
+			item.Approvers = append(item.Approvers, dto.WorkflowSimulateApproverRes{
+				ID:      t.AssignedTo,
+				IsGroup: t.IsGroup,
+				Name:    w.assigneeName(ctx, t.AssignedTo, t.IsGroup),
+				DueDate: t.DueDate,
+			})
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+// Tên hiển thị của người/nhóm được giao (bỏ trống nếu không tìm thấy)
+func (w *workflowService) assigneeName(ctx context.Context, id string, isGroup bool) string {
+	if isGroup {
+		if g, err := w.groupRepo.FindByCode(ctx, id); err == nil {
+			return g.GroupName
+		}
+		return ""
+	}
+	uid, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return ""
+	}
+	if u, err := w.userRepo.GetByID(ctx, uid); err == nil {
+		return u.FullName
+	}
+	return ""
+}
+
+// Kiểm tra toàn bộ định nghĩa trước khi lưu (tránh lỗi lúc chạy trong engine)
+func (w *workflowService) validateDefinition(ctx context.Context, serviceCode string, steps []model.WorkflowStep) error {
+	if len(steps) == 0 {
+		return errors.New("workflow must have at least one step")
+	}
+	if err := validateTimeoutActions(steps); err != nil {
+		return err
+	}
+	if err := validateConditions(steps); err != nil {
+		return err
+	}
+	if err := validateCompletionPolicies(steps); err != nil {
+		return err
+	}
+	if err := validateAssignmentTypes(steps); err != nil {
+		return err
+	}
+	if err := validateStepTypes(steps); err != nil {
+		return err
+	}
+
+	problems := make([]string, 0)
+	orders := make(map[int]string, len(steps))
+	codes := make(map[string]bool, len(steps))
+	checkedGroups := make(map[string]bool)
+	checkedUsers := make(map[string]bool)
+	checkedDepts := make(map[uint64]bool)
+
+	for _, step := range steps {
+		if step.StepCode == "" {
+			problems = append(problems, fmt.Sprintf("step order %d: step_code is required", step.StepOrder))
+		} else if codes[step.StepCode] {
+			problems = append(problems, fmt.Sprintf("duplicate step_code %s", step.StepCode))
+		}
+		codes[step.StepCode] = true
+
+		if step.StepOrder <= 0 {
+			problems = append(problems, fmt.Sprintf("step %s: step_order must be greater than 0", step.StepCode))
+		} else if other, ok := orders[step.StepOrder]; ok {
+			problems = append(problems, fmt.Sprintf("steps %s and %s have the same step_order %d", other, step.StepCode, step.StepOrder))
+		}
+		orders[step.StepOrder] = step.StepCode
+
+		// Bước Sub-workflow: Người duyệt nằm ở quy trình con, không cần rule gán
+		if step.SubWorkflowCode != "" {
+			if step.SubWorkflowCode == serviceCode {
+				problems = append(problems, fmt.Sprintf("step %s: sub-workflow cannot start its own workflow %s", step.StepCode, serviceCode))
+			} else if _, err := w.repo.FindByServiceCode(ctx, step.SubWorkflowCode); err != nil {
+				problems = append(problems, fmt.Sprintf("step %s: sub-workflow %s not found or inactive", step.StepCode, step.SubWorkflowCode))
+			}
+			if len(step.Assignments) > 0 {
+				problems = append(problems, fmt.Sprintf("step %s: sub-workflow step must not have assignments", step.StepCode))
+			}
+			continue
+		}
+
+		active := 0
+		for _, assign := range step.Assignments {
+			if !assign.IsActive {
+				continue
+			}
+			active++
+
+			switch assign.AssignedType {
+			case model.ASSIGN_USER:
+				if checkedUsers[assign.AssignedIdentity] {
+					break
+				}
+				checkedUsers[assign.AssignedIdentity] = true
+				uid, err := strconv.ParseUint(assign.AssignedIdentity, 10, 64)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("step %s: user id %q must be numeric", step.StepCode, assign.AssignedIdentity))
+					break
+				}
+				if u, err := w.userRepo.GetByID(ctx, uid); err != nil || !u.IsActive {
+					problems = append(problems, fmt.Sprintf("step %s: user %s not found or inactive", step.StepCode, assign.AssignedIdentity))
+				}
+			case model.ASSIGN_GROUP:
+				if checkedGroups[assign.AssignedIdentity] {
+					break
+				}
+				checkedGroups[assign.AssignedIdentity] = true
+				g, err := w.groupRepo.FindByCode(ctx, assign.AssignedIdentity)
+				if err != nil || !g.IsActive {
+					problems = append(problems, fmt.Sprintf("step %s: group %s not found or inactive", step.StepCode, assign.AssignedIdentity))
+				} else if len(g.Members) == 0 {
+					problems = append(problems, fmt.Sprintf("step %s: group %s has no members", step.StepCode, assign.AssignedIdentity))
+				}
+			case model.ASSIGN_MANAGER_LEVEL, model.ASSIGN_POSITION_LEVEL:
+				if n, err := strconv.Atoi(assign.AssignedIdentity); err != nil || n < 0 {
+					problems = append(problems, fmt.Sprintf("step %s: %s needs a non-negative number, got %q", step.StepCode, assign.AssignedType, assign.AssignedIdentity))
+				}
+			}
+
+			for _, deptID := range assign.DepartmentIDs {
+				if checkedDepts[deptID] {
+					continue
+				}
+				checkedDepts[deptID] = true
+				if _, err := w.departmentRepo.GetByID(ctx, deptID); err != nil {
+					problems = append(problems, fmt.Sprintf("step %s: department %d does not exist", step.StepCode, deptID))
+				}
+			}
+		}
+		if active == 0 {
+			problems = append(problems, fmt.Sprintf("step %s: has no active assignment", step.StepCode))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid workflow definition: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ServiceCode gốc (bản archive bị đổi tên ServiceCode)
+func baseCode(wf *model.WorkflowDefinition) string {
+	if wf.BaseServiceCode != "" {
+		return wf.BaseServiceCode
+	}
+	return wf.ServiceCode
+}
+
+// Mặc định quá hạn thì leo thang lên Trưởng phòng
+func timeoutActionOrDefault(action string) string {
+	if action == "" {
+		return model.TIMEOUT_ESCALATE
+	}
+	return action
+}
+
+func validateTimeoutActions(steps []model.WorkflowStep) error {
+	for _, step := range steps {
+		switch step.TimeoutAction {
+		case model.TIMEOUT_NONE, model.TIMEOUT_ESCALATE, model.TIMEOUT_AUTO_APPROVE, model.TIMEOUT_AUTO_REJECT:
+		default:
+			return fmt.Errorf("step %s: invalid timeout_action %s", step.StepCode, step.TimeoutAction)
+		}
+	}
+	return nil
+}
+
+// Mặc định 1 người duyệt là xong bước (giữ hành vi cũ)
+func completionPolicyOrDefault(policy string) string {
+	if policy == "" {
+		return model.COMPLETION_ANY
+	}
+	return policy
+}
+
+// Bước trống StepType (dữ liệu cũ / client chưa gửi) = APPROVAL
+func stepTypeOrDefault(stepType string) string {
+	if stepType == "" {
+		return model.STEP_TYPE_APPROVAL
+	}
+	return stepType
+}
+
+func validateStepTypes(steps []model.WorkflowStep) error {
+	blocking := 0
+	for _, step := range steps {
+		switch step.StepType {
+		case model.STEP_TYPE_APPROVAL:
+			blocking++
+		case model.STEP_TYPE_NOTIFY, model.STEP_TYPE_COUNTERSIGN:
+			if step.SubWorkflowCode != "" {
+				return fmt.Errorf("step %s: %s step cannot start a sub-workflow", step.StepCode, step.StepType)
+			}
+		default:
+			return fmt.Errorf("step %s: invalid step_type %s", step.StepCode, step.StepType)
+		}
+	}
+	if blocking == 0 {
+		return errors.New("workflow must have at least one APPROVAL step")
+	}
+	return nil
+}
+
+func validateCompletionPolicies(steps []model.WorkflowStep) error {
+	for _, step := range steps {
+		switch step.CompletionPolicy {
+		case model.COMPLETION_ANY, model.COMPLETION_ALL:
+		case model.COMPLETION_QUORUM:
+			if step.RequiredApprovals < 1 {
+				return fmt.Errorf("step %s: required_approvals must be at least 1 for QUORUM", step.StepCode)
+			}
+		default:
+			return fmt.Errorf("step %s: invalid completion_policy %s", step.StepCode, step.CompletionPolicy)
+		}
+	}
+	return nil
+}
+
+func validateAssignmentTypes(steps []model.WorkflowStep) error {
+	for _, step := range steps {
+		for _, assign := range step.Assignments {
+			if !repository.IsAssignmentTypeSupported(assign.AssignedType) {
+				return fmt.Errorf("step %s: unsupported assigned_type %s", step.StepCode, assign.AssignedType)
+			}
+			switch assign.AssignedType {
+			case model.ASSIGN_CREATOR_MANAGER, model.ASSIGN_DEPARTMENT_HEAD:
+				// Resolve theo người tạo/phòng ban của đơn, không cần AssignedIdentity
+			default:
+				if assign.AssignedIdentity == "" {
+					return fmt.Errorf("step %s: assigned_identity is required for %s", step.StepCode, assign.AssignedType)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateConditions(steps []model.WorkflowStep) error {
+	for _, step := range steps {
+		if err := repository.ValidateCondition(step.Condition); err != nil {
+			return fmt.Errorf("step %s: %w", step.StepCode, err)
+		}
+	}
+	return nil
+}
+
+func toModelCondition(c *dto.StepCondition) *model.StepCondition {
+	if c == nil {
+		return nil
+	}
+	res := &model.StepCondition{
+		Not:    toModelCondition(c.Not),
+		Field:  c.Field,
+		Op:     c.Op,
+		Value:  c.Value,
+		Values: c.Values,
+		Min:    c.Min,
+		Max:    c.Max,
+	}
+	for i := range c.All {
+		res.All = append(res.All, *toModelCondition(&c.All[i]))
+	}
+	for i := range c.Any {
+		res.Any = append(res.Any, *toModelCondition(&c.Any[i]))
+	}
+	return res
+}
+
+func toDTOCondition(c *model.StepCondition) *dto.StepCondition {
+	if c == nil {
+		return nil
+	}
+	res := &dto.StepCondition{
+		Not:    toDTOCondition(c.Not),
+		Field:  c.Field,
+		Op:     c.Op,
+		Value:  c.Value,
+		Values: c.Values,
+		Min:    c.Min,
+		Max:    c.Max,
+	}
+	for i := range c.All {
+		res.All = append(res.All, *toDTOCondition(&c.All[i]))
+	}
+	for i := range c.Any {
+		res.Any = append(res.Any, *toDTOCondition(&c.Any[i]))
+	}
+	return res
+}
+
+// So sánh 2 version theo StepCode
+func diffWorkflowVersions(prev, cur *model.WorkflowDefinition) *dto.WorkflowVersionDiff {
+	diff := &dto.WorkflowVersionDiff{
+		AddedSteps:   make([]string, 0),
+		RemovedSteps: make([]string, 0),
+		ChangedSteps: make([]dto.WorkflowStepDiff, 0),
+	}
+	prevSteps := make(map[string]*model.WorkflowStep, len(prev.Steps))
+	for i := range prev.Steps {
+		prevSteps[prev.Steps[i].StepCode] = &prev.Steps[i]
+	}
+
+	curSteps := append([]model.WorkflowStep(nil), cur.Steps...)
+	sort.Slice(curSteps, func(i, j int) bool { return curSteps[i].StepOrder < curSteps[j].StepOrder })
+	seen := make(map[string]bool, len(curSteps))
+	for i := range curSteps {
+		step := &curSteps[i]
+		seen[step.StepCode] = true
+		old, ok := prevSteps[step.StepCode]
+		if !ok {
+			diff.AddedSteps = append(diff.AddedSteps, step.StepCode)
+			continue
+		}
+		if changes := diffSteps(old, step); len(changes) > 0 {
+			diff.ChangedSteps = append(diff.ChangedSteps, dto.WorkflowStepDiff{StepCode: step.StepCode, Changes: changes})
+		}
+	}
+	for _, step := range prev.Steps {
+		if !seen[step.StepCode] {
+			diff.RemovedSteps = append(diff.RemovedSteps, step.StepCode)
+		}
+	}
+	sort.Strings(diff.RemovedSteps)
+	return diff
+}
+
+func diffSteps(a, b *model.WorkflowStep) []string {
+	changes := make([]string, 0)
+	field := func(name string, from, to interface{}) {
+		if fmt.Sprint(from) != fmt.Sprint(to) {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, from, to))
+		}
+	}
+	field("step_name", a.StepName, b.StepName)
+	field("step_order", a.StepOrder, b.StepOrder)
+	field("step_type", stepTypeOrDefault(a.StepType), stepTypeOrDefault(b.StepType))
+	field("required_role", a.RequiredRole, b.RequiredRole)
+	field("can_skip", a.Canskip, b.Canskip)
+	field("can_delegate", a.CanDelegate, b.CanDelegate)
+	field("require_comment", a.RequireComment, b.RequireComment)
+	field("time_hours", a.TimeHours, b.TimeHours)
+	field("timeout_action", a.TimeoutAction, b.TimeoutAction)
+	field("completion_policy", a.CompletionPolicy, b.CompletionPolicy)
+	field("required_approvals", a.RequiredApprovals, b.RequiredApprovals)
+	field("sub_workflow_code", a.SubWorkflowCode, b.SubWorkflowCode)
+
+	condA, _ := json.Marshal(a.Condition)
+	condB, _ := json.Marshal(b.Condition)
+	if string(condA) != string(condB) {
+		changes = append(changes, fmt.Sprintf("condition: %s -> %s", condA, condB))
+	}
+
+	assignA, assignB := assignmentKeys(a.Assignments), assignmentKeys(b.Assignments)
+	if strings.Join(assignA, ";") != strings.Join(assignB, ";") {
+		changes = append(changes, fmt.Sprintf("assignments: [%s] -> [%s]", strings.Join(assignA, "; "), strings.Join(assignB, "; ")))
+	}
+	return changes
+}
+
+// Mô tả rule gán dạng chuỗi đã sắp xếp để so sánh (bỏ qua ID)
+func assignmentKeys(assigns []model.WorkflowStepAssignment) []string {
+	keys := make([]string, 0, len(assigns))
+	for _, a := range assigns {
+		if !a.IsActive {
+			continue
+		}
+		key := a.AssignedType + ":" + a.AssignedIdentity
+		if a.FactoryID != nil {
+			key += fmt.Sprintf(" factory=%d", *a.FactoryID)
+		}
+		if len(a.DepartmentIDs) > 0 {
+			depts := append([]uint64(nil), a.DepartmentIDs...)
+			sort.Slice(depts, func(i, j int) bool { return depts[i] < depts[j] })
+			key += fmt.Sprintf(" depts=%v", depts)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"CQS-KYC/internal/dto"
+	"CQS-KYC/internal/model"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+	"gorm.io/gorm"
+)
+
+// Export quy trình thành bundle JSON/YAML (ID -> Code để Import được sang công ty khác)
+func (w *workflowService) Export(ctx context.Context, id uint64, format string) ([]byte, string, error) {
+	wf, err := w.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get workflow definition by id %w", err)
+	}
+
+	bundle := dto.WorkflowBundle{
+		FormatVersion: dto.WORKFLOW_BUNDLE_FORMAT,
+		ServiceCode:   baseCode(wf), // Bản đã archive bị đổi tên ServiceCode -> Xuất theo code gốc
+		Operation:     wf.Operation,
+		WorkflowName:  wf.WorkflowName,
+		Description:   wf.Description,
+		SourceVersion: wf.Version,
+		Steps:         make([]dto.WorkflowBundleStep, 0, len(wf.Steps)),
+	}
+
+	steps := append([]model.WorkflowStep{}, wf.Steps...)
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].StepOrder < steps[j].StepOrder })
+
+	problems := make([]string, 0)
+	for _, step := range steps {
+		item := dto.WorkflowBundleStep{
+			StepCode:          step.StepCode,
+			StepName:          step.StepName,
+			StepOrder:         step.StepOrder,
+			StepType:          stepTypeOrDefault(step.StepType),
+			RequiredRole:      step.RequiredRole,
+			Canskip:           step.Canskip,
+			CanDelegate:       step.CanDelegate,
+			RequireComment:    step.RequireComment,
+			TimeHours:         step.TimeHours,
+			TimeoutAction:     step.TimeoutAction,
+			Condition:         toDTOCondition(step.Condition),
+			CompletionPolicy:  step.CompletionPolicy,
+			RequiredApprovals: step.RequiredApprovals,
+			SubWorkflowCode:   step.SubWorkflowCode,
+			Assignments:       make([]dto.WorkflowBundleAssignment, 0, len(step.Assignments)),
+		}
+		for _, assign := range step.Assignments {
+			isActive := assign.IsActive
+			out := dto.WorkflowBundleAssignment{
+				AssignedType:     assign.AssignedType,
+				AssignedIdentity: assign.AssignedIdentity,
+				Priority:         assign.Priority,
+				IsActive:         &isActive,
+			}
+
+			if assign.AssignedType == model.ASSIGN_USER {
+				code, err := w.userCodeOf(ctx, assign.AssignedIdentity)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("step %s: %v", step.StepCode, err))
+				}
+				out.AssignedIdentity = code
+			}
+			for _, deptID := range assign.DepartmentIDs {
+				dept, err := w.departmentRepo.GetByID(ctx, deptID)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("step %s: department %d not found", step.StepCode, deptID))
+					continue
+				}
+				out.DepartmentCodes = append(out.DepartmentCodes, dept.Code)
+			}
+			if assign.FactoryID != nil && *assign.FactoryID != 0 {
+				factory, err := w.factoryRepo.GetByID(ctx, *assign.FactoryID)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("step %s: factory %d not found", step.StepCode, *assign.FactoryID))
+				} else {
+					out.FactoryCode = factory.Code
+				}
+			}
+			item.Assignments = append(item.Assignments, out)
+		}
+		bundle.Steps = append(bundle.Steps, item)
+	}
+	// Không xuất bundle thiếu tham chiếu (Import ở nơi khác sẽ sai người duyệt)
+	if len(problems) > 0 {
+		return nil, "", fmt.Errorf("cannot export workflow %d: %s", id, strings.Join(problems, "; "))
+	}
+
+	switch format {
+	case dto.BUNDLE_YAML:
+		data, err := yaml.Marshal(&bundle)
+		return data, dto.BUNDLE_YAML, err
+	case "", dto.BUNDLE_JSON:
+		data, err := json.MarshalIndent(&bundle, "", "  ")
+		return data, dto.BUNDLE_JSON, err
+	}
+	return nil, "", fmt.Errorf("unsupported bundle format %q", format)
+}
+
+// Import bundle: Code -> ID theo dữ liệu công ty hiện tại, kiểm tra như khi tạo/sửa,
+// ServiceCode đã có thì tạo version mới (workflowRepo.Update), chưa có thì tạo mới
+func (w *workflowService) Import(ctx context.Context, data []byte, format string) (*dto.WorkflowImportRes, error) {
+	bundle, err := decodeBundle(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.FormatVersion != dto.WORKFLOW_BUNDLE_FORMAT {
+		return nil, fmt.Errorf("unsupported bundle format_version %d, expected %d", bundle.FormatVersion, dto.WORKFLOW_BUNDLE_FORMAT)
+	}
+	if bundle.ServiceCode == "" {
+		return nil, errors.New("bundle service_code is required")
+	}
+	if bundle.WorkflowName == "" {
+		return nil, errors.New("bundle workflow_name is required")
+	}
+
+	steps, err := w.resolveBundleSteps(ctx, bundle.Steps)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.validateDefinition(ctx, bundle.ServiceCode, steps); err != nil {
+		return nil, err
+	}
+
+	wf := &model.WorkflowDefinition{
+		ServiceCode:  bundle.ServiceCode,
+		Operation:    bundle.Operation,
+		WorkflowName: bundle.WorkflowName,
+		Description:  bundle.Description,
+		IsActive:     true,
+		Steps:        steps,
+	}
+
+	existing, err := w.repo.FindByServiceCode(ctx, bundle.ServiceCode)
+	switch {
+	case err == nil:
+		if err := w.repo.Update(ctx, existing.ID, wf); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		wf.Version = 1
+		wf.BaseServiceCode = bundle.ServiceCode
+		if err := w.repo.Create(ctx, wf); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	current, err := w.repo.FindByServiceCode(ctx, bundle.ServiceCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload imported workflow: %w", err)
+	}
+	return &dto.WorkflowImportRes{
+		ID:          current.ID,
+		ServiceCode: current.ServiceCode,
+		Version:     current.Version,
+		Created:     existing == nil,
+	}, nil
+}
+
+// Code trong bundle -> ID của công ty hiện tại (gom tất cả lỗi để sửa 1 lần)
+func (w *workflowService) resolveBundleSteps(ctx context.Context, in []dto.WorkflowBundleStep) ([]model.WorkflowStep, error) {
+	deptIDs := make(map[string]uint64)
+	factoryIDs := make(map[string]uint64)
+	userIDs := make(map[string]string)
+	problems := make([]string, 0)
+
+	steps := make([]model.WorkflowStep, 0, len(in))
+	for _, step := range in {
+		wfStep := model.WorkflowStep{
+			StepCode:          step.StepCode,
+			StepName:          step.StepName,
+			StepOrder:         step.StepOrder,
+			StepType:          stepTypeOrDefault(step.StepType),
+			RequiredRole:      step.RequiredRole,
+			Canskip:           step.Canskip,
+			CanDelegate:       step.CanDelegate,
+			RequireComment:    step.RequireComment,
+			TimeHours:         step.TimeHours,
+			TimeoutAction:     timeoutActionOrDefault(step.TimeoutAction),
+			Condition:         toModelCondition(step.Condition),
+			CompletionPolicy:  completionPolicyOrDefault(step.CompletionPolicy),
+			RequiredApprovals: step.RequiredApprovals,
+			SubWorkflowCode:   step.SubWorkflowCode,
+			Assignments:       make([]model.WorkflowStepAssignment, 0, len(step.Assignments)),
+		}
+
+		for _, assign := range step.Assignments {
+			wfAssign := model.WorkflowStepAssignment{
+				AssignedType:     assign.AssignedType,
+				AssignedIdentity: assign.AssignedIdentity,
+				Priority:         assign.Priority,
+				IsActive:         assign.IsActive == nil || *assign.IsActive,
+			}
+
+			if assign.AssignedType == model.ASSIGN_USER && assign.AssignedIdentity != "" {
+				id, ok := userIDs[assign.AssignedIdentity]
+				if !ok {
+					if u, err := w.userRepo.GetByCode(ctx, assign.AssignedIdentity); err == nil {
+						id = strconv.FormatUint(u.ID, 10)
+					}
+					userIDs[assign.AssignedIdentity] = id
+				}
+				if id == "" {
+					problems = append(problems, fmt.Sprintf("step %s: user code %s not found", step.StepCode, assign.AssignedIdentity))
+				}
+				wfAssign.AssignedIdentity = id
+			}
+
+			for _, code := range assign.DepartmentCodes {
+				id, ok := deptIDs[code]
+				if !ok {
+					if dept, err := w.departmentRepo.GetByCode(ctx, code); err == nil {
+						id = dept.ID
+					}
+					deptIDs[code] = id
+				}
+				if id == 0 {
+					problems = append(problems, fmt.Sprintf("step %s: department code %s not found", step.StepCode, code))
+					continue
+				}
+				wfAssign.DepartmentIDs = append(wfAssign.DepartmentIDs, id)
+			}
+
+			if assign.FactoryCode != "" {
+				id, ok := factoryIDs[assign.FactoryCode]
+				if !ok {
+					if factory, err := w.factoryRepo.GetByCode(ctx, assign.FactoryCode); err == nil {
+						id = factory.ID
+					}
+					factoryIDs[assign.FactoryCode] = id
+				}
+				if id == 0 {
+					problems = append(problems, fmt.Sprintf("step %s: factory code %s not found", step.StepCode, assign.FactoryCode))
+				} else {
+					wfAssign.FactoryID = &id
+				}
+			}
+			wfStep.Assignments = append(wfStep.Assignments, wfAssign)
+		}
+		steps = append(steps, wfStep)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid workflow bundle: %s", strings.Join(problems, "; "))
+	}
+	return steps, nil
+}
+
+// UserID -> UserCode
+func (w *workflowService) userCodeOf(ctx context.Context, userID string) (string, error) {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("user id %q must be numeric", userID)
+	}
+	u, err := w.userRepo.GetByID(ctx, uid)
+	if err != nil {
+		return "", fmt.Errorf("user %s not found", userID)
+	}
+	return u.UserCode, nil
+}
+
+// Đọc bundle theo format; không truyền format thì đoán theo ký tự đầu ('{' = JSON)
+func decodeBundle(data []byte, format string) (*dto.WorkflowBundle, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New("bundle is empty")
+	}
+	if format == "" {
+		format = dto.BUNDLE_YAML
+		if trimmed[0] == '{' {
+			format = dto.BUNDLE_JSON
+		}
+	}
+
+	var bundle dto.WorkflowBundle
+	switch format {
+	case dto.BUNDLE_JSON:
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields() // Gõ sai tên field trong file -> báo lỗi thay vì bỏ qua
+		if err := dec.Decode(&bundle); err != nil {
+			return nil, fmt.Errorf("invalid json bundle: %w", err)
+		}
+	case dto.BUNDLE_YAML:
+		dec := yaml.NewDecoder(bytes.NewReader(trimmed))
+		dec.KnownFields(true)
+		if err := dec.Decode(&bundle); err != nil {
+			return nil, fmt.Errorf("invalid yaml bundle: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bundle format %q", format)
+	}
+	return &bundle, nil
+}
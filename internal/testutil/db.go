@@ -0,0 +1,30 @@
+package testutil
+
+import (
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DB SQLite trong bộ nhớ cho test, mỗi test 1 DB riêng (theo t.Name()), tự tạo bảng cho models.
+// Đóng khi test kết thúc -> SQLite xóa DB, chạy lại (-count) không trùng dữ liệu
+func NewDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}